@@ -18,6 +18,7 @@ import (
 	"github.com/syncthing/syncthing/internal/config"
 	"github.com/syncthing/syncthing/internal/events"
 	"github.com/syncthing/syncthing/internal/model"
+	"github.com/syncthing/syncthing/internal/sync"
 	"github.com/thejerf/suture"
 )
 
@@ -30,6 +31,14 @@ type connectionSvc struct {
 	model  *model.Model
 	tlsCfg *tls.Config
 	conns  chan *tls.Conn
+
+	limiters    map[protocol.DeviceID]deviceLimiters
+	limitersMut sync.Mutex
+}
+
+// The per device limits, applied in addition to the global ones.
+type deviceLimiters struct {
+	send, recv *limiter
 }
 
 func newConnectionSvc(cfg *config.Wrapper, myID protocol.DeviceID, model *model.Model, tlsCfg *tls.Config) *connectionSvc {
@@ -40,6 +49,9 @@ func newConnectionSvc(cfg *config.Wrapper, myID protocol.DeviceID, model *model.
 		model:      model,
 		tlsCfg:     tlsCfg,
 		conns:      make(chan *tls.Conn),
+
+		limiters:    make(map[protocol.DeviceID]deviceLimiters),
+		limitersMut: sync.NewMutex(),
 	}
 
 	// There are several moving parts here; one routine per listening address
@@ -86,6 +98,7 @@ func newConnectionSvc(cfg *config.Wrapper, myID protocol.DeviceID, model *model.
 		svc.Add(listener)
 	}
 	svc.Add(serviceFunc(svc.handle))
+	svc.Add(serviceFunc(svc.applyLimits))
 
 	return svc
 }
@@ -138,6 +151,12 @@ next:
 
 		for deviceID, deviceCfg := range s.cfg.Devices() {
 			if deviceID == remoteID {
+				if deviceCfg.Paused || s.model.ScheduledSettings().PausedDevices[remoteID] {
+					l.Infof("Connection from paused device %s", remoteID)
+					conn.Close()
+					continue next
+				}
+
 				// Verify the name on the certificate. By default we set it to
 				// "syncthing" when generating, but the user may have replaced
 				// the certificate and used another name.
@@ -161,15 +180,22 @@ next:
 				limit := s.shouldLimit(conn.RemoteAddr())
 
 				wr := io.Writer(conn)
-				if limit && writeRateLimit != nil {
+				if limit {
 					wr = &limitedWriter{conn, writeRateLimit}
 				}
 
 				rd := io.Reader(conn)
-				if limit && readRateLimit != nil {
+				if limit {
 					rd = &limitedReader{conn, readRateLimit}
 				}
 
+				// The device's own limits apply on top of the global ones,
+				// regardless of whether it's on the LAN.
+
+				devLimits := s.deviceLimiters(deviceCfg)
+				wr = &limitedWriter{wr, devLimits.send}
+				rd = &limitedReader{rd, devLimits.recv}
+
 				name := fmt.Sprintf("%s-%s", conn.LocalAddr(), conn.RemoteAddr())
 				protoConn := protocol.NewConnection(remoteID, rd, wr, s.model, name, deviceCfg.Compression)
 
@@ -240,9 +266,10 @@ func (s *connectionSvc) listen(addr string) {
 func (s *connectionSvc) connect() {
 	delay := time.Second
 	for {
+		scheduled := s.model.ScheduledSettings()
 	nextDevice:
 		for deviceID, deviceCfg := range s.cfg.Devices() {
-			if deviceID == myID {
+			if deviceID == myID || deviceCfg.Paused || scheduled.PausedDevices[deviceID] {
 				continue
 			}
 
@@ -317,6 +344,41 @@ func (s *connectionSvc) connect() {
 	}
 }
 
+// applyLimits keeps the rate limits in line with the schedules.
+func (s *connectionSvc) applyLimits() {
+	sub := events.Default.Subscribe(events.ScheduleChanged)
+	defer events.Default.Unsubscribe(sub)
+
+	s.setLimits()
+	for range sub.C() {
+		s.setLimits()
+	}
+}
+
+func (s *connectionSvc) setLimits() {
+	opts := s.cfg.Options()
+	scheduled := s.model.ScheduledSettings()
+	writeRateLimit.setRate(scheduled.SendKbps(opts.MaxSendKbps))
+	readRateLimit.setRate(scheduled.RecvKbps(opts.MaxRecvKbps))
+}
+
+// deviceLimiters returns the limiters for the given device, creating them as
+// necessary.
+func (s *connectionSvc) deviceLimiters(cfg config.DeviceConfiguration) deviceLimiters {
+	s.limitersMut.Lock()
+	defer s.limitersMut.Unlock()
+
+	lims, ok := s.limiters[cfg.DeviceID]
+	if !ok {
+		lims = deviceLimiters{
+			send: newLimiter(cfg.MaxSendKbps),
+			recv: newLimiter(cfg.MaxRecvKbps),
+		}
+		s.limiters[cfg.DeviceID] = lims
+	}
+	return lims
+}
+
 func (*connectionSvc) setTCPOptions(conn *net.TCPConn) {
 	var err error
 	if err = conn.SetLinger(0); err != nil {
@@ -355,18 +417,37 @@ func (s *connectionSvc) VerifyConfiguration(from, to config.Configuration) error
 }
 
 func (s *connectionSvc) CommitConfiguration(from, to config.Configuration) bool {
-	// We require a restart if a device as been removed.
+	// We require a restart if a device as been removed. Its limiters are
+	// forgotten regardless.
 
 	newDevices := make(map[protocol.DeviceID]bool, len(to.Devices))
 	for _, dev := range to.Devices {
 		newDevices[dev.DeviceID] = true
 	}
 
+	removed := false
+	s.limitersMut.Lock()
 	for _, dev := range from.Devices {
 		if !newDevices[dev.DeviceID] {
-			return false
+			delete(s.limiters, dev.DeviceID)
+			removed = true
+		}
+	}
+	s.limitersMut.Unlock()
+	if removed {
+		return false
+	}
+
+	// Changed device limits apply to the existing connections.
+
+	s.limitersMut.Lock()
+	for _, dev := range to.Devices {
+		if lims, ok := s.limiters[dev.DeviceID]; ok {
+			lims.send.setRate(dev.MaxSendKbps)
+			lims.recv.setRate(dev.MaxRecvKbps)
 		}
 	}
+	s.limitersMut.Unlock()
 
 	return true
 }
@@ -131,44 +131,62 @@ func (s *apiSvc) Serve() {
 
 	// The GET handlers
 	getRestMux := http.NewServeMux()
-	getRestMux.HandleFunc("/rest/db/completion", s.getDBCompletion)              // device folder
-	getRestMux.HandleFunc("/rest/db/file", s.getDBFile)                          // folder file
-	getRestMux.HandleFunc("/rest/db/ignores", s.getDBIgnores)                    // folder
-	getRestMux.HandleFunc("/rest/db/need", s.getDBNeed)                          // folder [perpage] [page]
-	getRestMux.HandleFunc("/rest/db/status", s.getDBStatus)                      // folder
-	getRestMux.HandleFunc("/rest/db/browse", s.getDBBrowse)                      // folder [prefix] [dirsonly] [levels]
-	getRestMux.HandleFunc("/rest/events", s.getEvents)                           // since [limit]
-	getRestMux.HandleFunc("/rest/stats/device", s.getDeviceStats)                // -
-	getRestMux.HandleFunc("/rest/stats/folder", s.getFolderStats)                // -
-	getRestMux.HandleFunc("/rest/svc/deviceid", s.getDeviceID)                   // id
-	getRestMux.HandleFunc("/rest/svc/lang", s.getLang)                           // -
-	getRestMux.HandleFunc("/rest/svc/report", s.getReport)                       // -
-	getRestMux.HandleFunc("/rest/system/browse", s.getSystemBrowse)              // current
-	getRestMux.HandleFunc("/rest/system/config", s.getSystemConfig)              // -
-	getRestMux.HandleFunc("/rest/system/config/insync", s.getSystemConfigInsync) // -
-	getRestMux.HandleFunc("/rest/system/connections", s.getSystemConnections)    // -
-	getRestMux.HandleFunc("/rest/system/discovery", s.getSystemDiscovery)        // -
-	getRestMux.HandleFunc("/rest/system/error", s.getSystemError)                // -
-	getRestMux.HandleFunc("/rest/system/ping", s.restPing)                       // -
-	getRestMux.HandleFunc("/rest/system/status", s.getSystemStatus)              // -
-	getRestMux.HandleFunc("/rest/system/upgrade", s.getSystemUpgrade)            // -
-	getRestMux.HandleFunc("/rest/system/version", s.getSystemVersion)            // -
+	getRestMux.HandleFunc("/rest/db/completion", s.getDBCompletion)                // device folder
+	getRestMux.HandleFunc("/rest/db/conflicts", s.getDBConflicts)                  // folder
+	getRestMux.HandleFunc("/rest/db/deletions", s.getDBDeletions)                  // folder
+	getRestMux.HandleFunc("/rest/db/failures", s.getDBFailures)                    // folder
+	getRestMux.HandleFunc("/rest/db/file", s.getDBFile)                            // folder file
+	getRestMux.HandleFunc("/rest/db/ignores", s.getDBIgnores)                      // folder
+	getRestMux.HandleFunc("/rest/db/localchanged", s.getDBLocalChanged)            // folder
+	getRestMux.HandleFunc("/rest/db/need", s.getDBNeed)                            // folder [perpage] [page]
+	getRestMux.HandleFunc("/rest/db/override", s.getDBOverride)                    // folder
+	getRestMux.HandleFunc("/rest/db/plan", s.getDBPlan)                            // folder
+	getRestMux.HandleFunc("/rest/db/status", s.getDBStatus)                        // folder
+	getRestMux.HandleFunc("/rest/db/browse", s.getDBBrowse)                        // folder [prefix] [dirsonly] [levels]
+	getRestMux.HandleFunc("/rest/events", s.getEvents)                             // since [limit]
+	getRestMux.HandleFunc("/rest/folder/versions", s.getFolderVersions)            // folder [prefix]
+	getRestMux.HandleFunc("/rest/folder/versions/stats", s.getFolderVersionsStats) // folder
+	getRestMux.HandleFunc("/rest/stats/device", s.getDeviceStats)                  // -
+	getRestMux.HandleFunc("/rest/stats/folder", s.getFolderStats)                  // -
+	getRestMux.HandleFunc("/rest/svc/deviceid", s.getDeviceID)                     // id
+	getRestMux.HandleFunc("/rest/svc/lang", s.getLang)                             // -
+	getRestMux.HandleFunc("/rest/svc/report", s.getReport)                         // -
+	getRestMux.HandleFunc("/rest/system/browse", s.getSystemBrowse)                // current
+	getRestMux.HandleFunc("/rest/system/config", s.getSystemConfig)                // -
+	getRestMux.HandleFunc("/rest/system/config/insync", s.getSystemConfigInsync)   // -
+	getRestMux.HandleFunc("/rest/system/connections", s.getSystemConnections)      // -
+	getRestMux.HandleFunc("/rest/system/discovery", s.getSystemDiscovery)          // -
+	getRestMux.HandleFunc("/rest/system/error", s.getSystemError)                  // -
+	getRestMux.HandleFunc("/rest/system/ping", s.restPing)                         // -
+	getRestMux.HandleFunc("/rest/system/status", s.getSystemStatus)                // -
+	getRestMux.HandleFunc("/rest/system/upgrade", s.getSystemUpgrade)              // -
+	getRestMux.HandleFunc("/rest/system/version", s.getSystemVersion)              // -
 
 	// The POST handlers
 	postRestMux := http.NewServeMux()
-	postRestMux.HandleFunc("/rest/db/prio", s.postDBPrio)                      // folder file [perpage] [page]
-	postRestMux.HandleFunc("/rest/db/ignores", s.postDBIgnores)                // folder
-	postRestMux.HandleFunc("/rest/db/override", s.postDBOverride)              // folder
-	postRestMux.HandleFunc("/rest/db/scan", s.postDBScan)                      // folder [sub...] [delay]
-	postRestMux.HandleFunc("/rest/system/config", s.postSystemConfig)          // <body>
-	postRestMux.HandleFunc("/rest/system/discovery", s.postSystemDiscovery)    // device addr
-	postRestMux.HandleFunc("/rest/system/error", s.postSystemError)            // <body>
-	postRestMux.HandleFunc("/rest/system/error/clear", s.postSystemErrorClear) // -
-	postRestMux.HandleFunc("/rest/system/ping", s.restPing)                    // -
-	postRestMux.HandleFunc("/rest/system/reset", s.postSystemReset)            // [folder]
-	postRestMux.HandleFunc("/rest/system/restart", s.postSystemRestart)        // -
-	postRestMux.HandleFunc("/rest/system/shutdown", s.postSystemShutdown)      // -
-	postRestMux.HandleFunc("/rest/system/upgrade", s.postSystemUpgrade)        // -
+	postRestMux.HandleFunc("/rest/db/conflicts", s.postDBConflicts)                      // folder copy keep [name] [copyname]
+	postRestMux.HandleFunc("/rest/db/deletions", s.postDBDeletions)                      // folder action
+	postRestMux.HandleFunc("/rest/db/failures", s.postDBFailures)                        // folder item action
+	postRestMux.HandleFunc("/rest/db/prio", s.postDBPrio)                                // folder file [perpage] [page]
+	postRestMux.HandleFunc("/rest/db/ignores", s.postDBIgnores)                          // folder
+	postRestMux.HandleFunc("/rest/db/override", s.postDBOverride)                        // folder
+	postRestMux.HandleFunc("/rest/db/pause", s.postDBPause)                              // folder
+	postRestMux.HandleFunc("/rest/db/resume", s.postDBResume)                            // folder
+	postRestMux.HandleFunc("/rest/db/revert", s.postDBRevert)                            // folder
+	postRestMux.HandleFunc("/rest/db/scan", s.postDBScan)                                // folder [sub...] [delay]
+	postRestMux.HandleFunc("/rest/db/verify", s.postDBVerify)                            // folder
+	postRestMux.HandleFunc("/rest/folder/versions/restore", s.postFolderVersionsRestore) // folder file version
+	postRestMux.HandleFunc("/rest/system/config", s.postSystemConfig)                    // <body>
+	postRestMux.HandleFunc("/rest/system/discovery", s.postSystemDiscovery)              // device addr
+	postRestMux.HandleFunc("/rest/system/error", s.postSystemError)                      // <body>
+	postRestMux.HandleFunc("/rest/system/error/clear", s.postSystemErrorClear)           // -
+	postRestMux.HandleFunc("/rest/system/pause", s.postSystemPause)                      // device
+	postRestMux.HandleFunc("/rest/system/ping", s.restPing)                              // -
+	postRestMux.HandleFunc("/rest/system/reset", s.postSystemReset)                      // [folder]
+	postRestMux.HandleFunc("/rest/system/restart", s.postSystemRestart)                  // -
+	postRestMux.HandleFunc("/rest/system/resume", s.postSystemResume)                    // device
+	postRestMux.HandleFunc("/rest/system/shutdown", s.postSystemShutdown)                // -
+	postRestMux.HandleFunc("/rest/system/upgrade", s.postSystemUpgrade)                  // -
 
 	// Debug endpoints, not for general use
 	getRestMux.HandleFunc("/rest/debug/peerCompletion", s.getPeerCompletion)
@@ -181,6 +199,7 @@ func (s *apiSvc) Serve() {
 	mux := http.NewServeMux()
 	mux.Handle("/rest/", restMux)
 	mux.HandleFunc("/qr/", s.getQR)
+	mux.HandleFunc("/files/", s.getFiles)
 
 	// Serve compiled in assets unless an asset directory was set (for development)
 	mux.Handle("/", embeddedStatic{
@@ -428,6 +447,10 @@ func folderSummary(m *model.Model, folder string) map[string]interface{} {
 		res["error"] = err.Error()
 	}
 
+	if progress, ok := m.ScanProgress(folder); ok {
+		res["scanProgress"] = progress
+	}
+
 	lv, _ := m.CurrentLocalVersion(folder)
 	rv, _ := m.RemoteLocalVersion(folder)
 
@@ -451,6 +474,112 @@ func (s *apiSvc) postDBOverride(w http.ResponseWriter, r *http.Request) {
 	go s.model.Override(folder)
 }
 
+func (s *apiSvc) postDBRevert(w http.ResponseWriter, r *http.Request) {
+	var qs = r.URL.Query()
+	var folder = qs.Get("folder")
+	go s.model.Revert(folder)
+}
+
+func (s *apiSvc) getDBLocalChanged(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	folder := qs.Get("folder")
+
+	files := s.model.LocalChangedFiles(folder)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"files": s.toNeedSlice(files),
+	})
+}
+
+func (s *apiSvc) getDBConflicts(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	folder := qs.Get("folder")
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"conflicts": s.model.Conflicts(folder),
+	})
+}
+
+func (s *apiSvc) postDBConflicts(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	err := s.model.ResolveConflict(qs.Get("folder"), qs.Get("copy"), qs.Get("keep"), qs.Get("name"), qs.Get("copyname"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
+
+func (s *apiSvc) getDBDeletions(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	folder := qs.Get("folder")
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deletions": s.model.PendingDeletions(folder),
+	})
+}
+
+func (s *apiSvc) postDBDeletions(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	folder := qs.Get("folder")
+
+	var err error
+	switch action := qs.Get("action"); action {
+	case "approve":
+		err = s.model.ApproveDeletions(folder)
+	case "reject":
+		err = s.model.RejectDeletions(folder)
+	default:
+		err = fmt.Errorf("unknown action %q", action)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
+
+func (s *apiSvc) getDBFailures(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	folder := qs.Get("folder")
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"failures": s.model.FailedItems(folder),
+	})
+}
+
+func (s *apiSvc) postDBFailures(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	err := s.model.ActOnFailedItem(qs.Get("folder"), qs.Get("item"), qs.Get("action"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
+
+func (s *apiSvc) getDBPlan(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	plan, err := s.model.PullPlan(qs.Get("folder"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(plan)
+}
+
+func (s *apiSvc) getDBOverride(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	plan, err := s.model.OverridePlan(qs.Get("folder"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(plan)
+}
+
 func (s *apiSvc) getDBNeed(w http.ResponseWriter, r *http.Request) {
 	qs := r.URL.Query()
 
@@ -563,6 +692,64 @@ func (s *apiSvc) postSystemConfig(w http.ResponseWriter, r *http.Request) {
 	cfg.Save()
 }
 
+func (s *apiSvc) postSystemPause(w http.ResponseWriter, r *http.Request) {
+	s.setDevicePaused(w, r, true)
+}
+
+func (s *apiSvc) postSystemResume(w http.ResponseWriter, r *http.Request) {
+	s.setDevicePaused(w, r, false)
+}
+
+func (s *apiSvc) setDevicePaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	s.systemConfigMut.Lock()
+	defer s.systemConfigMut.Unlock()
+
+	var qs = r.URL.Query()
+	device, err := protocol.DeviceIDFromString(qs.Get("device"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	devCfg, ok := cfg.Devices()[device]
+	if !ok {
+		http.Error(w, "Unknown device", 500)
+		return
+	}
+
+	devCfg.Paused = paused
+	if resp := cfg.SetDevice(devCfg); resp.RequiresRestart {
+		configInSync = false
+	}
+	cfg.Save()
+}
+
+func (s *apiSvc) postDBPause(w http.ResponseWriter, r *http.Request) {
+	s.setFolderPaused(w, r, true)
+}
+
+func (s *apiSvc) postDBResume(w http.ResponseWriter, r *http.Request) {
+	s.setFolderPaused(w, r, false)
+}
+
+func (s *apiSvc) setFolderPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	s.systemConfigMut.Lock()
+	defer s.systemConfigMut.Unlock()
+
+	var qs = r.URL.Query()
+	folderCfg, ok := cfg.Folders()[qs.Get("folder")]
+	if !ok {
+		http.Error(w, "Invalid folder ID", 500)
+		return
+	}
+
+	folderCfg.Paused = paused
+	if resp := cfg.SetFolder(folderCfg); resp.RequiresRestart {
+		configInSync = false
+	}
+	cfg.Save()
+}
+
 func (s *apiSvc) getSystemConfigInsync(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	json.NewEncoder(w).Encode(map[string]bool{"configInSync": configInSync})
@@ -736,6 +923,44 @@ func (s *apiSvc) postDBIgnores(w http.ResponseWriter, r *http.Request) {
 	s.getDBIgnores(w, r)
 }
 
+func (s *apiSvc) getFolderVersions(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	versions, err := s.model.FolderVersions(qs.Get("folder"), qs.Get("prefix"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(versions)
+}
+
+func (s *apiSvc) getFolderVersionsStats(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	stats, err := s.model.FolderArchiveStats(qs.Get("folder"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(stats)
+}
+
+func (s *apiSvc) postFolderVersionsRestore(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	versionTime, err := time.Parse(time.RFC3339, qs.Get("version"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	err = s.model.RestoreFolderVersion(qs.Get("folder"), qs.Get("file"), versionTime)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
+
 func (s *apiSvc) getEvents(w http.ResponseWriter, r *http.Request) {
 	qs := r.URL.Query()
 	sinceStr := qs.Get("since")
@@ -829,6 +1054,14 @@ func (s *apiSvc) postSystemUpgrade(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (s *apiSvc) postDBVerify(w http.ResponseWriter, r *http.Request) {
+	folder := r.URL.Query().Get("folder")
+	// Verifying may take hours; the outcome is logged and reported as events.
+	if err := s.model.StartVerifyFolder(folder); err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
+
 func (s *apiSvc) postDBScan(w http.ResponseWriter, r *http.Request) {
 	qs := r.URL.Query()
 	folder := qs.Get("folder")
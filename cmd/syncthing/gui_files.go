@@ -0,0 +1,144 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"html/template"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/db"
+)
+
+// The file server serves the files in the global state of the folders under
+// /files/<folder>/<path>, whether we have them locally or not. Directories
+// are listed and files are read from local blocks where possible and
+// otherwise pulled from the connected devices as they are read.
+//
+// The files come from other devices and are served from the origin of the
+// GUI, so they are always served as downloads and sandboxed, lest a page or
+// image with scripts in it gets to use the REST API.
+
+var fileListing = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Folder}}/{{.Dir}}</title>
+</head>
+<body>
+<h1>{{.Folder}}/{{.Dir}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Modified</th></tr>
+{{if .Dir}}<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{.Href}}">{{.Name}}</a></td><td>{{if not .IsDir}}{{.Size}}{{end}}</td><td>{{.Modified.Format "2006-01-02 15:04:05"}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+type fileListingEntry struct {
+	Name     string
+	Href     string
+	Size     int64
+	Modified time.Time
+	IsDir    bool
+}
+
+func (s *apiSvc) getFiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, "/files/")
+	slash := strings.Index(rest, "/")
+	if slash < 0 {
+		http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+		return
+	}
+	folder := rest[:slash]
+	name := strings.TrimPrefix(path.Clean("/"+rest[slash:]), "/")
+
+	if strings.HasSuffix(rest, "/") {
+		s.listFiles(w, folder, name)
+		return
+	}
+
+	fr, err := s.model.NewGlobalFileReader(folder, name)
+	if err != nil {
+		if _, ok := s.model.GlobalDirectory(folder, name); ok {
+			http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+
+	// ServeContent takes care of range requests.
+	file := fr.File()
+	base := filepath.Base(file.Name)
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": base})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Disposition", disposition)
+	setSandboxHeaders(w)
+	http.ServeContent(w, r, base, time.Unix(file.Modified, 0), fr)
+}
+
+// setSandboxHeaders keeps the browser from treating the response as active
+// content of the GUI.
+func setSandboxHeaders(w http.ResponseWriter) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+}
+
+func (s *apiSvc) listFiles(w http.ResponseWriter, folder, dir string) {
+	files, ok := s.model.GlobalDirectory(folder, dir)
+	if !ok {
+		http.Error(w, "404 page not found", http.StatusNotFound)
+		return
+	}
+
+	entries := make([]fileListingEntry, 0, len(files))
+	for _, f := range files {
+		entries = append(entries, listingEntry(f))
+	}
+
+	if dir != "" {
+		dir += "/"
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	setSandboxHeaders(w)
+	fileListing.Execute(w, map[string]interface{}{
+		"Folder":  folder,
+		"Dir":     dir,
+		"Entries": entries,
+	})
+}
+
+func listingEntry(f db.FileInfoTruncated) fileListingEntry {
+	name := filepath.Base(f.Name)
+	e := fileListingEntry{
+		Name:     name,
+		Href:     (&url.URL{Path: name}).String(),
+		Size:     f.Size(),
+		Modified: time.Unix(f.Modified, 0),
+		IsDir:    f.IsDirectory() && !f.IsSymlink(),
+	}
+	if e.IsDir {
+		e.Name += "/"
+		e.Href += "/"
+	}
+	return e
+}
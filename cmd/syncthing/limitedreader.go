@@ -8,19 +8,17 @@ package main
 
 import (
 	"io"
-
-	"github.com/juju/ratelimit"
 )
 
 type limitedReader struct {
-	r      io.Reader
-	bucket *ratelimit.Bucket
+	r       io.Reader
+	limiter *limiter
 }
 
 func (r *limitedReader) Read(buf []byte) (int, error) {
 	n, err := r.r.Read(buf)
-	if r.bucket != nil {
-		r.bucket.Wait(int64(n))
+	if r.limiter != nil {
+		r.limiter.wait(n)
 	}
 	return n, err
 }
@@ -8,18 +8,16 @@ package main
 
 import (
 	"io"
-
-	"github.com/juju/ratelimit"
 )
 
 type limitedWriter struct {
-	w      io.Writer
-	bucket *ratelimit.Bucket
+	w       io.Writer
+	limiter *limiter
 }
 
 func (w *limitedWriter) Write(buf []byte) (int, error) {
-	if w.bucket != nil {
-		w.bucket.Wait(int64(len(buf)))
+	if w.limiter != nil {
+		w.limiter.wait(len(buf))
 	}
 	return w.w.Write(buf)
 }
@@ -0,0 +1,58 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"github.com/juju/ratelimit"
+	"github.com/syncthing/syncthing/internal/sync"
+)
+
+// A limiter is a rate limit shared between connections, that can be changed
+// while they are in use.
+type limiter struct {
+	kbps   int
+	bucket *ratelimit.Bucket
+	mut    sync.Mutex
+}
+
+func newLimiter(kbps int) *limiter {
+	lim := &limiter{
+		mut: sync.NewMutex(),
+	}
+	lim.setRate(kbps)
+	return lim
+}
+
+// setRate sets the limit in kilobytes per second. Zero or less is no limit.
+func (lim *limiter) setRate(kbps int) {
+	if kbps < 0 {
+		kbps = 0
+	}
+
+	lim.mut.Lock()
+	defer lim.mut.Unlock()
+
+	if kbps == lim.kbps && (kbps == 0) == (lim.bucket == nil) {
+		return
+	}
+	lim.kbps = kbps
+	if kbps == 0 {
+		lim.bucket = nil
+		return
+	}
+	lim.bucket = ratelimit.NewBucketWithRate(float64(1000*kbps), int64(5*1000*kbps))
+}
+
+func (lim *limiter) wait(n int) {
+	lim.mut.Lock()
+	bucket := lim.bucket
+	lim.mut.Unlock()
+
+	if bucket != nil {
+		bucket.Wait(int64(n))
+	}
+}
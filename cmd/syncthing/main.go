@@ -26,7 +26,6 @@ import (
 	"time"
 
 	"github.com/calmh/logger"
-	"github.com/juju/ratelimit"
 	"github.com/syncthing/protocol"
 	"github.com/syncthing/syncthing/internal/config"
 	"github.com/syncthing/syncthing/internal/db"
@@ -105,8 +104,8 @@ var (
 	myID           protocol.DeviceID
 	confDir        string
 	logFlags       = log.Ltime
-	writeRateLimit *ratelimit.Bucket
-	readRateLimit  *ratelimit.Bucket
+	writeRateLimit *limiter
+	readRateLimit  *limiter
 	stop           = make(chan int)
 	discoverer     *discover.Discoverer
 	cert           tls.Certificate
@@ -154,9 +153,11 @@ are mostly useful for developers. Use with care.
                  - "net"      (the main package; connections & network messages)
                  - "model"    (the model package)
                  - "scanner"  (the scanner package)
+                 - "schedule" (the schedule package)
                  - "stats"    (the stats package)
                  - "suture"   (the suture package; service management)
                  - "upnp"     (the upnp package)
+                 - "watcher"  (the watcher package)
                  - "xdr"      (the xdr package)
                  - "all"      (all of the above)
 
@@ -568,14 +569,12 @@ func syncthingMain() {
 	protocol.PingTimeout = time.Duration(opts.PingTimeoutS) * time.Second
 	protocol.PingIdleTime = time.Duration(opts.PingIdleTimeS) * time.Second
 
-	if opts.MaxSendKbps > 0 {
-		writeRateLimit = ratelimit.NewBucketWithRate(float64(1000*opts.MaxSendKbps), int64(5*1000*opts.MaxSendKbps))
-	}
-	if opts.MaxRecvKbps > 0 {
-		readRateLimit = ratelimit.NewBucketWithRate(float64(1000*opts.MaxRecvKbps), int64(5*1000*opts.MaxRecvKbps))
-	}
+	// The limits are adjusted by the connection service as schedules
+	// change.
+	writeRateLimit = newLimiter(opts.MaxSendKbps)
+	readRateLimit = newLimiter(opts.MaxRecvKbps)
 
-	if (opts.MaxRecvKbps > 0 || opts.MaxSendKbps > 0) && !opts.LimitBandwidthInLan {
+	if (opts.MaxRecvKbps > 0 || opts.MaxSendKbps > 0 || len(cfg.Raw().Schedules) > 0) && !opts.LimitBandwidthInLan {
 		lans, _ = osutil.GetLans()
 		networks := make([]string, 0, len(lans))
 		for _, lan := range lans {
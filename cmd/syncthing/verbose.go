@@ -60,7 +60,7 @@ func (s *verboseSvc) WaitForStart() {
 
 func (s *verboseSvc) formatEvent(ev events.Event) string {
 	switch ev.Type {
-	case events.Ping, events.DownloadProgress:
+	case events.Ping, events.DownloadProgress, events.FolderScanProgress:
 		// Skip
 		return ""
 
@@ -123,6 +123,39 @@ func (s *verboseSvc) formatEvent(ev events.Event) string {
 		delete(sum, "ignorePatterns")
 		delete(sum, "stateChanged")
 		return fmt.Sprintf("Summary for folder %q is %v", data["folder"], data["summary"])
+	case events.FolderWatchStateChanged:
+		data := ev.Data.(map[string]interface{})
+		return fmt.Sprintf("Watcher for folder %q is now %v", data["folder"], data["to"])
+	case events.ItemConflict:
+		data := ev.Data.(map[string]interface{})
+		return fmt.Sprintf("Conflict on %q in folder %q resolved: %v", data["item"], data["folder"], data["resolution"])
+	case events.FolderOutOfDiskSpace:
+		data := ev.Data.(map[string]interface{})
+		return fmt.Sprintf("Pulling stopped in folder %q: %v", data["folder"], data["error"])
+	case events.FolderPaused:
+		data := ev.Data.(map[string]interface{})
+		return fmt.Sprintf("Folder %q paused", data["folder"])
+	case events.FolderResumed:
+		data := ev.Data.(map[string]interface{})
+		return fmt.Sprintf("Folder %q resumed", data["folder"])
+	case events.DevicePaused:
+		data := ev.Data.(map[string]interface{})
+		return fmt.Sprintf("Device %v paused", data["device"])
+	case events.DeviceResumed:
+		data := ev.Data.(map[string]interface{})
+		return fmt.Sprintf("Device %v resumed", data["device"])
+	case events.ScheduleChanged:
+		data := ev.Data.(map[string]interface{})
+		return fmt.Sprintf("Schedule changed: send limit %v kbps, receive limit %v kbps, pullers %v, paused folders %v, paused devices %v", data["maxSendKbps"], data["maxRecvKbps"], data["pullers"], data["pausedFolders"], data["pausedDevices"])
+	case events.FolderDeletionsPending:
+		data := ev.Data.(map[string]interface{})
+		return fmt.Sprintf("Folder %q has %v deletions pending approval", data["folder"], data["count"])
+	case events.ItemFailed:
+		data := ev.Data.(map[string]interface{})
+		return fmt.Sprintf("Failed to sync %q / %q (%v times): %v", data["folder"], data["item"], data["count"], data["error"])
+	case events.ItemCorrupted:
+		data := ev.Data.(map[string]interface{})
+		return fmt.Sprintf("Found %q / %q corrupted (%v blocks damaged, repaired: %v)", data["folder"], data["item"], data["blocks"], data["repaired"])
 	}
 
 	return fmt.Sprintf("%s %#v", ev.Type, ev)
@@ -267,6 +267,13 @@ angular.module('syncthing.core')
             $scope.model[data.folder] = data.summary;
         });
 
+        $scope.$on(Events.FOLDER_SCAN_PROGRESS, function (event, arg) {
+            var data = arg.data;
+            if ($scope.model[data.folder]) {
+                $scope.model[data.folder].scanProgress = data;
+            }
+        });
+
         $scope.$on(Events.FOLDER_COMPLETION, function (event, arg) {
             var data = arg.data;
             if (!$scope.completion[data.device]) {
@@ -577,10 +584,10 @@ angular.module('syncthing.core')
             if (status === 'unknown') {
                 return 'info';
             }
-            if (status === 'unshared') {
+            if (status === 'unshared' || status === 'paused') {
                 return 'warning';
             }
-            if (status === 'stopped' || status === 'outofsync' || status === 'error') {
+            if (status === 'stopped' || status === 'outofsync' || status === 'error' || status === 'outOfDiskSpace' || status === 'deletionsPending') {
                 return 'danger';
             }
 
@@ -599,6 +606,19 @@ angular.module('syncthing.core')
             return Math.floor(pct);
         };
 
+        $scope.scanPercentage = function (folder) {
+            if (typeof $scope.model[folder] === 'undefined' || !$scope.model[folder].scanProgress) {
+                return 0;
+            }
+            var progress = $scope.model[folder].scanProgress;
+            if (progress.bytesDiscovered === 0) {
+                return 0;
+            }
+
+            var pct = 100 * progress.bytesHashed / progress.bytesDiscovered;
+            return Math.floor(pct);
+        };
+
         $scope.deviceIcon = function (deviceCfg) {
             if ($scope.connections[deviceCfg.deviceID]) {
                 if ($scope.completion[deviceCfg.deviceID] && $scope.completion[deviceCfg.deviceID]._total === 100) {
@@ -1018,8 +1038,10 @@ angular.module('syncthing.core')
                 $scope.currentFolder.path = $scope.currentFolder.path.slice(0, -1);
             }
             $scope.currentFolder.selectedDevices = {};
+            $scope.currentFolder.encryptedDevices = {};
             $scope.currentFolder.devices.forEach(function (n) {
                 $scope.currentFolder.selectedDevices[n.deviceID] = true;
+                $scope.currentFolder.encryptedDevices[n.deviceID] = n.encrypted;
             });
             if ($scope.currentFolder.versioning && $scope.currentFolder.versioning.type === "trashcan") {
                 $scope.currentFolder.trashcanFileVersioning = true;
@@ -1035,13 +1057,22 @@ angular.module('syncthing.core')
                 $scope.currentFolder.staggeredMaxAge = Math.floor(+$scope.currentFolder.versioning.params.maxAge / 86400);
                 $scope.currentFolder.staggeredCleanInterval = +$scope.currentFolder.versioning.params.cleanInterval;
                 $scope.currentFolder.staggeredVersionsPath = $scope.currentFolder.versioning.params.versionsPath;
+            } else if ($scope.currentFolder.versioning && $scope.currentFolder.versioning.type === "dedup") {
+                $scope.currentFolder.fileVersioningSelector = "dedup";
+                $scope.currentFolder.staggeredMaxAge = Math.floor(+$scope.currentFolder.versioning.params.maxAge / 86400);
+                $scope.currentFolder.staggeredCleanInterval = +$scope.currentFolder.versioning.params.cleanInterval;
             } else if ($scope.currentFolder.versioning && $scope.currentFolder.versioning.type === "external") {
                 $scope.currentFolder.externalFileVersioning = true;
                 $scope.currentFolder.fileVersioningSelector = "external";
                 $scope.currentFolder.externalCommand = $scope.currentFolder.versioning.params.command;
+                $scope.currentFolder.externalTimeout = +$scope.currentFolder.versioning.params.timeout;
             } else {
                 $scope.currentFolder.fileVersioningSelector = "none";
             }
+            if ($scope.currentFolder.versioning && $scope.currentFolder.versioning.params) {
+                $scope.currentFolder.versioningMaxSize = +$scope.currentFolder.versioning.params.maxSizeMiB;
+            }
+            $scope.currentFolder.versioningMaxSize = $scope.currentFolder.versioningMaxSize || 0;
             $scope.currentFolder.trashcanClean = $scope.currentFolder.trashcanClean || 0; // weeds out nulls and undefineds
             $scope.currentFolder.simpleKeep = $scope.currentFolder.simpleKeep || 5;
             $scope.currentFolder.staggeredCleanInterval = $scope.currentFolder.staggeredCleanInterval || 3600;
@@ -1054,6 +1085,7 @@ angular.module('syncthing.core')
                 $scope.currentFolder.staggeredMaxAge = 365;
             }
             $scope.currentFolder.externalCommand = $scope.currentFolder.externalCommand || "";
+            $scope.currentFolder.externalTimeout = $scope.currentFolder.externalTimeout || 0;
 
             $scope.editingExisting = true;
             $scope.folderEditor.$setPristine();
@@ -1062,17 +1094,20 @@ angular.module('syncthing.core')
 
         $scope.addFolder = function () {
             $scope.currentFolder = {
-                selectedDevices: {}
+                selectedDevices: {},
+                encryptedDevices: {}
             };
             $scope.currentFolder.rescanIntervalS = 60;
             $scope.currentFolder.order = "random";
             $scope.currentFolder.fileVersioningSelector = "none";
             $scope.currentFolder.trashcanClean = 0;
             $scope.currentFolder.simpleKeep = 5;
+            $scope.currentFolder.versioningMaxSize = 0;
             $scope.currentFolder.staggeredMaxAge = 365;
             $scope.currentFolder.staggeredCleanInterval = 3600;
             $scope.currentFolder.staggeredVersionsPath = "";
             $scope.currentFolder.externalCommand = "";
+            $scope.currentFolder.externalTimeout = 0;
             $scope.currentFolder.autoNormalize = true;
             $scope.editingExisting = false;
             $scope.folderEditor.$setPristine();
@@ -1084,14 +1119,17 @@ angular.module('syncthing.core')
             $scope.currentFolder = {
                 id: folder,
                 selectedDevices: {},
+                encryptedDevices: {},
                 rescanIntervalS: 60,
                 fileVersioningSelector: "none",
                 trashcanClean: 0,
                 simpleKeep: 5,
+                versioningMaxSize: 0,
                 staggeredMaxAge: 365,
                 staggeredCleanInterval: 3600,
                 staggeredVersionsPath: "",
                 externalCommand: "",
+                externalTimeout: 0,
                 autoNormalize: true
             };
             $scope.currentFolder.selectedDevices[device] = true;
@@ -1120,11 +1158,13 @@ angular.module('syncthing.core')
             for (var deviceID in folderCfg.selectedDevices) {
                 if (folderCfg.selectedDevices[deviceID] === true) {
                     folderCfg.devices.push({
-                        deviceID: deviceID
+                        deviceID: deviceID,
+                        encrypted: folderCfg.encryptedDevices[deviceID] === true
                     });
                 }
             }
             delete folderCfg.selectedDevices;
+            delete folderCfg.encryptedDevices;
 
             if (folderCfg.fileVersioningSelector === "trashcan") {
                 folderCfg.versioning = {
@@ -1158,18 +1198,45 @@ angular.module('syncthing.core')
                 delete folderCfg.staggeredCleanInterval;
                 delete folderCfg.staggeredVersionsPath;
 
+            } else if (folderCfg.fileVersioningSelector === "dedup") {
+                folderCfg.versioning = {
+                    'type': 'dedup',
+                    'params': {
+                        'maxAge': '' + (folderCfg.staggeredMaxAge * 86400),
+                        'cleanInterval': '' + folderCfg.staggeredCleanInterval
+                    }
+                };
+                delete folderCfg.staggeredMaxAge;
+                delete folderCfg.staggeredCleanInterval;
+                delete folderCfg.staggeredVersionsPath;
             } else if (folderCfg.fileVersioningSelector === "external") {
+                var externalParams = {
+                    'command': '' + folderCfg.externalCommand,
+                    'timeout': '' + folderCfg.externalTimeout
+                };
+                // Keep the environment variables, which aren't edited here.
+                if (folderCfg.versioning && folderCfg.versioning.params) {
+                    for (var key in folderCfg.versioning.params) {
+                        if (key.indexOf('env.') === 0) {
+                            externalParams[key] = folderCfg.versioning.params[key];
+                        }
+                    }
+                }
                 folderCfg.versioning = {
                     'Type': 'external',
-                    'Params': {
-                        'command': '' + folderCfg.externalCommand
-                    }
+                    'Params': externalParams
                 };
                 delete folderCfg.externalFileVersioning;
                 delete folderCfg.externalCommand;
+                delete folderCfg.externalTimeout;
             } else {
                 delete folderCfg.versioning;
             }
+            if (folderCfg.versioning && folderCfg.fileVersioningSelector !== "external") {
+                var params = folderCfg.versioning.params || folderCfg.versioning.Params;
+                params.maxSizeMiB = '' + folderCfg.versioningMaxSize;
+            }
+            delete folderCfg.versioningMaxSize;
 
             $scope.folders[folderCfg.id] = folderCfg;
             $scope.config.folders = folderList($scope.folders);
@@ -1310,7 +1377,17 @@ angular.module('syncthing.core')
         };
 
         $scope.override = function (folder) {
-            $http.post(urlbase + "/db/override?folder=" + encodeURIComponent(folder));
+            $http.get(urlbase + "/db/override?folder=" + encodeURIComponent(folder)).success(function (plan) {
+                var msg = 'Other devices will update ' + (plan.create.length + plan.update.length) +
+                    ' and delete ' + plan['delete'].length + ' items. Override changes?';
+                if (confirm(msg)) {
+                    $http.post(urlbase + "/db/override?folder=" + encodeURIComponent(folder));
+                }
+            }).error($scope.emitHTTPError);
+        };
+
+        $scope.resolveDeletions = function (folder, action) {
+            $http.post(urlbase + "/db/deletions?folder=" + encodeURIComponent(folder) + "&action=" + action);
         };
 
         $scope.about = function () {
@@ -1334,6 +1411,22 @@ angular.module('syncthing.core')
             $http.post(urlbase + "/db/scan?folder=" + encodeURIComponent(folder));
         };
 
+        $scope.setFolderPause = function (folder, pause) {
+            $http.post(urlbase + "/db/" + (pause ? "pause" : "resume") + "?folder=" + encodeURIComponent(folder)).success(function () {
+                $scope.folders[folder].paused = pause;
+            });
+        };
+
+        $scope.setDevicePause = function (device, pause) {
+            $http.post(urlbase + "/system/" + (pause ? "pause" : "resume") + "?device=" + encodeURIComponent(device)).success(function () {
+                $scope.devices.forEach(function (deviceCfg) {
+                    if (deviceCfg.deviceID === device) {
+                        deviceCfg.paused = pause;
+                    }
+                });
+            });
+        };
+
         $scope.bumpFile = function (folder, file) {
             var url = urlbase + "/db/prio?folder=" + encodeURIComponent(folder) + "&file=" + encodeURIComponent(file);
             // In order to get the right view of data in the response.
@@ -76,6 +76,18 @@ angular.module('syncthing.core')
             STARTUP_COMPLETED:    'StartupCompleted',   // Emitted exactly once, when initialization is complete and Syncthing is ready to start exchanging data with other devices
             STATE_CHANGED:        'StateChanged',   // Emitted when a folder changes state
             FOLDER_ERRORS:        'FolderErrors',   // Emitted when a folder has errors preventing a full sync
+            FOLDER_WATCH_STATE_CHANGED: 'FolderWatchStateChanged',   // Emitted when the filesystem watcher for a folder changes state
+            ITEM_CONFLICT:        'ItemConflict',   // Conflicting changes to a file were resolved
+            FOLDER_OUT_OF_DISK_SPACE: 'FolderOutOfDiskSpace',   // Pulling stopped for lack of disk space
+            FOLDER_PAUSED:        'FolderPaused',   // A folder has been paused
+            FOLDER_RESUMED:       'FolderResumed',   // A paused folder has been resumed
+            DEVICE_PAUSED:        'DevicePaused',   // A device has been paused
+            DEVICE_RESUMED:       'DeviceResumed',   // A paused device has been resumed
+            SCHEDULE_CHANGED:     'ScheduleChanged',   // The settings in effect by schedule have changed
+            FOLDER_DELETIONS_PENDING: 'FolderDeletionsPending',   // Deletions were held back for approval
+            ITEM_FAILED:          'ItemFailed',   // An item failed to sync and will be retried later
+            FOLDER_SCAN_PROGRESS: 'FolderScanProgress',   // Progress of a folder scan
+            ITEM_CORRUPTED:       'ItemCorrupted',   // A local file doesn't match the index
 
             start: function() {
                 $http.get(urlbase + '/events?limit=1')
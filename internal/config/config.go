@@ -31,13 +31,14 @@ const (
 )
 
 type Configuration struct {
-	Version        int                   `xml:"version,attr" json:"version"`
-	Folders        []FolderConfiguration `xml:"folder" json:"folders"`
-	Devices        []DeviceConfiguration `xml:"device" json:"devices"`
-	GUI            GUIConfiguration      `xml:"gui" json:"gui"`
-	Options        OptionsConfiguration  `xml:"options" json:"options"`
-	IgnoredDevices []protocol.DeviceID   `xml:"ignoredDevice" json:"ignoredDevices"`
-	XMLName        xml.Name              `xml:"configuration" json:"-"`
+	Version        int                     `xml:"version,attr" json:"version"`
+	Folders        []FolderConfiguration   `xml:"folder" json:"folders"`
+	Devices        []DeviceConfiguration   `xml:"device" json:"devices"`
+	GUI            GUIConfiguration        `xml:"gui" json:"gui"`
+	Options        OptionsConfiguration    `xml:"options" json:"options"`
+	IgnoredDevices []protocol.DeviceID     `xml:"ignoredDevice" json:"ignoredDevices"`
+	Schedules      []ScheduleConfiguration `xml:"schedule" json:"schedules"`
+	XMLName        xml.Name                `xml:"configuration" json:"-"`
 
 	OriginalVersion int `xml:"-" json:"-"` // The version we read from disk, before any conversion
 }
@@ -63,22 +64,44 @@ func (cfg Configuration) Copy() Configuration {
 	newCfg.IgnoredDevices = make([]protocol.DeviceID, len(cfg.IgnoredDevices))
 	copy(newCfg.IgnoredDevices, cfg.IgnoredDevices)
 
+	// Deep copy ScheduleConfigurations
+	newCfg.Schedules = make([]ScheduleConfiguration, len(cfg.Schedules))
+	for i := range newCfg.Schedules {
+		newCfg.Schedules[i] = cfg.Schedules[i].Copy()
+	}
+
 	return newCfg
 }
 
 type FolderConfiguration struct {
-	ID              string                      `xml:"id,attr" json:"id"`
-	RawPath         string                      `xml:"path,attr" json:"path"`
-	Devices         []FolderDeviceConfiguration `xml:"device" json:"devices"`
-	ReadOnly        bool                        `xml:"ro,attr" json:"readOnly"`
-	RescanIntervalS int                         `xml:"rescanIntervalS,attr" json:"rescanIntervalS"`
-	IgnorePerms     bool                        `xml:"ignorePerms,attr" json:"ignorePerms"`
-	AutoNormalize   bool                        `xml:"autoNormalize,attr" json:"autoNormalize"`
-	Versioning      VersioningConfiguration     `xml:"versioning" json:"versioning"`
-	Copiers         int                         `xml:"copiers" json:"copiers"` // This defines how many files are handled concurrently.
-	Pullers         int                         `xml:"pullers" json:"pullers"` // Defines how many blocks are fetched at the same time, possibly between separate copier routines.
-	Hashers         int                         `xml:"hashers" json:"hashers"` // Less than one sets the value to the number of cores. These are CPU bound due to hashing.
-	Order           PullOrder                   `xml:"order" json:"order"`
+	ID                 string                      `xml:"id,attr" json:"id"`
+	RawPath            string                      `xml:"path,attr" json:"path"`
+	Devices            []FolderDeviceConfiguration `xml:"device" json:"devices"`
+	ReadOnly           bool                        `xml:"ro,attr" json:"readOnly"`
+	ReceiveOnly        bool                        `xml:"receiveOnly,attr" json:"receiveOnly"` // Local changes are never sent to other devices.
+	Paused             bool                        `xml:"paused,attr" json:"paused"`           // No pulling, scanning or serving of requests until resumed.
+	RescanIntervalS    int                         `xml:"rescanIntervalS,attr" json:"rescanIntervalS"`
+	IgnorePerms        bool                        `xml:"ignorePerms,attr" json:"ignorePerms"`
+	AutoNormalize      bool                        `xml:"autoNormalize,attr" json:"autoNormalize"`
+	Versioning         VersioningConfiguration     `xml:"versioning" json:"versioning"`
+	Copiers            int                         `xml:"copiers" json:"copiers"` // This defines how many files are handled concurrently.
+	Pullers            int                         `xml:"pullers" json:"pullers"` // Defines how many blocks are fetched at the same time, possibly between separate copier routines.
+	Hashers            int                         `xml:"hashers" json:"hashers"` // Less than one sets the value to the number of cores. These are CPU bound due to hashing.
+	Order              PullOrder                   `xml:"order" json:"order"`
+	FSWatcherEnabled   bool                        `xml:"fsWatcherEnabled,attr" json:"fsWatcherEnabled"` // Rescan changed paths as soon as the filesystem reports changes.
+	FSWatcherDelayS    int                         `xml:"fsWatcherDelayS,attr" json:"fsWatcherDelayS"`   // Changes are collected for this long before rescanning.
+	ConflictPolicy     ConflictPolicy              `xml:"conflictPolicy" json:"conflictPolicy"`
+	ConflictWinner     string                      `xml:"conflictWinner,omitempty" json:"conflictWinner"`             // The device that wins conflicts under the deviceWins policy.
+	ConflictName       string                      `xml:"conflictNameTemplate,omitempty" json:"conflictNameTemplate"` // Empty means DefaultConflictName.
+	MaxConflicts       int                         `xml:"maxConflicts" json:"maxConflicts"`                           // Conflict copies kept per file. Zero is unlimited.
+	MinDiskFree        Size                        `xml:"minDiskFree" json:"minDiskFree"`                             // Pulling stops below this, in addition to the global minimum.
+	MaxDeletes         int                         `xml:"maxDeletes" json:"maxDeletes"`                               // Deleting more files than this in one pull needs approval. Zero is unlimited.
+	MaxDeletesPct      int                         `xml:"maxDeletesPct" json:"maxDeletesPct"`                         // Likewise, in percent of the files in the folder.
+	EncryptionPassword string                      `xml:"encryptionPassword,omitempty" json:"encryptionPassword"`     // For the devices that get the folder encrypted.
+	VerifyIntervalH    int                         `xml:"verifyIntervalH" json:"verifyIntervalH"`                     // Hours between verifications of the file contents. Zero disables them.
+	VerifyRateKiBs     int                         `xml:"verifyRateKiBs" json:"verifyRateKiBs"`                       // Read rate while verifying. Zero is unlimited.
+	VerifyRepair       bool                        `xml:"verifyRepair,attr" json:"verifyRepair"`                      // Corrupted blocks are pulled again from other devices.
+	MinFileAgeS        int                         `xml:"minFileAgeS" json:"minFileAgeS"`                             // Files modified more recently are left for a later scan.
 
 	Invalid string `xml:"-" json:"invalid"` // Set at runtime when there is an error, not saved
 
@@ -199,6 +222,9 @@ type DeviceConfiguration struct {
 	Compression protocol.Compression `xml:"compression,attr" json:"compression"`
 	CertName    string               `xml:"certName,attr,omitempty" json:"certName"`
 	Introducer  bool                 `xml:"introducer,attr" json:"introducer"`
+	Paused      bool                 `xml:"paused,attr" json:"paused"`                     // Not connected to until resumed
+	MaxSendKbps int                  `xml:"maxSendKbps,attr,omitempty" json:"maxSendKbps"` // Zero is unlimited; applies on the LAN as well
+	MaxRecvKbps int                  `xml:"maxRecvKbps,attr,omitempty" json:"maxRecvKbps"`
 }
 
 func (orig DeviceConfiguration) Copy() DeviceConfiguration {
@@ -209,7 +235,8 @@ func (orig DeviceConfiguration) Copy() DeviceConfiguration {
 }
 
 type FolderDeviceConfiguration struct {
-	DeviceID protocol.DeviceID `xml:"id,attr" json:"deviceID"`
+	DeviceID  protocol.DeviceID `xml:"id,attr" json:"deviceID"`
+	Encrypted bool              `xml:"encrypted,attr,omitempty" json:"encrypted"` // The device gets the folder encrypted with the folder's password.
 }
 
 type OptionsConfiguration struct {
@@ -239,6 +266,10 @@ type OptionsConfiguration struct {
 	DatabaseBlockCacheMiB   int      `xml:"databaseBlockCacheMiB" json:"databaseBlockCacheMiB" default:"0"`
 	PingTimeoutS            int      `xml:"pingTimeoutS" json:"pingTimeoutS" default:"30"`
 	PingIdleTimeS           int      `xml:"pingIdleTimeS" json:"pingIdleTimeS" default:"60"`
+	MinDiskFree             Size     `xml:"minDiskFree" json:"minDiskFree" default:"1%"` // Pulling stops below this, for all folders
+	MaxHashKiBs             int      `xml:"maxHashKiBs" json:"maxHashKiBs"`              // Read rate when hashing, for all folders together; 0 for unlimited
+	MaxHashers              int      `xml:"maxHashers" json:"maxHashers"`                // Files hashed at once, for all folders together; 0 for the number of CPUs
+	LowPriorityIO           bool     `xml:"lowPriorityIO" json:"lowPriorityIO"`          // Hash using the idle IO scheduling class, on Linux
 }
 
 func (orig OptionsConfiguration) Copy() OptionsConfiguration {
@@ -250,6 +281,28 @@ func (orig OptionsConfiguration) Copy() OptionsConfiguration {
 	return c
 }
 
+// A ScheduleConfiguration is a weekly time window, in local time, during
+// which the settings in it override the regular ones.
+type ScheduleConfiguration struct {
+	Days         string              `xml:"days,attr" json:"days"`                    // Comma separated weekdays, like "mon,tue". Empty means every day.
+	Start        string              `xml:"start,attr" json:"start"`                  // As "15:04".
+	End          string              `xml:"end,attr" json:"end"`                      // As "15:04". An end before the start is on the next day.
+	MaxSendKbps  int                 `xml:"maxSendKbps,omitempty" json:"maxSendKbps"` // Zero leaves the global limit alone, negative is unlimited.
+	MaxRecvKbps  int                 `xml:"maxRecvKbps,omitempty" json:"maxRecvKbps"` // Zero leaves the global limit alone, negative is unlimited.
+	Pullers      int                 `xml:"pullers,omitempty" json:"pullers"`         // Zero leaves the folder setting alone.
+	PauseFolders []string            `xml:"pauseFolder" json:"pauseFolders"`
+	PauseDevices []protocol.DeviceID `xml:"pauseDevice" json:"pauseDevices"`
+}
+
+func (orig ScheduleConfiguration) Copy() ScheduleConfiguration {
+	c := orig
+	c.PauseFolders = make([]string, len(orig.PauseFolders))
+	copy(c.PauseFolders, orig.PauseFolders)
+	c.PauseDevices = make([]protocol.DeviceID, len(orig.PauseDevices))
+	copy(c.PauseDevices, orig.PauseDevices)
+	return c
+}
+
 type GUIConfiguration struct {
 	Enabled  bool   `xml:"enabled,attr" json:"enabled" default:"true"`
 	Address  string `xml:"address" json:"address" default:"127.0.0.1:8384"`
@@ -308,6 +361,9 @@ func (cfg *Configuration) prepare(myID protocol.DeviceID) {
 	if cfg.IgnoredDevices == nil {
 		cfg.IgnoredDevices = []protocol.DeviceID{}
 	}
+	if cfg.Schedules == nil {
+		cfg.Schedules = []ScheduleConfiguration{}
+	}
 
 	// Check for missing, bad or duplicate folder ID:s
 	var seenFolders = map[string]*FolderConfiguration{}
@@ -407,6 +463,13 @@ func (cfg *Configuration) prepare(myID protocol.DeviceID) {
 		if cfg.Folders[i].Pullers == 0 {
 			cfg.Folders[i].Pullers = 16
 		}
+		if cfg.Folders[i].FSWatcherDelayS <= 0 {
+			cfg.Folders[i].FSWatcherDelayS = 10
+		}
+		if cfg.Folders[i].ReadOnly && cfg.Folders[i].ReceiveOnly {
+			l.Warnf("Folder %q can't be both read only and receive only; ignoring receive only", cfg.Folders[i].ID)
+			cfg.Folders[i].ReceiveOnly = false
+		}
 		sort.Sort(FolderDeviceConfigurationList(cfg.Folders[i].Devices))
 	}
 
@@ -532,6 +595,13 @@ func setDefaults(data interface{}) error {
 			case bool:
 				f.SetBool(v == "true")
 
+			case Size:
+				size, err := ParseSize(v)
+				if err != nil {
+					return err
+				}
+				f.Set(reflect.ValueOf(size))
+
 			case []string:
 				// We don't do anything with string slices here. Any default
 				// we set will be appended to by the XML decoder, so we fill
@@ -730,3 +800,53 @@ func (o *PullOrder) UnmarshalText(bs []byte) error {
 	}
 	return nil
 }
+
+// A ConflictPolicy decides what happens when a file has been changed
+// concurrently on two devices.
+type ConflictPolicy int
+
+const (
+	ConflictKeepBoth   ConflictPolicy = iota // default; the local file is kept as a conflict copy
+	ConflictNewestWins                       // the file with the newest modification time wins
+	ConflictDeviceWins                       // changes made by the ConflictWinner device win
+	ConflictLocalWins                        // the local file wins
+)
+
+func (p ConflictPolicy) String() string {
+	switch p {
+	case ConflictKeepBoth:
+		return "keepBoth"
+	case ConflictNewestWins:
+		return "newestWins"
+	case ConflictDeviceWins:
+		return "deviceWins"
+	case ConflictLocalWins:
+		return "localWins"
+	default:
+		return "unknown"
+	}
+}
+
+func (p ConflictPolicy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *ConflictPolicy) UnmarshalText(bs []byte) error {
+	switch string(bs) {
+	case "newestWins":
+		*p = ConflictNewestWins
+	case "deviceWins":
+		*p = ConflictDeviceWins
+	case "localWins":
+		*p = ConflictLocalWins
+	default:
+		*p = ConflictKeepBoth
+	}
+	return nil
+}
+
+// DefaultConflictName is the template for the names of conflict copies. The
+// placeholders are %NAME% and %EXT%, the name and extension of the original
+// file, %DATE% and %TIME% of the conflict, and %DEVICE%, the short ID of the
+// device whose change lost.
+const DefaultConflictName = "%NAME%.sync-conflict-%DATE%-%TIME%-%DEVICE%%EXT%"
@@ -55,6 +55,7 @@ func TestDefaultValues(t *testing.T) {
 		DatabaseBlockCacheMiB:   0,
 		PingTimeoutS:            30,
 		PingIdleTimeS:           60,
+		MinDiskFree:             Size{1, "%"},
 	}
 
 	cfg := New(device1)
@@ -92,6 +93,7 @@ func TestDeviceConfig(t *testing.T) {
 				Pullers:         16,
 				Hashers:         0,
 				AutoNormalize:   true,
+				FSWatcherDelayS: 10,
 			},
 		}
 		expectedDevices := []DeviceConfiguration{
@@ -164,6 +166,7 @@ func TestOverriddenValues(t *testing.T) {
 		DatabaseBlockCacheMiB:   42,
 		PingTimeoutS:            60,
 		PingIdleTimeS:           120,
+		MinDiskFree:             Size{5, "GB"},
 	}
 
 	cfg, err := Load("testdata/overridenvalues.xml", device1)
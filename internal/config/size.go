@@ -0,0 +1,96 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A Size is an amount of disk space, either in bytes with an optional unit
+// ("500 MB", "2GiB") or as a percentage of the total space ("1%").
+type Size struct {
+	Value float64
+	Unit  string
+}
+
+var sizeUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"k":   1e3,
+	"kb":  1e3,
+	"kib": 1 << 10,
+	"m":   1e6,
+	"mb":  1e6,
+	"mib": 1 << 20,
+	"g":   1e9,
+	"gb":  1e9,
+	"gib": 1 << 30,
+	"t":   1e12,
+	"tb":  1e12,
+	"tib": 1 << 40,
+}
+
+func ParseSize(s string) (Size, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Size{}, nil
+	}
+
+	i := 0
+	for i < len(s) && (s[i] >= '0' && s[i] <= '9' || s[i] == '.') {
+		i++
+	}
+	val, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return Size{}, fmt.Errorf("invalid size %q", s)
+	}
+
+	unit := strings.TrimSpace(s[i:])
+	if _, ok := sizeUnits[strings.ToLower(unit)]; !ok && unit != "%" {
+		return Size{}, fmt.Errorf("invalid size unit %q", unit)
+	}
+	return Size{val, unit}, nil
+}
+
+// Percentage returns true if the size is relative to the total space.
+func (s Size) Percentage() bool {
+	return s.Unit == "%"
+}
+
+// Bytes returns the size in bytes, given the total space.
+func (s Size) Bytes(total int64) int64 {
+	if s.Percentage() {
+		return int64(s.Value / 100 * float64(total))
+	}
+	return int64(s.Value * sizeUnits[strings.ToLower(s.Unit)])
+}
+
+func (s Size) String() string {
+	switch s.Unit {
+	case "":
+		return strconv.FormatFloat(s.Value, 'f', -1, 64)
+	case "%":
+		return strconv.FormatFloat(s.Value, 'f', -1, 64) + "%"
+	default:
+		return strconv.FormatFloat(s.Value, 'f', -1, 64) + " " + s.Unit
+	}
+}
+
+func (s Size) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Size) UnmarshalText(bs []byte) error {
+	size, err := ParseSize(string(bs))
+	if err != nil {
+		return err
+	}
+	*s = size
+	return nil
+}
@@ -0,0 +1,47 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package config
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	cases := []struct {
+		in     string
+		str    string
+		bytes  int64 // of 1e9 total
+		failed bool
+	}{
+		{"", "0", 0, false},
+		{"1%", "1%", 1e7, false},
+		{" 2.5 % ", "2.5%", 25e6, false},
+		{"1024", "1024", 1024, false},
+		{"500 MB", "500 MB", 500e6, false},
+		{"2GiB", "2 GiB", 2 << 30, false},
+		{"1 XB", "", 0, true},
+		{"MB", "", 0, true},
+	}
+
+	for _, tc := range cases {
+		s, err := ParseSize(tc.in)
+		if tc.failed {
+			if err == nil {
+				t.Errorf("Unexpected nil error for %q", tc.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.in, err)
+			continue
+		}
+		if s.String() != tc.str {
+			t.Errorf("%q: string %q, expected %q", tc.in, s.String(), tc.str)
+		}
+		if b := s.Bytes(1e9); b != tc.bytes {
+			t.Errorf("%q: %d bytes, expected %d", tc.in, b, tc.bytes)
+		}
+	}
+}
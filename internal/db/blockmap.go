@@ -44,15 +44,14 @@ func NewBlockMap(db *leveldb.DB, folder string) *BlockMap {
 // Add files to the block map, ignoring any deleted or invalid files.
 func (m *BlockMap) Add(files []protocol.FileInfo) error {
 	batch := new(leveldb.Batch)
-	buf := make([]byte, 4)
 	for _, file := range files {
 		if file.IsDirectory() || file.IsDeleted() || file.IsInvalid() {
 			continue
 		}
 
+		blockSize := blockSizeOf(file.Blocks)
 		for i, block := range file.Blocks {
-			binary.BigEndian.PutUint32(buf, uint32(i))
-			batch.Put(m.blockKey(block.Hash, file.Name), buf)
+			batch.Put(m.blockKey(block.Hash, file.Name), blockValue(int32(i), blockSize))
 		}
 	}
 	return m.db.Write(batch, nil)
@@ -61,7 +60,6 @@ func (m *BlockMap) Add(files []protocol.FileInfo) error {
 // Update block map state, removing any deleted or invalid files.
 func (m *BlockMap) Update(files []protocol.FileInfo) error {
 	batch := new(leveldb.Batch)
-	buf := make([]byte, 4)
 	for _, file := range files {
 		if file.IsDirectory() {
 			continue
@@ -74,9 +72,9 @@ func (m *BlockMap) Update(files []protocol.FileInfo) error {
 			continue
 		}
 
+		blockSize := blockSizeOf(file.Blocks)
 		for i, block := range file.Blocks {
-			binary.BigEndian.PutUint32(buf, uint32(i))
-			batch.Put(m.blockKey(block.Hash, file.Name), buf)
+			batch.Put(m.blockKey(block.Hash, file.Name), blockValue(int32(i), blockSize))
 		}
 	}
 	return m.db.Write(batch, nil)
@@ -159,11 +157,12 @@ func (f *BlockFinder) String() string {
 }
 
 // Iterate takes an iterator function which iterates over all matching blocks
-// for the given hash. The iterator function has to return either true (if
-// they are happy with the block) or false to continue iterating for whatever
-// reason. The iterator finally returns the result, whether or not a
+// for the given hash. The iterator function is given the folder, file name,
+// block index and the block size of the file, and has to return either true
+// (if they are happy with the block) or false to continue iterating for
+// whatever reason. The iterator finally returns the result, whether or not a
 // satisfying block was eventually found.
-func (f *BlockFinder) Iterate(hash []byte, iterFn func(string, string, int32) bool) bool {
+func (f *BlockFinder) Iterate(hash []byte, iterFn func(string, string, int32, int) bool) bool {
 	f.mut.RLock()
 	folders := f.folders
 	f.mut.RUnlock()
@@ -174,8 +173,8 @@ func (f *BlockFinder) Iterate(hash []byte, iterFn func(string, string, int32) bo
 
 		for iter.Next() && iter.Error() == nil {
 			folder, file := fromBlockKey(iter.Key())
-			index := int32(binary.BigEndian.Uint32(iter.Value()))
-			if iterFn(folder, osutil.NativeFilename(file), index) {
+			index, blockSize := fromBlockValue(iter.Value())
+			if iterFn(folder, osutil.NativeFilename(file), index, blockSize) {
 				return true
 			}
 		}
@@ -186,12 +185,14 @@ func (f *BlockFinder) Iterate(hash []byte, iterFn func(string, string, int32) bo
 // Fix repairs incorrect blockmap entries, removing the old entry and
 // replacing it with a new entry for the given block
 func (f *BlockFinder) Fix(folder, file string, index int32, oldHash, newHash []byte) error {
-	buf := make([]byte, 4)
-	binary.BigEndian.PutUint32(buf, uint32(index))
+	blockSize := protocol.BlockSize
+	if val, err := f.db.Get(toBlockKey(oldHash, folder, file), nil); err == nil {
+		_, blockSize = fromBlockValue(val)
+	}
 
 	batch := new(leveldb.Batch)
 	batch.Delete(toBlockKey(oldHash, folder, file))
-	batch.Put(toBlockKey(newHash, folder, file), buf)
+	batch.Put(toBlockKey(newHash, folder, file), blockValue(index, blockSize))
 	return f.db.Write(batch, nil)
 }
 
@@ -209,6 +210,36 @@ func toBlockKey(hash []byte, folder, file string) []byte {
 	return o
 }
 
+// blockValue returns a byte slice encoding the following information:
+//	   block index (4 bytes)
+//	   block size of the file (4 bytes)
+func blockValue(index int32, blockSize int) []byte {
+	o := make([]byte, 8)
+	binary.BigEndian.PutUint32(o, uint32(index))
+	binary.BigEndian.PutUint32(o[4:], uint32(blockSize))
+	return o
+}
+
+// fromBlockValue decodes a block value. Entries written by older versions
+// lack the block size and are always for standard size blocks.
+func fromBlockValue(data []byte) (int32, int) {
+	index := int32(binary.BigEndian.Uint32(data))
+	if len(data) < 8 {
+		return index, protocol.BlockSize
+	}
+	return index, int(binary.BigEndian.Uint32(data[4:]))
+}
+
+// blockSizeOf returns the block size of the given block list; the size of
+// the first block, as all blocks but the last one of a file have the same
+// size.
+func blockSizeOf(blocks []protocol.BlockInfo) int {
+	if len(blocks) == 0 || blocks[0].Size == 0 {
+		return protocol.BlockSize
+	}
+	return int(blocks[0].Size)
+}
+
 func fromBlockKey(data []byte) (string, string) {
 	if len(data) < 1+64+32+1 {
 		panic("Incorrect key length")
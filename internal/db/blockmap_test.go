@@ -94,21 +94,21 @@ func TestBlockMapAddUpdateWipe(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	f.Iterate(f1.Blocks[0].Hash, func(folder, file string, index int32) bool {
+	f.Iterate(f1.Blocks[0].Hash, func(folder, file string, index int32, blockSize int) bool {
 		if folder != "folder1" || file != "f1" || index != 0 {
 			t.Fatal("Mismatch")
 		}
 		return true
 	})
 
-	f.Iterate(f2.Blocks[0].Hash, func(folder, file string, index int32) bool {
+	f.Iterate(f2.Blocks[0].Hash, func(folder, file string, index int32, blockSize int) bool {
 		if folder != "folder1" || file != "f2" || index != 0 {
 			t.Fatal("Mismatch")
 		}
 		return true
 	})
 
-	f.Iterate(f3.Blocks[0].Hash, func(folder, file string, index int32) bool {
+	f.Iterate(f3.Blocks[0].Hash, func(folder, file string, index int32, blockSize int) bool {
 		t.Fatal("Unexpected block")
 		return true
 	})
@@ -123,17 +123,17 @@ func TestBlockMapAddUpdateWipe(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	f.Iterate(f1.Blocks[0].Hash, func(folder, file string, index int32) bool {
+	f.Iterate(f1.Blocks[0].Hash, func(folder, file string, index int32, blockSize int) bool {
 		t.Fatal("Unexpected block")
 		return false
 	})
 
-	f.Iterate(f2.Blocks[0].Hash, func(folder, file string, index int32) bool {
+	f.Iterate(f2.Blocks[0].Hash, func(folder, file string, index int32, blockSize int) bool {
 		t.Fatal("Unexpected block")
 		return false
 	})
 
-	f.Iterate(f3.Blocks[0].Hash, func(folder, file string, index int32) bool {
+	f.Iterate(f3.Blocks[0].Hash, func(folder, file string, index int32, blockSize int) bool {
 		if folder != "folder1" || file != "f3" || index != 0 {
 			t.Fatal("Mismatch")
 		}
@@ -180,7 +180,7 @@ func TestBlockFinderLookup(t *testing.T) {
 	}
 
 	counter := 0
-	f.Iterate(f1.Blocks[0].Hash, func(folder, file string, index int32) bool {
+	f.Iterate(f1.Blocks[0].Hash, func(folder, file string, index int32, blockSize int) bool {
 		counter++
 		switch counter {
 		case 1:
@@ -208,7 +208,7 @@ func TestBlockFinderLookup(t *testing.T) {
 	}
 
 	counter = 0
-	f.Iterate(f1.Blocks[0].Hash, func(folder, file string, index int32) bool {
+	f.Iterate(f1.Blocks[0].Hash, func(folder, file string, index int32, blockSize int) bool {
 		counter++
 		switch counter {
 		case 1:
@@ -230,7 +230,7 @@ func TestBlockFinderLookup(t *testing.T) {
 func TestBlockFinderFix(t *testing.T) {
 	db, f := setup()
 
-	iterFn := func(folder, file string, index int32) bool {
+	iterFn := func(folder, file string, index int32, blockSize int) bool {
 		return true
 	}
 
@@ -257,3 +257,39 @@ func TestBlockFinderFix(t *testing.T) {
 		t.Fatal("Block not found")
 	}
 }
+
+func TestBlockFinderBlockSize(t *testing.T) {
+	db, f := setup()
+
+	m := NewBlockMap(db, "folder1")
+	err := m.Add([]protocol.FileInfo{f2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := f.Iterate(f2.Blocks[3].Hash, func(folder, file string, index int32, blockSize int) bool {
+		if index != 3 || blockSize != int(f2.Blocks[0].Size) {
+			t.Errorf("Incorrect index %d or block size %d", index, blockSize)
+		}
+		return true
+	})
+	if !found {
+		t.Fatal("Block not found")
+	}
+
+	// Entries written by older versions only carry the index and are for
+	// standard size blocks.
+	legacy := []byte{0, 0, 0, 5}
+	if err := db.Put(toBlockKey(f3.Blocks[0].Hash, "folder1", f3.Name), legacy, nil); err != nil {
+		t.Fatal(err)
+	}
+	found = f.Iterate(f3.Blocks[0].Hash, func(folder, file string, index int32, blockSize int) bool {
+		if index != 5 || blockSize != protocol.BlockSize {
+			t.Errorf("Incorrect index %d or block size %d", index, blockSize)
+		}
+		return true
+	})
+	if !found {
+		t.Fatal("Block not found")
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/syncthing/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// A Conflict records a conflict copy made by the puller, for the user to
+// resolve later.
+type Conflict struct {
+	Name          string          `json:"name"`          // The file that was in conflict
+	CopyName      string          `json:"copyName"`      // The conflict copy of our version of it
+	LocalVersion  protocol.Vector `json:"localVersion"`  // The version moved to the conflict copy
+	RemoteVersion protocol.Vector `json:"remoteVersion"` // The version that replaced it
+	LocalDevice   string          `json:"localDevice"`   // The device that made the local change
+	RemoteDevice  string          `json:"remoteDevice"`  // The device that made the remote change
+	Time          time.Time       `json:"time"`
+}
+
+// This type keeps the conflicts of a folder, keyed by the name of the
+// conflict copy.
+
+type ConflictRepo struct {
+	ns *NamespacedKV
+}
+
+func NewConflictRepo(ldb *leveldb.DB, folder string) *ConflictRepo {
+	prefix := string([]byte{KeyTypeConflict}) + folder
+
+	return &ConflictRepo{
+		ns: NewNamespacedKV(ldb, prefix),
+	}
+}
+
+func (r *ConflictRepo) Put(c Conflict) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+	if debug {
+		l.Debugf("conflicts: storing %s -> %s", c.Name, c.CopyName)
+	}
+	r.ns.PutBytes(c.CopyName, data)
+}
+
+// Get returns the conflict with the given conflict copy name.
+func (r *ConflictRepo) Get(copyName string) (Conflict, bool) {
+	var c Conflict
+	data, ok := r.ns.Bytes(copyName)
+	if !ok {
+		return c, false
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, false
+	}
+	return c, true
+}
+
+func (r *ConflictRepo) Delete(copyName string) {
+	r.ns.Delete(copyName)
+}
+
+// List returns all conflicts, ordered by file and time.
+func (r *ConflictRepo) List() []Conflict {
+	it := r.ns.db.NewIterator(util.BytesPrefix(r.ns.prefix), nil)
+	defer it.Release()
+
+	conflicts := []Conflict{}
+	for it.Next() {
+		var c Conflict
+		if err := json.Unmarshal(it.Value(), &c); err != nil {
+			continue
+		}
+		conflicts = append(conflicts, c)
+	}
+
+	sort.Sort(conflictList(conflicts))
+	return conflicts
+}
+
+func (r *ConflictRepo) Drop() {
+	r.ns.Reset()
+}
+
+type conflictList []Conflict
+
+func (s conflictList) Len() int      { return len(s) }
+func (s conflictList) Swap(a, b int) { s[a], s[b] = s[b], s[a] }
+func (s conflictList) Less(a, b int) bool {
+	if s[a].Name != s[b].Name {
+		return s[a].Name < s[b].Name
+	}
+	return s[a].Time.Before(s[b].Time)
+}
@@ -0,0 +1,63 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syncthing/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestConflictRepo(t *testing.T) {
+	ldb, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo1 := NewConflictRepo(ldb, "folder1")
+	repo2 := NewConflictRepo(ldb, "folder2")
+
+	now := time.Now().Truncate(time.Second)
+	c1 := Conflict{
+		Name:          "b",
+		CopyName:      "b.sync-conflict-1",
+		LocalVersion:  protocol.Vector{{ID: 1, Value: 2}},
+		RemoteVersion: protocol.Vector{{ID: 1, Value: 1}, {ID: 2, Value: 1}},
+		Time:          now,
+	}
+	c2 := Conflict{Name: "a", CopyName: "a.sync-conflict-1", Time: now}
+	c3 := Conflict{Name: "b", CopyName: "b.sync-conflict-0", Time: now.Add(-time.Minute)}
+
+	repo1.Put(c1)
+	repo1.Put(c2)
+	repo1.Put(c3)
+
+	if c, ok := repo1.Get(c1.CopyName); !ok || !c.LocalVersion.Equal(c1.LocalVersion) || !c.RemoteVersion.Equal(c1.RemoteVersion) || !c.Time.Equal(now) {
+		t.Errorf("Incorrect conflict %+v", c)
+	}
+	if _, ok := repo2.Get(c1.CopyName); ok {
+		t.Error("Unexpected conflict in other folder")
+	}
+
+	list := repo1.List()
+	if len(list) != 3 || list[0].CopyName != c2.CopyName || list[1].CopyName != c3.CopyName || list[2].CopyName != c1.CopyName {
+		t.Errorf("Incorrect list %+v", list)
+	}
+
+	repo1.Delete(c1.CopyName)
+	if _, ok := repo1.Get(c1.CopyName); ok {
+		t.Error("Unexpected conflict after delete")
+	}
+
+	repo1.Drop()
+	if list := repo1.List(); len(list) != 0 {
+		t.Errorf("Unexpected conflicts after drop: %+v", list)
+	}
+}
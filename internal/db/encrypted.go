@@ -0,0 +1,73 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"github.com/syncthing/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// This type keeps the encrypted index of a folder; the encrypted form of
+// each file as announced to untrusted devices, and the plaintext file for
+// each encrypted name. Computing the encrypted form of a file requires
+// reading all of it, and the plaintext of an encrypted file can only be had
+// from the device that has it, so both are kept until the file changes.
+
+type EncryptedRepo struct {
+	files *NamespacedKV // plaintext name -> encrypted file
+	names *NamespacedKV // encrypted name -> plaintext file
+}
+
+func NewEncryptedRepo(ldb *leveldb.DB, folder string) *EncryptedRepo {
+	return &EncryptedRepo{
+		files: NewNamespacedKV(ldb, string([]byte{KeyTypeEncryptedFile})+folder),
+		names: NewNamespacedKV(ldb, string([]byte{KeyTypeEncryptedName})+folder),
+	}
+}
+
+// Put records that the plaintext file is encrypted as enc.
+func (r *EncryptedRepo) Put(plain, enc protocol.FileInfo) {
+	if debug {
+		l.Debugf("encrypted: storing %s -> %s", plain.Name, enc.Name)
+	}
+	r.files.PutBytes(plain.Name, marshalFileInfo(enc))
+	r.names.PutBytes(enc.Name, marshalFileInfo(plain))
+}
+
+// Encrypted returns the encrypted form of the named plaintext file.
+func (r *EncryptedRepo) Encrypted(name string) (protocol.FileInfo, bool) {
+	return unmarshalFileInfo(r.files.Bytes(name))
+}
+
+// Plain returns the plaintext file with the given encrypted name.
+func (r *EncryptedRepo) Plain(encName string) (protocol.FileInfo, bool) {
+	return unmarshalFileInfo(r.names.Bytes(encName))
+}
+
+func (r *EncryptedRepo) Drop() {
+	r.files.Reset()
+	r.names.Reset()
+}
+
+func marshalFileInfo(f protocol.FileInfo) []byte {
+	bs, err := f.MarshalXDR()
+	if err != nil {
+		panic(err)
+	}
+	return bs
+}
+
+func unmarshalFileInfo(bs []byte, ok bool) (protocol.FileInfo, bool) {
+	var f protocol.FileInfo
+	if !ok {
+		return f, false
+	}
+	if err := f.UnmarshalXDR(bs); err != nil {
+		return f, false
+	}
+	return f, true
+}
@@ -0,0 +1,54 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"testing"
+
+	"github.com/syncthing/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestEncryptedRepo(t *testing.T) {
+	ldb, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo1 := NewEncryptedRepo(ldb, "folder1")
+	repo2 := NewEncryptedRepo(ldb, "folder2")
+
+	plain := protocol.FileInfo{
+		Name:    "dir/file",
+		Flags:   0644,
+		Version: protocol.Vector{{ID: 1, Value: 2}},
+		Blocks:  []protocol.BlockInfo{{Size: 10, Hash: []byte("plain hash")}},
+	}
+	enc := protocol.FileInfo{
+		Name:    "ENCRYPTEDNAME",
+		Flags:   protocol.FlagNoPermBits | 0644,
+		Version: plain.Version,
+		Blocks:  []protocol.BlockInfo{{Size: 42, Hash: []byte("encrypted hash")}},
+	}
+	repo1.Put(plain, enc)
+
+	if f, ok := repo1.Encrypted(plain.Name); !ok || f.Name != enc.Name || !f.Version.Equal(plain.Version) || f.Blocks[0].Size != 42 {
+		t.Errorf("Incorrect encrypted file %v", f)
+	}
+	if f, ok := repo1.Plain(enc.Name); !ok || f.Name != plain.Name || string(f.Blocks[0].Hash) != "plain hash" {
+		t.Errorf("Incorrect plaintext file %v", f)
+	}
+	if _, ok := repo2.Plain(enc.Name); ok {
+		t.Error("Unexpected plaintext file in other folder")
+	}
+
+	repo1.Drop()
+	if _, ok := repo1.Encrypted(plain.Name); ok {
+		t.Error("Unexpected encrypted file after drop")
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/syncthing/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// A FailedItem records a file that the puller has failed to sync, so that
+// it's retried with a backoff instead of on every pull.
+type FailedItem struct {
+	Name      string          `json:"name"`
+	Error     string          `json:"error"`     // The most recent error
+	Count     int             `json:"count"`     // Consecutive failures of this version
+	FirstSeen time.Time       `json:"firstSeen"` // The first failure of this version
+	LastSeen  time.Time       `json:"lastSeen"`
+	RetryAt   time.Time       `json:"retryAt"`
+	Skipped   bool            `json:"skipped"` // Not retried until there is a new version
+	Version   protocol.Vector `json:"version"` // The version that failed
+}
+
+// This type keeps the failed items of a folder, keyed by name.
+
+type FailureRepo struct {
+	ns *NamespacedKV
+}
+
+func NewFailureRepo(ldb *leveldb.DB, folder string) *FailureRepo {
+	prefix := string([]byte{KeyTypeFailure}) + folder
+
+	return &FailureRepo{
+		ns: NewNamespacedKV(ldb, prefix),
+	}
+}
+
+func (r *FailureRepo) Put(f FailedItem) {
+	data, err := json.Marshal(f)
+	if err != nil {
+		panic(err)
+	}
+	if debug {
+		l.Debugf("failures: storing %s (%d)", f.Name, f.Count)
+	}
+	r.ns.PutBytes(f.Name, data)
+}
+
+func (r *FailureRepo) Get(name string) (FailedItem, bool) {
+	var f FailedItem
+	data, ok := r.ns.Bytes(name)
+	if !ok {
+		return f, false
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return f, false
+	}
+	return f, true
+}
+
+func (r *FailureRepo) Delete(name string) {
+	r.ns.Delete(name)
+}
+
+// List returns all failed items, ordered by name.
+func (r *FailureRepo) List() []FailedItem {
+	it := r.ns.db.NewIterator(util.BytesPrefix(r.ns.prefix), nil)
+	defer it.Release()
+
+	items := []FailedItem{}
+	for it.Next() {
+		var f FailedItem
+		if err := json.Unmarshal(it.Value(), &f); err != nil {
+			continue
+		}
+		items = append(items, f)
+	}
+
+	sort.Sort(failedItemList(items))
+	return items
+}
+
+func (r *FailureRepo) Drop() {
+	r.ns.Reset()
+}
+
+type failedItemList []FailedItem
+
+func (s failedItemList) Len() int           { return len(s) }
+func (s failedItemList) Swap(a, b int)      { s[a], s[b] = s[b], s[a] }
+func (s failedItemList) Less(a, b int) bool { return s[a].Name < s[b].Name }
@@ -0,0 +1,63 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syncthing/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestFailureRepo(t *testing.T) {
+	ldb, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo1 := NewFailureRepo(ldb, "folder1")
+	repo2 := NewFailureRepo(ldb, "folder2")
+
+	now := time.Now().Truncate(time.Second)
+	f1 := FailedItem{
+		Name:      "b",
+		Error:     "permission denied",
+		Count:     3,
+		FirstSeen: now.Add(-time.Hour),
+		LastSeen:  now,
+		RetryAt:   now.Add(4 * time.Minute),
+		Version:   protocol.Vector{{ID: 1, Value: 2}},
+	}
+	f2 := FailedItem{Name: "a", Count: 1, LastSeen: now}
+
+	repo1.Put(f1)
+	repo1.Put(f2)
+
+	if f, ok := repo1.Get(f1.Name); !ok || f.Count != 3 || !f.RetryAt.Equal(f1.RetryAt) || !f.Version.Equal(f1.Version) {
+		t.Errorf("Incorrect failed item %+v", f)
+	}
+	if _, ok := repo2.Get(f1.Name); ok {
+		t.Error("Unexpected failed item in other folder")
+	}
+
+	list := repo1.List()
+	if len(list) != 2 || list[0].Name != "a" || list[1].Name != "b" {
+		t.Errorf("Incorrect list %+v", list)
+	}
+
+	repo1.Delete(f1.Name)
+	if _, ok := repo1.Get(f1.Name); ok {
+		t.Error("Unexpected failed item after delete")
+	}
+
+	repo1.Drop()
+	if list := repo1.List(); len(list) != 0 {
+		t.Errorf("Unexpected failed items after drop: %+v", list)
+	}
+}
@@ -46,6 +46,11 @@ const (
 	KeyTypeDeviceStatistic
 	KeyTypeFolderStatistic
 	KeyTypeVirtualMtime
+	KeyTypePullState
+	KeyTypeConflict
+	KeyTypeFailure
+	KeyTypeEncryptedFile
+	KeyTypeEncryptedName
 )
 
 type fileVersion struct {
@@ -0,0 +1,124 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"time"
+
+	"github.com/syncthing/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// This type keeps track of which blocks of a partially downloaded file have
+// been verified and written to its temporary file, so that the download can
+// be resumed after a restart without rehashing the temporary file. Each
+// record carries a fingerprint of the block list it was made for, and is
+// disregarded if the wanted version of the file has different contents.
+//
+// The record for a file is encoded as:
+//	   last update, unix nanoseconds (8 bytes)
+//	   fingerprint of the block list (32 bytes)
+//	   bitmap of available blocks (variable size)
+
+type PullStateRepo struct {
+	ns *NamespacedKV
+}
+
+func NewPullStateRepo(ldb *leveldb.DB, folder string) *PullStateRepo {
+	prefix := string([]byte{KeyTypePullState}) + folder
+
+	return &PullStateRepo{
+		ns: NewNamespacedKV(ldb, prefix),
+	}
+}
+
+// Put records which blocks of the file are available in its temporary file.
+func (r *PullStateRepo) Put(file protocol.FileInfo, available []bool) {
+	data := make([]byte, 8+32+(len(available)+7)/8)
+	binary.BigEndian.PutUint64(data, uint64(time.Now().UnixNano()))
+	copy(data[8:], blocksFingerprint(file.Blocks))
+	bitmap := data[8+32:]
+	for i, ok := range available {
+		if ok {
+			bitmap[i/8] |= 1 << uint(i%8)
+		}
+	}
+
+	if debug {
+		l.Debugf("pull state: storing state for %s", file.Name)
+	}
+	r.ns.PutBytes(file.Name, data)
+}
+
+// Get returns which blocks of the file are available in its temporary file,
+// and false if there is no record for this version of the file.
+func (r *PullStateRepo) Get(file protocol.FileInfo) ([]bool, bool) {
+	data, ok := r.ns.Bytes(file.Name)
+	if !ok {
+		return nil, false
+	}
+
+	if len(data) < 8+32 || len(data)-8-32 != (len(file.Blocks)+7)/8 ||
+		!bytes.Equal(data[8:8+32], blocksFingerprint(file.Blocks)) {
+		if debug {
+			l.Debugf("pull state: record for %s is for another version", file.Name)
+		}
+		return nil, false
+	}
+
+	bitmap := data[8+32:]
+	available := make([]bool, len(file.Blocks))
+	for i := range available {
+		available[i] = bitmap[i/8]&(1<<uint(i%8)) != 0
+	}
+	return available, true
+}
+
+// Delete removes the record for the named file.
+func (r *PullStateRepo) Delete(name string) {
+	r.ns.Delete(name)
+}
+
+// Expire removes all records that haven't been updated within maxAge.
+func (r *PullStateRepo) Expire(maxAge time.Duration) {
+	cutoff := time.Now().Add(-maxAge).UnixNano()
+
+	it := r.ns.db.NewIterator(util.BytesPrefix(r.ns.prefix), nil)
+	defer it.Release()
+	batch := new(leveldb.Batch)
+	for it.Next() {
+		val := it.Value()
+		if len(val) < 8 || int64(binary.BigEndian.Uint64(val)) < cutoff {
+			if debug {
+				l.Debugf("pull state: expiring %s", it.Key()[len(r.ns.prefix):])
+			}
+			batch.Delete(it.Key())
+		}
+	}
+	if batch.Len() > 0 {
+		r.ns.db.Write(batch, nil)
+	}
+}
+
+func (r *PullStateRepo) Drop() {
+	r.ns.Reset()
+}
+
+func blocksFingerprint(blocks []protocol.BlockInfo) []byte {
+	h := sha256.New()
+	var size [4]byte
+	for _, block := range blocks {
+		binary.BigEndian.PutUint32(size[:], uint32(block.Size))
+		h.Write(size[:])
+		h.Write(block.Hash)
+	}
+	return h.Sum(nil)
+}
@@ -0,0 +1,74 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/syncthing/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestPullStateRepo(t *testing.T) {
+	ldb, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo1 := NewPullStateRepo(ldb, "folder1")
+	repo2 := NewPullStateRepo(ldb, "folder2")
+
+	file := protocol.FileInfo{
+		Name:   "file",
+		Blocks: genBlocks(10),
+	}
+
+	if _, ok := repo1.Get(file); ok {
+		t.Error("Unexpected state before put")
+	}
+
+	available := make([]bool, len(file.Blocks))
+	available[0] = true
+	available[3] = true
+	available[9] = true
+	repo1.Put(file, available)
+
+	if res, ok := repo1.Get(file); !ok || !reflect.DeepEqual(res, available) {
+		t.Errorf("Incorrect state %v, %v != %v", ok, res, available)
+	}
+	if _, ok := repo2.Get(file); ok {
+		t.Error("State leaked into other folder")
+	}
+
+	// A record for different contents isn't used
+	other := file
+	other.Blocks = genBlocks(11)[1:]
+	if _, ok := repo1.Get(other); ok {
+		t.Error("Unexpected state for other version")
+	}
+
+	// Fresh records aren't expired
+	repo1.Expire(time.Hour)
+	if _, ok := repo1.Get(file); !ok {
+		t.Error("State expired too early")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	repo1.Expire(time.Millisecond)
+	if _, ok := repo1.Get(file); ok {
+		t.Error("State should have expired")
+	}
+
+	repo1.Put(file, available)
+	repo1.Delete(file.Name)
+	if _, ok := repo1.Get(file); ok {
+		t.Error("State should have been deleted")
+	}
+}
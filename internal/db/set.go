@@ -229,6 +229,10 @@ func DropFolder(db *leveldb.DB, folder string) {
 	}
 	bm.Drop()
 	NewVirtualMtimeRepo(db, folder).Drop()
+	NewPullStateRepo(db, folder).Drop()
+	NewConflictRepo(db, folder).Drop()
+	NewFailureRepo(db, folder).Drop()
+	NewEncryptedRepo(db, folder).Drop()
 }
 
 func normalizeFilenames(fs []protocol.FileInfo) {
@@ -0,0 +1,182 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package encryption implements the encryption of folder data for devices
+// that are not trusted to read it.
+//
+// The encrypted form of a file is its blocks, each encrypted on its own and
+// concatenated, followed by an encrypted trailer holding the metadata of the
+// file and the length of the encrypted trailer as four bytes, big endian.
+// Encryption is deterministic; the same data encrypts to the same
+// ciphertext, so that all trusted devices announce the same encrypted files.
+//
+// Names are replaced by a keyed hash of the name. The real name is only
+// available from the trailer.
+//
+// A deleted file has no data and so no trailer. Its deletion is instead
+// authenticated by a keyed hash of the real name and the version, so that
+// untrusted devices can pass deletions on, but can't make them up.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"hash"
+)
+
+const (
+	nonceSize = 12
+	tagSize   = 16
+
+	// BlockOverhead is the number of bytes an encrypted block is larger
+	// than the plaintext.
+	BlockOverhead = nonceSize + tagSize
+
+	// TrailerLengthSize is the size of the trailer length at the end of an
+	// encrypted file.
+	TrailerLengthSize = 4
+
+	keyIterations = 1 << 16
+)
+
+var (
+	ErrDecrypt    = errors.New("decryption failed")
+	ErrBadTrailer = errors.New("invalid trailer length")
+)
+
+var nameEncoding = base32.StdEncoding
+
+// A Key encrypts and decrypts the data of a folder.
+type Key struct {
+	name []byte
+	aead cipher.AEAD
+	iv   []byte
+	tomb []byte
+}
+
+// NewKey derives the key for the given folder from the password.
+func NewKey(folder, password string) *Key {
+	master := pbkdf2([]byte(password), []byte("syncthing"+folder), keyIterations)
+
+	block, err := aes.NewCipher(subKey(master, "data"))
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+
+	return &Key{
+		name: subKey(master, "name"),
+		aead: aead,
+		iv:   subKey(master, "iv"),
+		tomb: subKey(master, "tombstone"),
+	}
+}
+
+// EncryptName returns the name the file is known by on untrusted devices.
+func (k *Key) EncryptName(name string) string {
+	mac := hmac.New(sha256.New, k.name)
+	mac.Write([]byte(name))
+	enc := nameEncoding.EncodeToString(mac.Sum(nil))
+	// Strip the padding
+	for len(enc) > 0 && enc[len(enc)-1] == '=' {
+		enc = enc[:len(enc)-1]
+	}
+	return enc
+}
+
+// Tombstone returns the authenticator of the deletion of the named file at
+// the given, serialized, version.
+func (k *Key) Tombstone(name string, version []byte) []byte {
+	mac := hmac.New(sha256.New, k.tomb)
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write(version)
+	return mac.Sum(nil)
+}
+
+// Encrypt returns the encrypted form of the data. The nonce is derived from
+// the given hash of the data, so the same data always encrypts the same.
+func (k *Key) Encrypt(data, hash []byte) []byte {
+	mac := hmac.New(sha256.New, k.iv)
+	mac.Write(hash)
+	nonce := mac.Sum(nil)[:nonceSize]
+
+	out := make([]byte, nonceSize, nonceSize+len(data)+tagSize)
+	copy(out, nonce)
+	return k.aead.Seal(out, nonce, data, nil)
+}
+
+// Decrypt returns the plaintext of data encrypted by Encrypt.
+func (k *Key) Decrypt(enc []byte) ([]byte, error) {
+	if len(enc) < BlockOverhead {
+		return nil, ErrDecrypt
+	}
+	data, err := k.aead.Open(nil, enc[:nonceSize], enc[nonceSize:], nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return data, nil
+}
+
+// EncryptTrailer returns the trailer holding the given metadata, including
+// the trailing length.
+func (k *Key) EncryptTrailer(data []byte) []byte {
+	hash := sha256.Sum256(data)
+	enc := k.Encrypt(data, hash[:])
+	var length [TrailerLengthSize]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(enc)))
+	return append(enc, length[:]...)
+}
+
+// TrailerLength returns the length of the encrypted trailer, given the last
+// bytes of an encrypted file of the given size.
+func TrailerLength(length []byte, size int64) (int, error) {
+	if len(length) != TrailerLengthSize {
+		return 0, ErrBadTrailer
+	}
+	n := int64(binary.BigEndian.Uint32(length))
+	if n < BlockOverhead || n > size-TrailerLengthSize {
+		return 0, ErrBadTrailer
+	}
+	return int(n), nil
+}
+
+func subKey(master []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, master)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// pbkdf2 is PBKDF2 with HMAC-SHA256, producing a single block of key.
+func pbkdf2(password, salt []byte, iterations int) []byte {
+	prf := hmac.New(sha256.New, password)
+	u := sum(prf, salt, []byte{0, 0, 0, 1})
+	key := make([]byte, len(u))
+	copy(key, u)
+	for i := 1; i < iterations; i++ {
+		u = sum(prf, u)
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+func sum(h hash.Hash, data ...[]byte) []byte {
+	h.Reset()
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
@@ -0,0 +1,90 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package encryption
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestPBKDF2(t *testing.T) {
+	// From RFC 7914
+	key := pbkdf2([]byte("passwd"), []byte("salt"), 1)
+	if hex.EncodeToString(key) != "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" {
+		t.Errorf("Incorrect key %x", key)
+	}
+}
+
+func TestName(t *testing.T) {
+	k1 := NewKey("folder", "password")
+	k2 := NewKey("folder", "other password")
+
+	name := k1.EncryptName("dir/file.txt")
+	if name != k1.EncryptName("dir/file.txt") {
+		t.Error("Name encryption is not deterministic")
+	}
+	if name == k2.EncryptName("dir/file.txt") || name == k1.EncryptName("dir/file2.txt") {
+		t.Error("Unexpected identical encrypted names")
+	}
+	if len(name) != 52 {
+		t.Errorf("Unexpected name length %d for %q", len(name), name)
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	k1 := NewKey("folder", "password")
+	k2 := NewKey("other folder", "password")
+
+	data := []byte("some data to encrypt")
+	hash := sha256.Sum256(data)
+
+	enc := k1.Encrypt(data, hash[:])
+	if len(enc) != len(data)+BlockOverhead {
+		t.Errorf("Incorrect encrypted length %d", len(enc))
+	}
+	if !bytes.Equal(enc, k1.Encrypt(data, hash[:])) {
+		t.Error("Encryption is not deterministic")
+	}
+	if bytes.Contains(enc, data) {
+		t.Error("Encrypted data contains the plaintext")
+	}
+
+	if dec, err := k1.Decrypt(enc); err != nil || !bytes.Equal(dec, data) {
+		t.Errorf("Incorrect decryption %q, %v", dec, err)
+	}
+	if _, err := k2.Decrypt(enc); err != ErrDecrypt {
+		t.Errorf("Unexpected error %v decrypting with the wrong key", err)
+	}
+	enc[len(enc)-1]++
+	if _, err := k1.Decrypt(enc); err != ErrDecrypt {
+		t.Errorf("Unexpected error %v decrypting modified data", err)
+	}
+}
+
+func TestTrailer(t *testing.T) {
+	k := NewKey("folder", "password")
+
+	meta := []byte("metadata")
+	trailer := k.EncryptTrailer(meta)
+	file := append([]byte("encrypted blocks"), trailer...)
+	size := int64(len(file))
+
+	n, err := TrailerLength(file[size-TrailerLengthSize:], size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := size - TrailerLengthSize - int64(n)
+	if dec, err := k.Decrypt(file[start : size-TrailerLengthSize]); err != nil || !bytes.Equal(dec, meta) {
+		t.Errorf("Incorrect trailer %q, %v", dec, err)
+	}
+
+	if _, err := TrailerLength([]byte{0, 0, 1, 0}, size); err != ErrBadTrailer {
+		t.Errorf("Unexpected error %v for too long trailer", err)
+	}
+}
@@ -36,6 +36,18 @@ const (
 	FolderSummary
 	FolderCompletion
 	FolderErrors
+	FolderWatchStateChanged
+	ItemConflict
+	FolderOutOfDiskSpace
+	FolderPaused
+	FolderResumed
+	DevicePaused
+	DeviceResumed
+	ScheduleChanged
+	FolderDeletionsPending
+	ItemFailed
+	FolderScanProgress
+	ItemCorrupted
 
 	AllEvents = (1 << iota) - 1
 )
@@ -78,6 +90,30 @@ func (t EventType) String() string {
 		return "FolderCompletion"
 	case FolderErrors:
 		return "FolderErrors"
+	case FolderWatchStateChanged:
+		return "FolderWatchStateChanged"
+	case ItemConflict:
+		return "ItemConflict"
+	case FolderOutOfDiskSpace:
+		return "FolderOutOfDiskSpace"
+	case FolderPaused:
+		return "FolderPaused"
+	case FolderResumed:
+		return "FolderResumed"
+	case DevicePaused:
+		return "DevicePaused"
+	case DeviceResumed:
+		return "DeviceResumed"
+	case ScheduleChanged:
+		return "ScheduleChanged"
+	case FolderDeletionsPending:
+		return "FolderDeletionsPending"
+	case ItemFailed:
+		return "ItemFailed"
+	case FolderScanProgress:
+		return "FolderScanProgress"
+	case ItemCorrupted:
+		return "ItemCorrupted"
 	default:
 		return "Unknown"
 	}
@@ -0,0 +1,419 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/db"
+	"github.com/syncthing/syncthing/internal/events"
+	"github.com/syncthing/syncthing/internal/osutil"
+)
+
+// A conflictResolution is the outcome of the conflict policy for a file
+// that has been changed both locally and remotely.
+type conflictResolution int
+
+const (
+	conflictKeepBoth   conflictResolution = iota // the local file is moved to a conflict copy
+	conflictRemoteWins                           // the local file is replaced
+	conflictLocalWins                            // the remote change is overridden
+)
+
+func (r conflictResolution) String() string {
+	switch r {
+	case conflictKeepBoth:
+		return "keepBoth"
+	case conflictRemoteWins:
+		return "remoteWins"
+	case conflictLocalWins:
+		return "localWins"
+	default:
+		return "unknown"
+	}
+}
+
+// resolveConflict applies the folder's conflict policy to the current local
+// file and the conflicting remote file.
+func (p *rwFolder) resolveConflict(cur, file protocol.FileInfo) conflictResolution {
+	switch p.conflictPolicy {
+	case config.ConflictNewestWins:
+		if cur.Modified > file.Modified {
+			return conflictLocalWins
+		}
+		return conflictRemoteWins
+
+	case config.ConflictDeviceWins:
+		id := p.conflictWinner.Short()
+		switch {
+		case cur.Version.Counter(id) > file.Version.Counter(id):
+			return conflictLocalWins
+		case file.Version.Counter(id) > cur.Version.Counter(id):
+			return conflictRemoteWins
+		}
+		// The device hasn't been part of this conflict.
+		return conflictKeepBoth
+
+	case config.ConflictLocalWins:
+		return conflictLocalWins
+	}
+
+	return conflictKeepBoth
+}
+
+// keepLocal resolves a conflict in favour of the local file by giving it a
+// version that is newer than both, which causes the other devices to pull
+// it.
+func (p *rwFolder) keepLocal(cur, file protocol.FileInfo) {
+	loser := p.deviceName(losingDevice(file.Version, cur.Version))
+	cur.Version = cur.Version.Merge(file.Version).Update(p.shortID)
+	p.dbUpdates <- dbUpdateJob{cur, dbUpdateShortcutFile}
+	p.conflictEvent(file.Name, conflictLocalWins, loser, "")
+}
+
+// conflictCopy handles the local file when it's replaced by a conflicting
+// remote change. Unless the remote change wins outright the file is moved to
+// a conflict copy, and true is returned.
+func (p *rwFolder) conflictCopy(realName string, cur protocol.Vector, file protocol.FileInfo, resolution conflictResolution) (bool, error) {
+	loser := p.deviceName(losingDevice(cur, file.Version))
+	if resolution == conflictRemoteWins {
+		p.conflictEvent(file.Name, resolution, loser, "")
+		return false, nil
+	}
+	copyName, err := p.moveForConflict(realName, loser)
+	if copyName != "" {
+		p.recordConflict(file, cur, copyName)
+	}
+	p.conflictEvent(file.Name, conflictKeepBoth, loser, copyName)
+	return true, err
+}
+
+// recordConflict remembers the conflict copy, so that the user can resolve
+// the conflict later.
+func (p *rwFolder) recordConflict(file protocol.FileInfo, cur protocol.Vector, copyName string) {
+	rel, err := filepath.Rel(p.dir, copyName)
+	if err != nil {
+		return
+	}
+	db.NewConflictRepo(p.model.db, p.folder).Put(db.Conflict{
+		Name:          file.Name,
+		CopyName:      rel,
+		LocalVersion:  cur,
+		RemoteVersion: file.Version,
+		LocalDevice:   p.model.deviceString(losingDevice(cur, file.Version)),
+		RemoteDevice:  p.model.deviceString(losingDevice(file.Version, cur)),
+		Time:          time.Now(),
+	})
+}
+
+// moveForConflict moves the local file out of the way of the remote one,
+// into a conflict copy named by the folder's template. It returns the name
+// of the conflict copy, if one was created.
+func (p *rwFolder) moveForConflict(name, loser string) (string, error) {
+	now := time.Now()
+	newName := conflictName(p.conflictName, name, loser, now)
+	if _, err := osutil.Lstat(newName); err == nil {
+		// A template without date or time names the same copy every time,
+		// and may name an unrelated file. Neither is ever overwritten.
+		newName = conflictName(config.DefaultConflictName, name, loser, now)
+		if _, err := osutil.Lstat(newName); err == nil {
+			return "", fmt.Errorf("conflict copy %s already exists", filepath.Base(newName))
+		}
+	}
+	err := osutil.InWritableDir(func(name string) error {
+		return os.Rename(name, newName)
+	}, name)
+	if os.IsNotExist(err) {
+		// We were supposed to move a file away but it does not exist. Either
+		// the user has already moved it away, or the conflict was between a
+		// remote modification and a local delete. In either way it does not
+		// matter, go ahead as if the move succeeded.
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if p.maxConflicts > 0 {
+		p.pruneConflicts(name)
+	}
+	return newName, nil
+}
+
+// pruneConflicts removes the oldest conflict copies of the file beyond
+// maxConflicts.
+func (p *rwFolder) pruneConflicts(name string) {
+	dir := filepath.Dir(name)
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	// Copies that fell back to the default name are pruned along with the
+	// rest.
+	exp := conflictRegexp(p.conflictName, name)
+	defExp := conflictRegexp(config.DefaultConflictName, name)
+	var copies []os.FileInfo
+	for _, info := range infos {
+		if info.Mode().IsRegular() && (exp.MatchString(info.Name()) || defExp.MatchString(info.Name())) {
+			copies = append(copies, info)
+		}
+	}
+	if len(copies) <= p.maxConflicts {
+		return
+	}
+
+	sort.Sort(byModTime(copies))
+	for _, info := range copies[:len(copies)-p.maxConflicts] {
+		if debug {
+			l.Debugln(p, "removing old conflict copy", info.Name())
+		}
+		osutil.InWritableDir(osutil.Remove, filepath.Join(dir, info.Name()))
+	}
+}
+
+func (p *rwFolder) conflictEvent(name string, resolution conflictResolution, loser, copyName string) {
+	l.Infof("Puller (folder %q, file %q): conflict resolved (%v)", p.folder, name, resolution)
+	events.Default.Log(events.ItemConflict, map[string]interface{}{
+		"folder":     p.folder,
+		"item":       name,
+		"policy":     p.conflictPolicy.String(),
+		"resolution": resolution.String(),
+		"loser":      loser,
+		"copy":       copyName,
+	})
+}
+
+// deviceName returns the short name of the device with the given short ID,
+// as used in conflict copy names.
+func (p *rwFolder) deviceName(short uint64) string {
+	if id, ok := p.model.deviceByShortID(short); ok {
+		return id.String()[:7]
+	}
+	return fmt.Sprintf("%X", short)
+}
+
+// deviceString returns the full ID of the device with the given short ID,
+// if it's known.
+func (m *Model) deviceString(short uint64) string {
+	if id, ok := m.deviceByShortID(short); ok {
+		return id.String()
+	}
+	return fmt.Sprintf("%X", short)
+}
+
+func (m *Model) deviceByShortID(short uint64) (protocol.DeviceID, bool) {
+	if short == m.shortID {
+		return m.id, true
+	}
+	for id := range m.cfg.Devices() {
+		if id.Short() == short {
+			return id, true
+		}
+	}
+	return protocol.DeviceID{}, false
+}
+
+// losingDevice returns the short ID of the device that made a change in
+// the losing version that isn't in the winning one.
+func losingDevice(loser, winner protocol.Vector) uint64 {
+	for _, c := range loser {
+		if c.Value > winner.Counter(c.ID) {
+			return c.ID
+		}
+	}
+	return 0
+}
+
+// conflictTemplate returns the template to use for conflict copies of the
+// file. Templates that would put the copy anywhere but next to the file, or
+// on top of it, are replaced by the default.
+func conflictTemplate(template, name string) string {
+	base := filepath.Base(name)
+	ext := filepath.Ext(base)
+	fixed := strings.NewReplacer("%NAME%", base[:len(base)-len(ext)], "%EXT%", ext).Replace(template)
+	if template == "" || strings.ContainsAny(template, `/\`) || fixed == base || fixed == "." || fixed == ".." {
+		return config.DefaultConflictName
+	}
+	return template
+}
+
+// conflictName returns the name of the conflict copy of the file.
+func conflictName(template, name, device string, t time.Time) string {
+	dir, base := filepath.Split(name)
+	ext := filepath.Ext(base)
+
+	newName := strings.NewReplacer(
+		"%NAME%", base[:len(base)-len(ext)],
+		"%EXT%", ext,
+		"%DATE%", t.Format("20060102"),
+		"%TIME%", t.Format("150405"),
+		"%DEVICE%", device,
+	).Replace(conflictTemplate(template, name))
+	return filepath.Join(dir, newName)
+}
+
+// conflictRegexp returns an expression that matches the names of conflict
+// copies of the file.
+func conflictRegexp(template, name string) *regexp.Regexp {
+	base := filepath.Base(name)
+	ext := filepath.Ext(base)
+
+	exp := strings.NewReplacer(
+		"%NAME%", regexp.QuoteMeta(base[:len(base)-len(ext)]),
+		"%EXT%", regexp.QuoteMeta(ext),
+		"%DATE%", `\d{8}`,
+		"%TIME%", `\d{6}`,
+		"%DEVICE%", `[0-9A-Za-z]*`,
+	).Replace(regexp.QuoteMeta(conflictTemplate(template, name)))
+	return regexp.MustCompile("^" + exp + "$")
+}
+
+var errNoSuchConflict = errors.New("no such conflict")
+
+// parseConflictResolution returns the resolution of a recorded conflict
+// chosen by the user: "mine" for the local change in the conflict copy,
+// "theirs" for the file as pulled, or "both".
+func parseConflictResolution(keep string) (conflictResolution, error) {
+	switch keep {
+	case "mine":
+		return conflictLocalWins, nil
+	case "theirs":
+		return conflictRemoteWins, nil
+	case "both":
+		return conflictKeepBoth, nil
+	}
+	return 0, fmt.Errorf("unknown conflict resolution %q", keep)
+}
+
+// Conflicts returns the unresolved conflicts in the folder. Conflicts whose
+// copy has been removed by other means are forgotten.
+func (m *Model) Conflicts(folder string) []db.Conflict {
+	m.fmut.RLock()
+	folderCfg, ok := m.folderCfgs[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	repo := db.NewConflictRepo(m.db, folder)
+	conflicts := repo.List()
+	res := conflicts[:0]
+	for _, c := range conflicts {
+		if _, err := osutil.Lstat(filepath.Join(folderCfg.Path(), c.CopyName)); os.IsNotExist(err) {
+			repo.Delete(c.CopyName)
+			continue
+		}
+		res = append(res, c)
+	}
+	return res
+}
+
+// ResolveConflict resolves the conflict with the given conflict copy. With
+// "mine" the copy replaces the file, with "theirs" the copy is removed, and
+// with "both" the file and the copy are renamed to name and newCopyName
+// respectively, unless those are empty. The affected files are rescanned so
+// that the result is announced to the other devices.
+func (m *Model) ResolveConflict(folder, copyName, keep, name, newCopyName string) error {
+	m.fmut.RLock()
+	folderCfg, ok := m.folderCfgs[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		return errors.New("no such folder")
+	}
+
+	resolution, err := parseConflictResolution(keep)
+	if err != nil {
+		return err
+	}
+
+	repo := db.NewConflictRepo(m.db, folder)
+	c, ok := repo.Get(copyName)
+	if !ok {
+		return errNoSuchConflict
+	}
+
+	dir := folderCfg.Path()
+	subs := []string{c.Name, c.CopyName}
+	switch resolution {
+	case conflictLocalWins:
+		err = osutil.Rename(filepath.Join(dir, c.CopyName), filepath.Join(dir, c.Name))
+
+	case conflictRemoteWins:
+		err = osutil.InWritableDir(osutil.Remove, filepath.Join(dir, c.CopyName))
+
+	case conflictKeepBoth:
+		var renamed []string
+		renamed, err = renameBoth(dir, [][2]string{{c.Name, name}, {c.CopyName, newCopyName}})
+		subs = append(subs, renamed...)
+	}
+	if err != nil {
+		return err
+	}
+
+	repo.Delete(copyName)
+	return m.ScanFolderSubs(folder, subs)
+}
+
+// renameBoth renames the files from the first to the second name of each
+// pair, skipping empty new names, and returns the new names. Either all of
+// the files are renamed, or, as far as possible, none.
+func renameBoth(dir string, renames [][2]string) ([]string, error) {
+	var names []string
+	var done [][2]string // paths renamed from, to
+	for _, r := range renames {
+		if r[1] == "" || r[1] == r[0] {
+			continue
+		}
+		from := filepath.Join(dir, r[0])
+		target, err := conflictTarget(dir, r[1])
+		if err == nil {
+			err = osutil.Rename(from, target)
+		}
+		if err != nil {
+			for i := len(done) - 1; i >= 0; i-- {
+				if rerr := osutil.Rename(done[i][1], done[i][0]); rerr != nil {
+					l.Warnf("Undoing rename of %s to %s: %v", done[i][0], done[i][1], rerr)
+				}
+			}
+			return nil, err
+		}
+		done = append(done, [2]string{from, target})
+		names = append(names, r[1])
+	}
+	return names, nil
+}
+
+// conflictTarget returns the path of the new name for a file in conflict,
+// which must be inside the folder and must not exist yet.
+func conflictTarget(dir, name string) (string, error) {
+	name = filepath.Clean(osutil.NativeFilename(name))
+	if filepath.IsAbs(name) || name == "." || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+		return "", errors.New("invalid name")
+	}
+	target := filepath.Join(dir, name)
+	if _, err := osutil.Lstat(target); err == nil {
+		return "", fmt.Errorf("%s already exists", name)
+	}
+	return target, nil
+}
+
+type byModTime []os.FileInfo
+
+func (s byModTime) Len() int           { return len(s) }
+func (s byModTime) Less(a, b int) bool { return s[a].ModTime().Before(s[b].ModTime()) }
+func (s byModTime) Swap(a, b int)      { s[a], s[b] = s[b], s[a] }
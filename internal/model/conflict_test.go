@@ -0,0 +1,255 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/db"
+)
+
+func TestConflictName(t *testing.T) {
+	when := time.Date(2015, 7, 1, 12, 34, 56, 0, time.Local)
+	cases := []struct {
+		template, name, expected string
+	}{
+		{"", "foo.txt", "foo.sync-conflict-20150701-123456-ABCDEFG.txt"},
+		{"", filepath.Join("dir", "foo"), filepath.Join("dir", "foo.sync-conflict-20150701-123456-ABCDEFG")},
+		{"%NAME% (%DEVICE% %DATE%)%EXT%", "foo.txt", "foo (ABCDEFG 20150701).txt"},
+		// Templates that would overwrite the file or put the copy elsewhere
+		// are replaced by the default.
+		{"%NAME%%EXT%", "foo.txt", "foo.sync-conflict-20150701-123456-ABCDEFG.txt"},
+		{"../%NAME%-%DATE%%EXT%", "foo.txt", "foo.sync-conflict-20150701-123456-ABCDEFG.txt"},
+	}
+
+	for _, tc := range cases {
+		if res := conflictName(tc.template, tc.name, "ABCDEFG", when); res != tc.expected {
+			t.Errorf("conflictName(%q, %q) = %q, expected %q", tc.template, tc.name, res, tc.expected)
+		}
+		if exp := conflictRegexp(tc.template, tc.name); !exp.MatchString(filepath.Base(tc.expected)) {
+			t.Errorf("conflictRegexp(%q, %q) doesn't match %q", tc.template, tc.name, tc.expected)
+		}
+	}
+
+	exp := conflictRegexp("", "foo")
+	for _, name := range []string{"foo", "foo.txt.sync-conflict-20150701-123456-ABCDEFG", "foo.sync-conflict-20150701-123456-ABCDEFG.txt"} {
+		if exp.MatchString(name) {
+			t.Errorf("Conflict copy expression for foo matches %q", name)
+		}
+	}
+}
+
+func TestResolveConflict(t *testing.T) {
+	local := protocol.FileInfo{
+		Name:     "foo",
+		Modified: 1000,
+		Version:  protocol.Vector{{ID: 1, Value: 1}, {ID: 2, Value: 1}},
+	}
+	remote := protocol.FileInfo{
+		Name:     "foo",
+		Modified: 2000,
+		Version:  protocol.Vector{{ID: 1, Value: 1}, {ID: device1.Short(), Value: 1}},
+	}
+
+	cases := []struct {
+		policy   config.ConflictPolicy
+		winner   protocol.DeviceID
+		expected conflictResolution
+	}{
+		{config.ConflictKeepBoth, protocol.DeviceID{}, conflictKeepBoth},
+		{config.ConflictNewestWins, protocol.DeviceID{}, conflictRemoteWins},
+		{config.ConflictLocalWins, protocol.DeviceID{}, conflictLocalWins},
+		{config.ConflictDeviceWins, device1, conflictRemoteWins},
+		{config.ConflictDeviceWins, device2, conflictKeepBoth},
+	}
+
+	for _, tc := range cases {
+		p := rwFolder{conflictPolicy: tc.policy, conflictWinner: tc.winner}
+		if res := p.resolveConflict(local, remote); res != tc.expected {
+			t.Errorf("Policy %v (%v): resolution %v, expected %v", tc.policy, tc.winner, res, tc.expected)
+		}
+	}
+
+	if dev := losingDevice(local.Version, remote.Version); dev != 2 {
+		t.Errorf("Losing device %d, expected 2", dev)
+	}
+}
+
+func TestPruneConflicts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conflicts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	names := []string{
+		"foo.sync-conflict-20150101-000000-AAAAAAA.txt",
+		"foo.sync-conflict-20150102-000000-BBBBBBB.txt",
+		"foo.sync-conflict-20150103-000000-CCCCCCC.txt",
+		"foo.txt",
+		"bar.sync-conflict-20150101-000000-AAAAAAA.txt",
+	}
+	then := time.Now().Add(-time.Hour)
+	for i, name := range names {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := then.Add(time.Duration(i) * time.Minute)
+		os.Chtimes(path, mtime, mtime)
+	}
+
+	p := rwFolder{maxConflicts: 2}
+	p.pruneConflicts(filepath.Join(dir, "foo.txt"))
+
+	for i, name := range names {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if exists := err == nil; exists != (i != 0) {
+			t.Errorf("%s: exists %v", name, exists)
+		}
+	}
+}
+
+func TestMoveForConflictNoOverwrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conflicts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "foo.txt")
+	p := rwFolder{conflictName: "%NAME%-conflict%EXT%"}
+
+	var copies []string
+	for _, data := range []string{"first", "second"} {
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		name, err := p.moveForConflict(path, "ABCDEFG")
+		if err != nil {
+			t.Fatal(err)
+		}
+		copies = append(copies, name)
+	}
+
+	if copies[0] != filepath.Join(dir, "foo-conflict.txt") || copies[1] == copies[0] {
+		t.Fatalf("Incorrect conflict copies %v", copies)
+	}
+	for i, data := range []string{"first", "second"} {
+		if bs, _ := ioutil.ReadFile(copies[i]); string(bs) != data {
+			t.Errorf("%s contains %q, expected %q", copies[i], bs, data)
+		}
+	}
+
+	// An existing file the template happens to name is left alone.
+	bak := filepath.Join(dir, "foo.bak")
+	if err := ioutil.WriteFile(bak, []byte("user data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("third"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p.conflictName = "%NAME%.bak"
+	if name, err := p.moveForConflict(path, "HIJKLMN"); err != nil || name == bak {
+		t.Fatalf("Conflict copy %q, error %v", name, err)
+	}
+	if bs, _ := ioutil.ReadFile(bak); string(bs) != "user data" {
+		t.Errorf("Existing file overwritten with %q", bs)
+	}
+}
+
+func TestRecordedConflicts(t *testing.T) {
+	m, dir := setupFolderModel(t, config.FolderConfiguration{ID: "c"})
+	defer os.RemoveAll(dir)
+
+	local := protocol.Vector{{ID: protocol.LocalDeviceID.Short(), Value: 1}}
+	remote := protocol.FileInfo{
+		Name:    "foo.txt",
+		Version: protocol.Vector{{ID: device1.Short(), Value: 1}},
+	}
+	p := rwFolder{model: m, folder: "c", dir: dir, shortID: m.shortID}
+
+	// Simulates the puller replacing our version of the file.
+	conflict := func() db.Conflict {
+		path := filepath.Join(dir, "foo.txt")
+		if err := ioutil.WriteFile(path, []byte("mine"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.conflictCopy(path, local, remote, conflictKeepBoth); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("theirs"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		conflicts := m.Conflicts("c")
+		if len(conflicts) != 1 {
+			t.Fatalf("Incorrect conflicts %v", conflicts)
+		}
+		return conflicts[0]
+	}
+
+	c := conflict()
+	if c.Name != "foo.txt" || c.LocalDevice != protocol.LocalDeviceID.String() || c.RemoteDevice != device1.String() {
+		t.Errorf("Incorrect conflict %+v", c)
+	}
+	if !c.LocalVersion.Equal(local) || !c.RemoteVersion.Equal(remote.Version) {
+		t.Errorf("Incorrect conflict versions %+v", c)
+	}
+
+	if err := m.ResolveConflict("c", c.CopyName, "mine", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if bs, _ := ioutil.ReadFile(filepath.Join(dir, "foo.txt")); string(bs) != "mine" {
+		t.Errorf("Keeping mine left %q", bs)
+	}
+	if conflicts := m.Conflicts("c"); len(conflicts) != 0 {
+		t.Errorf("Unexpected conflicts after resolving %v", conflicts)
+	}
+	if err := m.ResolveConflict("c", c.CopyName, "mine", "", ""); err != errNoSuchConflict {
+		t.Errorf("Unexpected error %v resolving twice", err)
+	}
+
+	c = conflict()
+	if err := m.ResolveConflict("c", c.CopyName, "nothing", "", ""); err == nil {
+		t.Error("Unexpected nil error with an unknown resolution")
+	}
+	// The second rename fails, so the first one is undone.
+	if err := m.ResolveConflict("c", c.CopyName, "both", "same.txt", "same.txt"); err == nil {
+		t.Error("Unexpected nil error renaming both to the same name")
+	}
+	if bs, _ := ioutil.ReadFile(filepath.Join(dir, "foo.txt")); string(bs) != "theirs" {
+		t.Errorf("Failed rename left %q", bs)
+	}
+	if _, ok := db.NewConflictRepo(m.db, "c").Get(c.CopyName); !ok {
+		t.Error("Conflict forgotten after failed rename")
+	}
+	if err := m.ResolveConflict("c", c.CopyName, "both", "", "foo.txt"); err == nil {
+		t.Error("Unexpected nil error renaming on top of an existing file")
+	}
+	if err := m.ResolveConflict("c", c.CopyName, "both", "", "../foo-mine.txt"); err == nil {
+		t.Error("Unexpected nil error renaming outside of the folder")
+	}
+	if err := m.ResolveConflict("c", c.CopyName, "both", "", "foo-mine.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if bs, _ := ioutil.ReadFile(filepath.Join(dir, "foo-mine.txt")); string(bs) != "mine" {
+		t.Errorf("Keeping both left %q", bs)
+	}
+	if bs, _ := ioutil.ReadFile(filepath.Join(dir, "foo.txt")); string(bs) != "theirs" {
+		t.Errorf("Keeping both left %q", bs)
+	}
+	if fi, ok := m.CurrentFolderFile("c", "foo-mine.txt"); !ok || fi.IsDeleted() {
+		t.Error("Renamed conflict copy should be in the index")
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"time"
+)
+
+// Files that changed while being hashed are scanned again after at least
+// this long, also in folders without a minimum file age.
+var deferredScanMinDelay = 10 * time.Second
+
+// A deferredScan is a scan, yet to come, of the files that were left out of
+// previous scans of a folder.
+type deferredScan struct {
+	names map[string]struct{}
+	timer *time.Timer
+}
+
+// deferScan schedules a scan of the files of the folder after the delay.
+// Files deferred while a scan is already pending are added to it.
+func (m *Model) deferScan(folder string, names []string, delay time.Duration) {
+	if delay < deferredScanMinDelay {
+		delay = deferredScanMinDelay
+	}
+
+	m.scanMut.Lock()
+	defer m.scanMut.Unlock()
+
+	ds, ok := m.deferredScans[folder]
+	if !ok {
+		ds = &deferredScan{
+			names: make(map[string]struct{}),
+		}
+		ds.timer = time.AfterFunc(delay, func() {
+			m.runDeferredScan(folder)
+		})
+		m.deferredScans[folder] = ds
+	}
+	for _, name := range names {
+		ds.names[name] = struct{}{}
+	}
+
+	if debug {
+		l.Debugf("folder %q: %d files deferred to a scan in %v", folder, len(ds.names), delay)
+	}
+}
+
+func (m *Model) runDeferredScan(folder string) {
+	m.scanMut.Lock()
+	ds := m.deferredScans[folder]
+	delete(m.deferredScans, folder)
+	m.scanMut.Unlock()
+	if ds == nil {
+		return
+	}
+
+	subs := make([]string, 0, len(ds.names))
+	for name := range ds.names {
+		subs = append(subs, name)
+	}
+	if err := m.ScanFolderSubs(folder, subs); err != nil && debug {
+		l.Debugf("folder %q: deferred scan: %v", folder, err)
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/events"
+)
+
+// When a pull would delete more files than the folder's MaxDeletes, or more
+// than MaxDeletesPct percent of them, the deletions are held back until the
+// user approves or rejects them. This keeps a device whose copy of the folder
+// has been emptied by accident from taking everyone else's copies with it.
+// The rest of the pull goes ahead as usual.
+
+var errNoPendingDeletions = errors.New("no deletions pending approval")
+
+type pendingDeletions struct {
+	names    []string        // held back, sorted
+	approved map[string]bool // may be deleted regardless of the thresholds
+}
+
+// tooManyDeletions returns true if deleting n of the total items in a folder
+// exceeds the given thresholds. Thresholds of zero or less are disabled.
+func tooManyDeletions(n, total, max, maxPct int) bool {
+	if max > 0 && n > max {
+		return true
+	}
+	if maxPct > 0 && total > 0 && n*100 > maxPct*total {
+		return true
+	}
+	return false
+}
+
+// heldDeletions returns the deletions in the current pull that are held back
+// for approval, if any, and records them as pending.
+func (p *rwFolder) heldDeletions(files map[string]protocol.FileInfo, dirs []protocol.FileInfo) map[string]bool {
+	if p.maxDeletes <= 0 && p.maxDeletesPct <= 0 {
+		return nil
+	}
+
+	names := p.deletionsToHold(files, dirs)
+	p.model.setPendingDeletions(p.folder, names)
+	if len(names) == 0 {
+		return nil
+	}
+
+	held := make(map[string]bool, len(names))
+	for _, name := range names {
+		held[name] = true
+	}
+	return held
+}
+
+// deletionsToHold returns the sorted names of the deletions that would be
+// held back for approval; none if the deletions that haven't been approved
+// yet are within the limits.
+func (p *rwFolder) deletionsToHold(files map[string]protocol.FileInfo, dirs []protocol.FileInfo) []string {
+	if p.maxDeletes <= 0 && p.maxDeletesPct <= 0 {
+		return nil
+	}
+
+	approved := p.model.approvedDeletions(p.folder)
+	var names []string
+	for name := range files {
+		if !approved[name] {
+			names = append(names, name)
+		}
+	}
+	for _, dir := range dirs {
+		if !approved[dir.Name] {
+			names = append(names, dir.Name)
+		}
+	}
+
+	total, _, _ := p.model.LocalSize(p.folder)
+	if !tooManyDeletions(len(names), total, p.maxDeletes, p.maxDeletesPct) {
+		return nil
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// setPendingDeletions records the deletions held back in the folder, emitting
+// an event when they change. Clearing them also forgets any approvals, as
+// they have been acted upon by then.
+func (m *Model) setPendingDeletions(folder string, names []string) {
+	m.delMut.Lock()
+	defer m.delMut.Unlock()
+
+	pd, ok := m.deletions[folder]
+	if len(names) == 0 {
+		delete(m.deletions, folder)
+		return
+	}
+	if !ok {
+		pd = &pendingDeletions{}
+		m.deletions[folder] = pd
+	} else if equalStrings(pd.names, names) {
+		return
+	}
+	pd.names = names
+
+	l.Warnf("Folder %q: holding back %d deletions for approval", folder, len(names))
+	events.Default.Log(events.FolderDeletionsPending, map[string]interface{}{
+		"folder": folder,
+		"count":  len(names),
+	})
+}
+
+func (m *Model) approvedDeletions(folder string) map[string]bool {
+	m.delMut.Lock()
+	defer m.delMut.Unlock()
+
+	if pd, ok := m.deletions[folder]; ok {
+		return pd.approved
+	}
+	return nil
+}
+
+// deletionsPending returns true if deletions are held back in the folder.
+func (m *Model) deletionsPending(folder string) bool {
+	m.delMut.Lock()
+	defer m.delMut.Unlock()
+
+	pd, ok := m.deletions[folder]
+	return ok && len(pd.names) > len(pd.approved)
+}
+
+// PendingDeletions returns the names of the files and directories whose
+// deletion is held back for approval in the given folder.
+func (m *Model) PendingDeletions(folder string) []string {
+	m.delMut.Lock()
+	defer m.delMut.Unlock()
+
+	names := []string{}
+	if pd, ok := m.deletions[folder]; ok {
+		for _, name := range pd.names {
+			if !pd.approved[name] {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// ApproveDeletions lets the puller go ahead with the deletions held back in
+// the given folder.
+func (m *Model) ApproveDeletions(folder string) error {
+	m.fmut.RLock()
+	runner, ok := m.folderRunners[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		return errors.New("no such folder")
+	}
+
+	m.delMut.Lock()
+	pd, ok := m.deletions[folder]
+	if !ok {
+		m.delMut.Unlock()
+		return errNoPendingDeletions
+	}
+	pd.approved = make(map[string]bool, len(pd.names))
+	for _, name := range pd.names {
+		pd.approved[name] = true
+	}
+	m.delMut.Unlock()
+
+	l.Infof("Folder %q: %d deletions approved", folder, len(pd.names))
+	runner.IndexUpdated()
+	return nil
+}
+
+// RejectDeletions keeps the files whose deletion is held back in the given
+// folder. Our versions of them become the newest ones in the cluster, so
+// they are sent to the devices that deleted them.
+func (m *Model) RejectDeletions(folder string) error {
+	m.fmut.RLock()
+	fs, ok := m.folderFiles[folder]
+	runner := m.folderRunners[folder]
+	m.fmut.RUnlock()
+	if !ok || runner == nil {
+		return errors.New("no such folder")
+	}
+
+	m.delMut.Lock()
+	pd, ok := m.deletions[folder]
+	delete(m.deletions, folder)
+	m.delMut.Unlock()
+	if !ok {
+		return errNoPendingDeletions
+	}
+
+	batch := make([]protocol.FileInfo, 0, indexBatchSize)
+	for _, name := range pd.names {
+		if pd.approved[name] {
+			continue
+		}
+		have, ok := fs.Get(protocol.LocalDeviceID, name)
+		if !ok || have.IsDeleted() {
+			continue
+		}
+		gf, ok := fs.GetGlobal(name)
+		if !ok {
+			continue
+		}
+		have.Version = have.Version.Merge(gf.Version).Update(m.shortID)
+		have.LocalVersion = 0
+		batch = append(batch, have)
+		if len(batch) == indexBatchSize {
+			m.updateLocals(folder, batch)
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		m.updateLocals(folder, batch)
+	}
+
+	l.Infof("Folder %q: %d deletions rejected", folder, len(pd.names))
+	runner.IndexUpdated()
+	return nil
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
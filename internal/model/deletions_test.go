@@ -0,0 +1,117 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/config"
+)
+
+func TestTooManyDeletions(t *testing.T) {
+	cases := []struct {
+		n, total, max, maxPct int
+		expected              bool
+	}{
+		{100, 100, 0, 0, false},
+		{10, 100, 10, 0, false},
+		{11, 100, 10, 0, true},
+		{50, 100, 0, 50, false},
+		{51, 100, 0, 50, true},
+		{5, 0, 0, 50, false},
+		{11, 1000, 100, 1, true},
+	}
+
+	for i, tc := range cases {
+		if res := tooManyDeletions(tc.n, tc.total, tc.max, tc.maxPct); res != tc.expected {
+			t.Errorf("%d: tooManyDeletions(%d, %d, %d, %d) = %v, expected %v", i, tc.n, tc.total, tc.max, tc.maxPct, res, tc.expected)
+		}
+	}
+}
+
+func TestHeldDeletions(t *testing.T) {
+	m, dir := setupFolderModel(t, config.FolderConfiguration{ID: "d"})
+	defer os.RemoveAll(dir)
+
+	names := []string{".stfolder", "a", "b", "c"}
+	for _, name := range names {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.ScanFolder("d"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The other device deletes everything.
+	deleted := func() map[string]protocol.FileInfo {
+		files := make(map[string]protocol.FileInfo)
+		var index []protocol.FileInfo
+		for _, name := range names {
+			f, _ := m.CurrentFolderFile("d", name)
+			f.Flags |= protocol.FlagDeleted
+			f.Blocks = nil
+			f.Version = f.Version.Update(device1.Short())
+			files[name] = f
+			index = append(index, f)
+		}
+		m.Index(device1, "d", index, 0, nil)
+		return files
+	}
+
+	p := rwFolder{model: m, folder: "d", maxDeletesPct: 50}
+
+	files := deleted()
+	if held := p.heldDeletions(files, nil); len(held) != len(names) {
+		t.Fatalf("Incorrect held deletions %v", held)
+	}
+	if pending := m.PendingDeletions("d"); len(pending) != len(names) || pending[1] != "a" {
+		t.Errorf("Incorrect pending deletions %v", pending)
+	}
+	if !m.deletionsPending("d") {
+		t.Error("Deletions should be pending")
+	}
+
+	if err := m.ApproveDeletions("d"); err != nil {
+		t.Fatal(err)
+	}
+	// A plan sees the approval too, without acting on it.
+	if names := p.deletionsToHold(files, nil); len(names) != 0 {
+		t.Errorf("Unexpected deletions to hold after approval %v", names)
+	}
+	if approved := m.approvedDeletions("d"); len(approved) != len(names) {
+		t.Errorf("Approvals forgotten by the plan: %v", approved)
+	}
+	if held := p.heldDeletions(files, nil); len(held) != 0 {
+		t.Errorf("Unexpected held deletions after approval %v", held)
+	}
+	if m.deletionsPending("d") {
+		t.Error("Deletions should no longer be pending")
+	}
+	if err := m.ApproveDeletions("d"); err != errNoPendingDeletions {
+		t.Errorf("Unexpected error %v approving twice", err)
+	}
+
+	// Rejecting makes our versions of the files the current ones.
+	p.heldDeletions(files, nil)
+	if err := m.RejectDeletions("d"); err != nil {
+		t.Fatal(err)
+	}
+	if pending := m.PendingDeletions("d"); len(pending) != 0 {
+		t.Errorf("Unexpected pending deletions after rejecting %v", pending)
+	}
+	if need, _ := m.NeedSize("d"); need != 0 {
+		t.Errorf("Need %d files after rejecting deletions", need)
+	}
+	if gf, _ := m.CurrentGlobalFile("d", "a"); gf.IsDeleted() {
+		t.Error("Rejected deletion should not be the global version")
+	}
+}
@@ -7,37 +7,123 @@
 package model
 
 import (
+	stdsync "sync"
+	"time"
+
 	"github.com/syncthing/protocol"
 	"github.com/syncthing/syncthing/internal/sync"
 )
 
+const (
+	// The request window of a device is sized to hold about this much
+	// transfer time worth of requests, based on the measured throughput. As
+	// long as requests complete faster than this the window keeps growing.
+	windowDuration = 2 * time.Second
+	minWindow      = 2
+	maxWindow      = 64
+	defaultWindow  = 4
+
+	// Throughput is sampled over at least rateInterval. Samples spanning
+	// more than maxRateInterval include idle time and are discarded.
+	rateInterval    = time.Second
+	maxRateInterval = 10 * time.Second
+)
+
 // deviceActivity tracks the number of outstanding requests per device and can
 // answer which device is least busy. It is safe for use from multiple
 // goroutines.
 type deviceActivity struct {
-	act map[protocol.DeviceID]int
-	mut sync.Mutex
+	act   map[protocol.DeviceID]int
+	rates map[protocol.DeviceID]*deviceRate
+	mut   sync.Mutex
+	cond  *stdsync.Cond
+}
+
+// deviceRate is the measured throughput of a device.
+type deviceRate struct {
+	rate    float64 // bytes per second, moving average
+	reqSize float64 // bytes per request, moving average
+	bytes   int64   // bytes received in the current sample
+	since   time.Time
 }
 
 func newDeviceActivity() *deviceActivity {
-	return &deviceActivity{
-		act: make(map[protocol.DeviceID]int),
-		mut: sync.NewMutex(),
+	m := &deviceActivity{
+		act:   make(map[protocol.DeviceID]int),
+		rates: make(map[protocol.DeviceID]*deviceRate),
+		mut:   sync.NewMutex(),
 	}
+	m.cond = stdsync.NewCond(m.mut)
+	return m
 }
 
+// leastBusy returns the device with the lowest number of outstanding requests
+// relative to its request window, preferring devices that have room left in
+// their window.
 func (m *deviceActivity) leastBusy(availability []protocol.DeviceID) protocol.DeviceID {
 	m.mut.Lock()
-	low := 2<<30 - 1
+	selected, _ := m.leastBusyLocked(availability)
+	m.mut.Unlock()
+	return selected
+}
+
+// acquire waits until one of the devices has room in its request window and
+// marks it as in use, as with using. It returns the empty device ID if there
+// are no devices to choose from.
+func (m *deviceActivity) acquire(availability []protocol.DeviceID) protocol.DeviceID {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	for {
+		selected, free := m.leastBusyLocked(availability)
+		if selected == (protocol.DeviceID{}) {
+			return selected
+		}
+		if free {
+			m.act[selected]++
+			return selected
+		}
+		m.cond.Wait()
+	}
+}
+
+func (m *deviceActivity) leastBusyLocked(availability []protocol.DeviceID) (protocol.DeviceID, bool) {
 	var selected protocol.DeviceID
+	var low float64
+	var free bool
 	for _, device := range availability {
-		if usage := m.act[device]; usage < low {
-			low = usage
+		window := m.windowLocked(device)
+		usage := m.act[device]
+		load := float64(usage) / float64(window)
+		hasRoom := usage < window
+		if selected == (protocol.DeviceID{}) || hasRoom && !free || hasRoom == free && load < low {
 			selected = device
+			low = load
+			free = hasRoom
 		}
 	}
-	m.mut.Unlock()
-	return selected
+	return selected, free
+}
+
+// window returns the number of outstanding requests we allow to the device.
+func (m *deviceActivity) window(device protocol.DeviceID) int {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return m.windowLocked(device)
+}
+
+func (m *deviceActivity) windowLocked(device protocol.DeviceID) int {
+	r, ok := m.rates[device]
+	if !ok || r.rate == 0 || r.reqSize == 0 {
+		return defaultWindow
+	}
+	window := int(r.rate*windowDuration.Seconds()/r.reqSize) + 1
+	if window < minWindow {
+		return minWindow
+	}
+	if window > maxWindow {
+		return maxWindow
+	}
+	return window
 }
 
 func (m *deviceActivity) using(device protocol.DeviceID) {
@@ -50,4 +136,45 @@ func (m *deviceActivity) done(device protocol.DeviceID) {
 	m.mut.Lock()
 	m.act[device]--
 	m.mut.Unlock()
+	m.cond.Broadcast()
+}
+
+// transferred records a completed request of the given size, updating the
+// measured throughput of the device.
+func (m *deviceActivity) transferred(device protocol.DeviceID, bytes int) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	now := time.Now()
+	r, ok := m.rates[device]
+	if !ok {
+		r = &deviceRate{since: now}
+		m.rates[device] = r
+	}
+
+	if r.reqSize == 0 {
+		r.reqSize = float64(bytes)
+	} else {
+		r.reqSize = 0.9*r.reqSize + 0.1*float64(bytes)
+	}
+
+	elapsed := now.Sub(r.since)
+	if elapsed > maxRateInterval {
+		// The device has been idle; don't count that as slowness.
+		r.bytes = int64(bytes)
+		r.since = now
+		return
+	}
+
+	r.bytes += int64(bytes)
+	if elapsed >= rateInterval {
+		sample := float64(r.bytes) / elapsed.Seconds()
+		if r.rate == 0 {
+			r.rate = sample
+		} else {
+			r.rate = 0.7*r.rate + 0.3*sample
+		}
+		r.bytes = 0
+		r.since = now
+	}
 }
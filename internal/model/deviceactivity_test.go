@@ -8,6 +8,7 @@ package model
 
 import (
 	"testing"
+	"time"
 
 	"github.com/syncthing/protocol"
 )
@@ -56,3 +57,55 @@ func TestDeviceActivity(t *testing.T) {
 		t.Errorf("Least busy device should be n0 (%v) not %v", n0, lb)
 	}
 }
+
+func TestDeviceActivityWindow(t *testing.T) {
+	n0 := protocol.DeviceID([32]byte{1, 2, 3, 4})
+	n1 := protocol.DeviceID([32]byte{5, 6, 7, 8})
+	devices := []protocol.DeviceID{n0, n1}
+	na := newDeviceActivity()
+
+	if w := na.window(n0); w != defaultWindow {
+		t.Errorf("Unmeasured window %d != expected %d", w, defaultWindow)
+	}
+
+	// n0 transfers 1 MB/s in 128 KiB requests, so the window should hold two
+	// seconds worth of those.
+	na.rates[n0] = &deviceRate{rate: 1 << 20, reqSize: 128 << 10, since: time.Now()}
+	if w := na.window(n0); w != 17 {
+		t.Errorf("Window %d != expected 17", w)
+	}
+
+	// A slow device gets the minimum window, which once full makes the
+	// other device preferred even though it has more outstanding requests.
+	na.rates[n0] = &deviceRate{rate: 1 << 10, reqSize: 128 << 10, since: time.Now()}
+	for i := 0; i < minWindow; i++ {
+		if d := na.acquire(devices[:1]); d != n0 {
+			t.Fatalf("Acquired %v, expected n0", d)
+		}
+	}
+	for i := 0; i < defaultWindow-1; i++ {
+		na.using(n1)
+	}
+	if lb := na.leastBusy(devices); lb != n1 {
+		t.Errorf("Least busy device should be n1 (%v) not %v", n1, lb)
+	}
+
+	acquired := make(chan protocol.DeviceID)
+	go func() {
+		acquired <- na.acquire(devices[:1])
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("Acquired device with a full window")
+	case <-time.After(50 * time.Millisecond):
+	}
+	na.done(n0)
+	select {
+	case d := <-acquired:
+		if d != n0 {
+			t.Errorf("Acquired %v, expected n0", d)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for acquire")
+	}
+}
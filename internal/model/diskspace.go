@@ -0,0 +1,93 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/syncthing/protocol"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/events"
+	"github.com/syncthing/syncthing/internal/osutil"
+	"github.com/syncthing/syncthing/internal/scanner"
+)
+
+// A diskSpaceError is returned when the free space on the disk of a folder
+// is, or would be after using the needed bytes, below the configured minimum.
+type diskSpaceError struct {
+	free, needed, min int64
+}
+
+func (e diskSpaceError) Error() string {
+	if e.needed > 0 {
+		return fmt.Sprintf("insufficient free disk space (%d bytes free, %d bytes needed, minimum %d bytes)", e.free, e.needed, e.min)
+	}
+	return fmt.Sprintf("insufficient free disk space (%d bytes free, minimum %d bytes)", e.free, e.min)
+}
+
+// checkDiskSpace returns a diskSpaceError if the free space on the folder's
+// disk, less the needed bytes, is below the folder's or the global minimum.
+// If the free space can't be determined we don't stand in the way of
+// pulling.
+func (p *rwFolder) checkDiskSpace(needed int64) error {
+	free, total, err := osutil.DiskFree(p.dir)
+	if err != nil {
+		if debug {
+			l.Debugln(p, "checking free disk space:", err)
+		}
+		return nil
+	}
+	return checkFree(free, total, needed, p.minDiskFree, p.model.cfg.Options().MinDiskFree)
+}
+
+func checkFree(free, total, needed int64, mins ...config.Size) error {
+	for _, min := range mins {
+		if b := min.Bytes(total); free-needed < b {
+			return diskSpaceError{free, needed, b}
+		}
+	}
+	return nil
+}
+
+// neededSpace returns how many bytes pulling the file adds to the disk. A
+// metadata only change adds nothing, and a temporary file left over from an
+// earlier attempt already takes up part of the space.
+func (p *rwFolder) neededSpace(file protocol.FileInfo) int64 {
+	if file.IsDeleted() || file.IsDirectory() {
+		return 0
+	}
+	if cur, ok := p.model.CurrentFolderFile(p.folder, file.Name); ok && scanner.BlocksEqual(cur.Blocks, file.Blocks) {
+		return 0
+	}
+
+	needed := file.Size()
+	tempName := filepath.Join(p.dir, defTempNamer.TempName(file.Name))
+	if info, err := osutil.Lstat(tempName); err == nil && info.Mode().IsRegular() {
+		needed -= info.Size()
+	}
+	if needed < 0 {
+		return 0
+	}
+	return needed
+}
+
+// outOfDiskSpace stops pulling until there is enough free space again. The
+// pull timer keeps running, so pulling resumes by itself.
+func (p *rwFolder) outOfDiskSpace(err error) {
+	if !p.diskFull {
+		l.Warnf("Folder %q: pulling stopped: %v", p.folder, err)
+		events.Default.Log(events.FolderOutOfDiskSpace, map[string]interface{}{
+			"folder": p.folder,
+			"error":  err.Error(),
+		})
+		p.diskFull = true
+	}
+	p.setState(FolderOutOfDiskSpace)
+	p.pullTimer.Reset(nextPullIntv)
+}
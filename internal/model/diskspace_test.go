@@ -0,0 +1,58 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestCheckFree(t *testing.T) {
+	cases := []struct {
+		free, total, needed int64
+		mins                []config.Size
+		ok                  bool
+	}{
+		{100, 1000, 0, nil, true},
+		{100, 1000, 0, []config.Size{{}, {}}, true},
+		{100, 1000, 0, []config.Size{{Value: 10, Unit: "%"}}, true},
+		{99, 1000, 0, []config.Size{{Value: 10, Unit: "%"}}, false},
+		{100, 1000, 0, []config.Size{{}, {Value: 100}}, true},
+		{100, 1000, 0, []config.Size{{Value: 1, Unit: "%"}, {Value: 1, Unit: "kB"}}, false},
+		// The file being pulled counts against the free space.
+		{200, 1000, 100, []config.Size{{Value: 10, Unit: "%"}}, true},
+		{200, 1000, 101, []config.Size{{Value: 10, Unit: "%"}}, false},
+		{100, 1000, 101, nil, true},
+	}
+
+	for i, tc := range cases {
+		err := checkFree(tc.free, tc.total, tc.needed, tc.mins...)
+		if ok := err == nil; ok != tc.ok {
+			t.Errorf("%d: unexpected result %v", i, err)
+		}
+	}
+}
+
+func TestCheckDiskSpace(t *testing.T) {
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel(defaultConfig, protocol.LocalDeviceID, "device", "syncthing", "dev", db)
+
+	p := rwFolder{model: m, dir: "testdata"}
+	if err := p.checkDiskSpace(0); err != nil {
+		t.Error(err)
+	}
+
+	// There is always less than all of the disk free.
+	p.minDiskFree = config.Size{Value: 100, Unit: "%"}
+	if _, ok := p.checkDiskSpace(0).(diskSpaceError); !ok {
+		t.Error("Expected a disk space error")
+	}
+}
@@ -0,0 +1,515 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/db"
+	"github.com/syncthing/syncthing/internal/encryption"
+	"github.com/syncthing/syncthing/internal/events"
+	"github.com/syncthing/syncthing/internal/scanner"
+	"github.com/syncthing/syncthing/internal/symlinks"
+	"github.com/syncthing/syncthing/internal/sync"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// Devices that a folder is shared with in encrypted mode are sent the
+// encrypted form of the index and of the files, as described in package
+// encryption. They store and serve the encrypted files like any others.
+// Their index is decrypted when it's received, fetching the trailer of each
+// file from the device as necessary, and the blocks pulled from them are
+// decrypted on the way in.
+
+var (
+	errFileChanged  = errors.New("file changed while encrypting")
+	errNoEncryption = errors.New("no encryption password set for folder")
+)
+
+// isEncrypted returns true if the folder is shared with the device in
+// encrypted mode.
+func isEncrypted(cfg config.FolderConfiguration, device protocol.DeviceID) bool {
+	for _, dev := range cfg.Devices {
+		if dev.DeviceID == device {
+			return dev.Encrypted
+		}
+	}
+	return false
+}
+
+// encryptedDevice returns true if the folder is shared with the device in
+// encrypted mode, along with the folder's key. The key is nil if the folder
+// has no password, in which case nothing may be exchanged with the device.
+func (m *Model) encryptedDevice(folder string, device protocol.DeviceID) (*encryption.Key, bool) {
+	m.fmut.RLock()
+	defer m.fmut.RUnlock()
+	if !isEncrypted(m.folderCfgs[folder], device) {
+		return nil, false
+	}
+	return m.folderKeys[folder], true
+}
+
+// An encrypter turns the files of a folder into their encrypted form.
+type encrypter struct {
+	key  *encryption.Key
+	dir  string
+	repo *db.EncryptedRepo
+}
+
+func newEncrypter(ldb *leveldb.DB, cfg config.FolderConfiguration, key *encryption.Key) *encrypter {
+	return &encrypter{
+		key:  key,
+		dir:  cfg.Path(),
+		repo: db.NewEncryptedRepo(ldb, cfg.ID),
+	}
+}
+
+// encryptedFile returns the encrypted form of the given local file to be
+// announced to untrusted devices. Files that can't be read as announced are
+// marked invalid. Unless the device handles variable block sizes the
+// ciphertext is hashed in blocks of protocol.BlockSize.
+func (e *encrypter) encryptedFile(f protocol.FileInfo, variableBlocks bool) protocol.FileInfo {
+	if cached, ok := e.repo.Encrypted(f.Name); ok && cached.Version.Equal(f.Version) && cached.IsDeleted() == f.IsDeleted() && (!cached.IsDeleted() || len(cached.Blocks) == 1) && (variableBlocks || cached.IsDeleted() || scanner.BlockSizeOf(cached.Blocks) <= protocol.BlockSize) {
+		cached.LocalVersion = f.LocalVersion
+		return cached
+	}
+
+	enc := protocol.FileInfo{
+		Name:         e.key.EncryptName(f.Name),
+		Flags:        protocol.FlagNoPermBits | 0644,
+		Version:      f.Version,
+		LocalVersion: f.LocalVersion,
+	}
+
+	switch {
+	case f.IsDeleted():
+		// The only block carries the tombstone, in place of a hash.
+		enc.Flags |= protocol.FlagDeleted
+		enc.Blocks = []protocol.BlockInfo{{Hash: tombstone(e.key, f)}}
+	case f.IsInvalid():
+		enc.Flags |= protocol.FlagInvalid
+		return enc
+	default:
+		r := e.reader(f)
+		blockSize := protocol.BlockSize
+		if variableBlocks {
+			blockSize = 0
+		}
+		blocks, err := scanner.Blocks(io.NewSectionReader(r, 0, r.size()), blockSize, r.size())
+		r.close()
+		if err != nil {
+			if debug {
+				l.Debugln("encrypting", f.Name, err)
+			}
+			enc.Flags |= protocol.FlagInvalid
+			return enc
+		}
+		enc.Blocks = blocks
+	}
+
+	e.repo.Put(f, enc)
+	return enc
+}
+
+// An encryptedReader reads the encrypted form of a local file.
+type encryptedReader struct {
+	key     *encryption.Key
+	file    protocol.FileInfo
+	path    string
+	offsets []int64 // offset of each plaintext block
+	starts  []int64 // offset of each encrypted block, then of the trailer
+	trailer []byte
+
+	fd        io.ReaderAt
+	closer    io.Closer
+	cur       []byte // the last encrypted block
+	curOffset int64
+}
+
+func (e *encrypter) reader(f protocol.FileInfo) *encryptedReader {
+	plain := f
+	plain.LocalVersion = 0
+	plain.Flags &= protocol.FlagsAll
+	meta, err := plain.MarshalXDR()
+	if err != nil {
+		panic(err)
+	}
+
+	// Block offsets aren't kept in the database, so they're worked out
+	// here for the plaintext as well.
+	offsets := make([]int64, len(f.Blocks))
+	starts := make([]int64, len(f.Blocks)+1)
+	for i, b := range f.Blocks {
+		if i > 0 {
+			offsets[i] = offsets[i-1] + int64(f.Blocks[i-1].Size)
+		}
+		starts[i+1] = starts[i] + int64(b.Size) + encryption.BlockOverhead
+	}
+
+	return &encryptedReader{
+		key:       e.key,
+		file:      f,
+		path:      filepath.Join(e.dir, f.Name),
+		offsets:   offsets,
+		starts:    starts,
+		trailer:   e.key.EncryptTrailer(meta),
+		curOffset: -1,
+	}
+}
+
+func (r *encryptedReader) size() int64 {
+	return r.starts[len(r.starts)-1] + int64(len(r.trailer))
+}
+
+func (r *encryptedReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		seg, start, err := r.segment(pos)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], seg[pos-start:])
+	}
+	return n, nil
+}
+
+// segment returns the encrypted block or trailer at the given offset, and
+// the offset it starts at.
+func (r *encryptedReader) segment(pos int64) ([]byte, int64, error) {
+	if pos >= r.size() {
+		return nil, 0, io.EOF
+	}
+	trailerStart := r.starts[len(r.starts)-1]
+	if pos >= trailerStart {
+		return r.trailer, trailerStart, nil
+	}
+
+	i := sort.Search(len(r.file.Blocks), func(i int) bool {
+		return r.starts[i+1] > pos
+	})
+	if r.curOffset == r.starts[i] {
+		return r.cur, r.curOffset, nil
+	}
+
+	if r.fd == nil {
+		if err := r.open(); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	block := r.file.Blocks[i]
+	data := make([]byte, block.Size)
+	if _, err := r.fd.ReadAt(data, r.offsets[i]); err != nil {
+		return nil, 0, err
+	}
+	if hash := sha256.Sum256(data); !bytes.Equal(hash[:], block.Hash) {
+		return nil, 0, errFileChanged
+	}
+
+	r.cur = r.key.Encrypt(data, block.Hash)
+	r.curOffset = r.starts[i]
+	return r.cur, r.curOffset, nil
+}
+
+func (r *encryptedReader) open() error {
+	if r.file.IsSymlink() {
+		target, _, err := symlinks.Read(r.path)
+		if err != nil {
+			return err
+		}
+		r.fd = strings.NewReader(target)
+		return nil
+	}
+
+	fd, err := os.Open(r.path)
+	if err != nil {
+		return err
+	}
+	r.fd = fd
+	r.closer = fd
+	return nil
+}
+
+func (r *encryptedReader) close() {
+	if r.closer != nil {
+		r.closer.Close()
+	}
+}
+
+// requestEncrypted serves a request for an encrypted file from an untrusted
+// device.
+func (m *Model) requestEncrypted(key *encryption.Key, folder, encName string, offset int64, size int) ([]byte, error) {
+	if key == nil {
+		return nil, protocol.ErrNoSuchFile
+	}
+
+	m.fmut.RLock()
+	cfg := m.folderCfgs[folder]
+	m.fmut.RUnlock()
+
+	e := newEncrypter(m.db, cfg, key)
+	plain, ok := e.repo.Plain(encName)
+	if !ok {
+		return nil, protocol.ErrNoSuchFile
+	}
+	lf, ok := m.CurrentFolderFile(folder, plain.Name)
+	if !ok || !lf.Version.Equal(plain.Version) {
+		return nil, protocol.ErrNoSuchFile
+	}
+	if lf.IsInvalid() || lf.IsDeleted() {
+		return nil, protocol.ErrInvalid
+	}
+
+	r := e.reader(lf)
+	defer r.close()
+
+	buf := make([]byte, size)
+	if _, err := r.ReadAt(buf, offset); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// requestDecrypted pulls a block of the given file from an untrusted device
+// and decrypts it.
+func (m *Model) requestDecrypted(nc protocol.Connection, key *encryption.Key, folder, name string, offset int64, size int) ([]byte, error) {
+	if key == nil {
+		return nil, errNoEncryption
+	}
+
+	// All blocks but the last are of the same size, so the block number
+	// follows from the offset.
+	blockSize := int64(protocol.BlockSize)
+	if gf, ok := m.CurrentGlobalFile(folder, name); ok {
+		blockSize = int64(scanner.BlockSizeOf(gf.Blocks))
+	}
+	block := offset / blockSize
+	encOffset := block * (blockSize + encryption.BlockOverhead)
+	data, err := nc.Request(folder, key.EncryptName(name), encOffset, size+encryption.BlockOverhead, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	return key.Decrypt(data)
+}
+
+type decryptJob struct {
+	folder  string
+	files   []protocol.FileInfo
+	replace bool // the files are the device's full index
+}
+
+// A decryptQueue holds the indexes received from an untrusted device until
+// they have been decrypted. Decrypting requires fetching data from the
+// device, which can't be done while receiving from it, so it's done in the
+// background in the order the indexes were received.
+type decryptQueue struct {
+	jobs  []decryptJob
+	mut   sync.Mutex
+	avail chan struct{}
+	stop  chan struct{}
+}
+
+// decryptIndex queues the index received from an untrusted device for
+// decryption.
+func (m *Model) decryptIndex(device protocol.DeviceID, folder string, files []protocol.FileInfo, replace bool) {
+	m.encMut.Lock()
+	q, ok := m.decryptQueues[device]
+	if !ok {
+		q = &decryptQueue{
+			mut:   sync.NewMutex(),
+			avail: make(chan struct{}, 1),
+			stop:  make(chan struct{}),
+		}
+		m.decryptQueues[device] = q
+		go m.decryptIndexes(device, q)
+	}
+	m.encMut.Unlock()
+
+	q.mut.Lock()
+	q.jobs = append(q.jobs, decryptJob{folder, files, replace})
+	q.mut.Unlock()
+
+	select {
+	case q.avail <- struct{}{}:
+	default:
+	}
+}
+
+// stopDecrypting drops the queued indexes of a device that is gone.
+func (m *Model) stopDecrypting(device protocol.DeviceID) {
+	m.encMut.Lock()
+	if q, ok := m.decryptQueues[device]; ok {
+		close(q.stop)
+		delete(m.decryptQueues, device)
+	}
+	m.encMut.Unlock()
+}
+
+func (m *Model) decryptIndexes(device protocol.DeviceID, q *decryptQueue) {
+	for {
+		select {
+		case <-q.stop:
+			return
+		case <-q.avail:
+		}
+
+		q.mut.Lock()
+		jobs := q.jobs
+		q.jobs = nil
+		q.mut.Unlock()
+
+		for _, job := range jobs {
+			select {
+			case <-q.stop:
+				return
+			default:
+			}
+			m.decryptJob(device, job)
+		}
+	}
+}
+
+func (m *Model) decryptJob(device protocol.DeviceID, job decryptJob) {
+	key, ok := m.encryptedDevice(job.folder, device)
+	if !ok || key == nil {
+		return
+	}
+
+	m.pmut.RLock()
+	nc, ok := m.protoConn[device]
+	m.pmut.RUnlock()
+	m.fmut.RLock()
+	files := m.folderFiles[job.folder]
+	runner := m.folderRunners[job.folder]
+	m.fmut.RUnlock()
+	if !ok || files == nil {
+		return
+	}
+
+	repo := db.NewEncryptedRepo(m.db, job.folder)
+	plain := make([]protocol.FileInfo, 0, len(job.files))
+	for _, f := range job.files {
+		pf, err := decryptFile(nc, key, repo, job.folder, f)
+		if err != nil {
+			l.Infof("Decrypting %q / %s from %s: %v", job.folder, f.Name, device, err)
+			continue
+		}
+		if pf.Name != "" {
+			plain = append(plain, pf)
+		}
+	}
+
+	if debug {
+		l.Debugf("%v decrypted index from %s / %q: %d of %d files", m, device, job.folder, len(plain), len(job.files))
+	}
+
+	plain = filterRemoteFiles(job.folder, plain)
+	if job.replace {
+		files.Replace(device, plain)
+		m.clearTempIndex(device, job.folder, nil)
+	} else {
+		files.Update(device, plain)
+		m.clearTempIndex(device, job.folder, plain)
+	}
+
+	events.Default.Log(events.RemoteIndexUpdated, map[string]interface{}{
+		"device":  device.String(),
+		"folder":  job.folder,
+		"items":   len(plain),
+		"version": files.LocalVersion(device),
+	})
+
+	if runner != nil {
+		runner.IndexUpdated()
+	}
+}
+
+var errForgedDeletion = errors.New("deletion not authenticated")
+
+// tombstone returns the authenticator of the deletion of the file, which
+// only the devices that have the key can create.
+func tombstone(key *encryption.Key, f protocol.FileInfo) []byte {
+	version := make([]byte, 16*len(f.Version))
+	for i, c := range f.Version {
+		binary.BigEndian.PutUint64(version[16*i:], c.ID)
+		binary.BigEndian.PutUint64(version[16*i+8:], c.Value)
+	}
+	return key.Tombstone(f.Name, version)
+}
+
+// decryptFile returns the plaintext form of a file announced by an untrusted
+// device. A file without a name is returned for files that are of no
+// interest, such as invalid ones and deletions of files we've never known.
+func decryptFile(nc protocol.Connection, key *encryption.Key, repo *db.EncryptedRepo, folder string, f protocol.FileInfo) (protocol.FileInfo, error) {
+	if f.IsInvalid() {
+		return protocol.FileInfo{}, nil
+	}
+
+	pf, known := repo.Plain(f.Name)
+	if known && pf.Version.Equal(f.Version) && pf.IsDeleted() == f.IsDeleted() {
+		return pf, nil
+	}
+
+	if f.IsDeleted() {
+		if !known {
+			return protocol.FileInfo{}, nil
+		}
+		pf.Flags |= protocol.FlagDeleted
+		pf.Blocks = nil
+		pf.Version = f.Version
+		if len(f.Blocks) != 1 || !hmac.Equal(f.Blocks[0].Hash, tombstone(key, pf)) {
+			return protocol.FileInfo{}, errForgedDeletion
+		}
+		repo.Put(pf, f)
+		return pf, nil
+	}
+
+	// Fetch and decrypt the trailer.
+	size := f.Size()
+	length, err := nc.Request(folder, f.Name, size-encryption.TrailerLengthSize, encryption.TrailerLengthSize, nil, 0, nil)
+	if err != nil {
+		return protocol.FileInfo{}, err
+	}
+	n, err := encryption.TrailerLength(length, size)
+	if err != nil {
+		return protocol.FileInfo{}, err
+	}
+	trailer, err := nc.Request(folder, f.Name, size-encryption.TrailerLengthSize-int64(n), n, nil, 0, nil)
+	if err != nil {
+		return protocol.FileInfo{}, err
+	}
+	meta, err := key.Decrypt(trailer)
+	if err != nil {
+		return protocol.FileInfo{}, err
+	}
+
+	pf = protocol.FileInfo{}
+	if err := pf.UnmarshalXDR(meta); err != nil {
+		return protocol.FileInfo{}, err
+	}
+
+	// The device could announce a trailer under another name, or with
+	// another version, than it belongs to.
+	if key.EncryptName(pf.Name) != f.Name || !pf.Version.Equal(f.Version) {
+		return protocol.FileInfo{}, encryption.ErrDecrypt
+	}
+
+	repo.Put(pf, f)
+	return pf, nil
+}
@@ -0,0 +1,147 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/db"
+	"github.com/syncthing/syncthing/internal/encryption"
+	"github.com/syncthing/syncthing/internal/scanner"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// encryptedConn is an untrusted device that has stored the encrypted files
+// of a model and serves them from it.
+type encryptedConn struct {
+	FakeConnection
+	m *Model
+}
+
+func (c encryptedConn) Request(folder, name string, offset int64, size int, hash []byte, flags uint32, options []protocol.Option) ([]byte, error) {
+	return c.m.Request(c.id, folder, name, offset, size, hash, flags, options)
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	m, dir := setupFolderModel(t, config.FolderConfiguration{
+		ID:                 "e",
+		EncryptionPassword: "secret",
+		Devices: []config.FolderDeviceConfiguration{
+			{DeviceID: device1, Encrypted: true},
+		},
+	})
+	defer os.RemoveAll(dir)
+	ldb := m.db
+	fcfg := m.cfg.Folders()["e"]
+
+	data := bytes.Repeat([]byte("encrypted data "), 20000)
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ScanFolder("e"); err != nil {
+		t.Fatal(err)
+	}
+
+	lf, ok := m.CurrentFolderFile("e", "file")
+	if !ok {
+		t.Fatal("file not scanned")
+	}
+
+	key := encryption.NewKey("e", "secret")
+	enc := newEncrypter(ldb, fcfg, key).encryptedFile(lf, false)
+	if enc.Name == lf.Name || enc.Name != key.EncryptName(lf.Name) {
+		t.Errorf("unexpected encrypted name %q", enc.Name)
+	}
+	if enc.IsInvalid() || enc.Size() <= lf.Size()+int64(len(lf.Blocks)*encryption.BlockOverhead) {
+		t.Fatalf("unexpected encrypted file %v", enc)
+	}
+
+	// A cached form hashed in large blocks is only announced to devices
+	// that handle variable block sizes; the others get it rehashed.
+	large := enc
+	large.Blocks = []protocol.BlockInfo{{Size: 2 * protocol.BlockSize}, {Size: 1}}
+	encr := newEncrypter(ldb, fcfg, key)
+	encr.repo.Put(lf, large)
+	if f := encr.encryptedFile(lf, true); scanner.BlockSizeOf(f.Blocks) != 2*protocol.BlockSize {
+		t.Errorf("cached encrypted file not used, blocks %v", f.Blocks)
+	}
+	if f := encr.encryptedFile(lf, false); scanner.BlockSizeOf(f.Blocks) != protocol.BlockSize || f.Size() != enc.Size() {
+		t.Errorf("encrypted file not rehashed for an older device, blocks %v", f.Blocks)
+	}
+
+	// Plaintext names are not served to the encrypted device.
+	if _, err := m.Request(device1, "e", "file", 0, 10, nil, 0, nil); err != protocol.ErrNoSuchFile {
+		t.Errorf("unexpected error %v requesting plaintext name", err)
+	}
+
+	// Another trusted device decrypts the index entry and the data as
+	// served by the encrypted device.
+	nc := encryptedConn{FakeConnection{id: device1}, m}
+	ldb2, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	repo := db.NewEncryptedRepo(ldb2, "e")
+
+	pf, err := decryptFile(nc, key, repo, "e", enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pf.Name != lf.Name || !pf.Version.Equal(lf.Version) || pf.Size() != lf.Size() {
+		t.Errorf("decrypted file %v does not match %v", pf, lf)
+	}
+	if cached, ok := repo.Plain(enc.Name); !ok || cached.Name != lf.Name {
+		t.Error("decrypted file not cached")
+	}
+
+	var offset int64
+	for _, b := range lf.Blocks {
+		bs, err := m.requestDecrypted(nc, key, "e", "file", offset, int(b.Size))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(bs, data[offset:offset+int64(b.Size)]) {
+			t.Errorf("block at %d does not match", offset)
+		}
+		offset += int64(b.Size)
+	}
+
+	// Deletions are passed on, but can't be made up by the encrypted device.
+	deleted := lf
+	deleted.Flags |= protocol.FlagDeleted
+	deleted.Blocks = nil
+	deleted.Version = lf.Version.Update(device2.Short())
+	encDeleted := newEncrypter(ldb, fcfg, key).encryptedFile(deleted, false)
+	forged := encDeleted
+	forged.Version = encDeleted.Version.Update(device1.Short())
+	if _, err := decryptFile(nc, key, repo, "e", forged); err != errForgedDeletion {
+		t.Errorf("unexpected error %v for forged deletion", err)
+	}
+	forged.Version = enc.Version.Update(device1.Short())
+	forged.Blocks = nil
+	if _, err := decryptFile(nc, key, repo, "e", forged); err != errForgedDeletion {
+		t.Errorf("unexpected error %v for deletion without tombstone", err)
+	}
+	pf, err = decryptFile(nc, key, repo, "e", encDeleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pf.Name != lf.Name || !pf.IsDeleted() || !pf.Version.Equal(deleted.Version) {
+		t.Errorf("unexpected decrypted deletion %v", pf)
+	}
+
+	// Files under names that have never been encrypted are refused.
+	other := enc
+	other.Name = key.EncryptName("other")
+	if _, err := decryptFile(nc, key, db.NewEncryptedRepo(ldb2, "e2"), "e", other); err == nil {
+		t.Error("unexpected nil error for unknown name")
+	}
+}
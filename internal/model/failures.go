@@ -0,0 +1,172 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/db"
+	"github.com/syncthing/syncthing/internal/events"
+)
+
+// Items that fail to sync are recorded in the database. A failed item is
+// not retried until its backoff has passed, which doubles with every
+// failure, unless a new version of it shows up. The record is forgotten once
+// the item is no longer needed.
+
+const (
+	minFailureBackoff = time.Minute
+	maxFailureBackoff = 24 * time.Hour
+)
+
+// The actions that can be taken on a failed item.
+const (
+	FailureRetry  = "retry"  // retry on the next pull
+	FailureSkip   = "skip"   // don't retry until there is a new version
+	FailureIgnore = "ignore" // add the item to the ignore patterns
+)
+
+var errNoSuchFailure = errors.New("no such failed item")
+
+// failureBackoff returns how long to wait before retrying an item that has
+// failed the given number of times.
+func failureBackoff(count int) time.Duration {
+	backoff := minFailureBackoff
+	for i := 1; i < count && backoff < maxFailureBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxFailureBackoff {
+		backoff = maxFailureBackoff
+	}
+	return backoff
+}
+
+// recordFailure persists the failure to sync the given item.
+func (p *rwFolder) recordFailure(name, msg string) {
+	repo := db.NewFailureRepo(p.model.db, p.folder)
+	now := time.Now()
+
+	gf, _ := p.model.CurrentGlobalFile(p.folder, name)
+	f, ok := repo.Get(name)
+	if !ok || !f.Version.Equal(gf.Version) {
+		f = db.FailedItem{
+			Name:      name,
+			FirstSeen: now,
+			Version:   gf.Version,
+		}
+	}
+	f.Count++
+	f.Error = msg
+	f.LastSeen = now
+	f.RetryAt = now.Add(failureBackoff(f.Count))
+	repo.Put(f)
+
+	events.Default.Log(events.ItemFailed, map[string]interface{}{
+		"folder":  p.folder,
+		"item":    name,
+		"error":   f.Error,
+		"count":   f.Count,
+		"retryAt": f.RetryAt,
+	})
+}
+
+// backingOff returns true if the needed file is a failed item that should
+// not be retried yet. The earliest upcoming retry is remembered in
+// p.nextRetry.
+func (p *rwFolder) backingOff(f db.FailedItem, file protocol.FileInfo, now time.Time) bool {
+	if !f.Version.Equal(file.Version) {
+		// A new version might well work.
+		return false
+	}
+	if f.Skipped {
+		return true
+	}
+	if now.Before(f.RetryAt) {
+		if p.nextRetry.IsZero() || f.RetryAt.Before(p.nextRetry) {
+			p.nextRetry = f.RetryAt
+		}
+		return true
+	}
+	return false
+}
+
+// retryDue returns true if a failed item should be retried by now.
+func (p *rwFolder) retryDue() bool {
+	return !p.nextRetry.IsZero() && !time.Now().Before(p.nextRetry)
+}
+
+// failures returns the failed items in the folder by name.
+func (p *rwFolder) failures() map[string]db.FailedItem {
+	failures := make(map[string]db.FailedItem)
+	for _, f := range db.NewFailureRepo(p.model.db, p.folder).List() {
+		failures[f.Name] = f
+	}
+	return failures
+}
+
+// forgetFailures removes the records of the failed items that are no longer
+// needed, as they have been synced or have otherwise gone away.
+func (p *rwFolder) forgetFailures(failures map[string]db.FailedItem, needed map[string]bool) {
+	repo := db.NewFailureRepo(p.model.db, p.folder)
+	for name := range failures {
+		if !needed[name] {
+			repo.Delete(name)
+		}
+	}
+}
+
+// FailedItems returns the items that have failed to sync in the given
+// folder.
+func (m *Model) FailedItems(folder string) []db.FailedItem {
+	return db.NewFailureRepo(m.db, folder).List()
+}
+
+// ActOnFailedItem retries, skips or ignores the given failed item.
+func (m *Model) ActOnFailedItem(folder, name, action string) error {
+	m.fmut.RLock()
+	runner, ok := m.folderRunners[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		return errors.New("no such folder")
+	}
+
+	repo := db.NewFailureRepo(m.db, folder)
+	f, ok := repo.Get(name)
+	if !ok {
+		return errNoSuchFailure
+	}
+
+	switch action {
+	case FailureRetry:
+		repo.Delete(name)
+		runner.IndexUpdated()
+
+	case FailureSkip:
+		f.Skipped = true
+		repo.Put(f)
+
+	case FailureIgnore:
+		lines, _, err := m.GetIgnores(folder)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, "/"+filepath.ToSlash(name))
+		if err := m.SetIgnores(folder, lines); err != nil {
+			return err
+		}
+		repo.Delete(name)
+
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/sync"
+)
+
+func TestFailureBackoff(t *testing.T) {
+	cases := []struct {
+		count    int
+		expected time.Duration
+	}{
+		{0, time.Minute},
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{5, 16 * time.Minute},
+		{12, 24 * time.Hour},
+		{1000, 24 * time.Hour},
+	}
+
+	for _, tc := range cases {
+		if res := failureBackoff(tc.count); res != tc.expected {
+			t.Errorf("failureBackoff(%d) = %v, expected %v", tc.count, res, tc.expected)
+		}
+	}
+}
+
+func TestFailedItems(t *testing.T) {
+	m, dir := setupFolderModel(t, config.FolderConfiguration{ID: "f"})
+	defer os.RemoveAll(dir)
+
+	file := protocol.FileInfo{
+		Name:    "foo",
+		Flags:   0644,
+		Version: protocol.Vector{{ID: device1.Short(), Value: 1}},
+	}
+	m.Index(device1, "f", []protocol.FileInfo{file}, 0, nil)
+
+	p := rwFolder{
+		model:     m,
+		folder:    "f",
+		dir:       dir,
+		errors:    make(map[string]string),
+		errorsMut: sync.NewMutex(),
+	}
+
+	// Failing twice in the same pull counts once.
+	p.newError("foo", errors.New("permission denied"))
+	p.newError("foo", errors.New("something else"))
+	p.recordFailures()
+	p.clearErrors()
+	p.newError("foo", errors.New("permission denied"))
+	p.recordFailures()
+
+	failures := m.FailedItems("f")
+	if len(failures) != 1 || failures[0].Name != "foo" || failures[0].Count != 2 || failures[0].Error != "permission denied" {
+		t.Fatalf("Incorrect failed items %+v", failures)
+	}
+	f := failures[0]
+	if retry := f.RetryAt.Sub(f.LastSeen); retry != 2*time.Minute {
+		t.Errorf("Incorrect backoff %v", retry)
+	}
+
+	now := time.Now()
+	if !p.backingOff(f, file, now) {
+		t.Error("Failed item should be backing off")
+	}
+	if !p.nextRetry.Equal(f.RetryAt) {
+		t.Errorf("Next retry %v, expected %v", p.nextRetry, f.RetryAt)
+	}
+	if p.backingOff(f, file, f.RetryAt) {
+		t.Error("Failed item should be retried after backing off")
+	}
+	newer := file
+	newer.Version = protocol.Vector{{ID: device1.Short(), Value: 2}}
+	if p.backingOff(f, newer, now) {
+		t.Error("A new version should be retried immediately")
+	}
+
+	if err := m.ActOnFailedItem("f", "foo", FailureSkip); err != nil {
+		t.Fatal(err)
+	}
+	if f := m.FailedItems("f")[0]; !f.Skipped || !p.backingOff(f, file, f.RetryAt) {
+		t.Errorf("Skipped item should not be retried %+v", f)
+	}
+
+	if err := m.ActOnFailedItem("f", "foo", FailureIgnore); err != nil {
+		t.Fatal(err)
+	}
+	if bs, _ := ioutil.ReadFile(filepath.Join(dir, ".stignore")); !strings.Contains(string(bs), "/foo\n") {
+		t.Errorf("Ignored item not in .stignore: %q", bs)
+	}
+	if failures := m.FailedItems("f"); len(failures) != 0 {
+		t.Errorf("Unexpected failed items after ignoring %+v", failures)
+	}
+	if err := m.ActOnFailedItem("f", "foo", FailureRetry); err != errNoSuchFailure {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	// Records are forgotten once the item isn't needed any more.
+	p.newError("bar", errors.New("invalid name"))
+	p.forgetFailures(p.failures(), map[string]bool{})
+	if failures := m.FailedItems("f"); len(failures) != 0 {
+		t.Errorf("Unexpected failed items %+v", failures)
+	}
+}
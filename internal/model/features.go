@@ -0,0 +1,108 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/syncthing/protocol"
+)
+
+// Optional protocol features are announced in the cluster config message as
+// a comma separated list under the "features" option. Older devices don't
+// send the option and are assumed to support none of them.
+const (
+	// The device answers requests carrying the "weakhashes" option with
+	// the rolling weak hash of each block of the file.
+	featureWeakHashes = "weakhashes"
+
+	// The device sends index updates flagged FlagIndexTemporary listing the
+	// blocks available in the temporary files of files it is pulling, and
+	// answers requests flagged FlagRequestTemporary from those files.
+	featureTempIndexes = "tempindexes"
+
+	// The device handles files with blocks larger than protocol.BlockSize.
+	// Older devices assume that size for all blocks and would crash pulling
+	// such a file, so folders shared with any device that doesn't announce
+	// this are scanned with fixed size blocks, and files with larger blocks
+	// aren't announced to it.
+	featureVariableBlocks = "variableblocks"
+)
+
+var localFeatures = []string{
+	featureWeakHashes,
+	featureTempIndexes,
+	featureVariableBlocks,
+}
+
+func parseFeatures(cm protocol.ClusterConfigMessage) map[string]bool {
+	features := make(map[string]bool)
+	for _, f := range strings.Split(cm.GetOption("features"), ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			features[f] = true
+		}
+	}
+	return features
+}
+
+func featureList(features map[string]bool) []string {
+	list := make([]string, 0, len(features))
+	for f := range features {
+		list = append(list, f)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// deviceHasFeature returns true if the given device has announced support
+// for the feature.
+func (m *Model) deviceHasFeature(deviceID protocol.DeviceID, feature string) bool {
+	m.pmut.RLock()
+	defer m.pmut.RUnlock()
+	return m.deviceFeatures[deviceID][feature]
+}
+
+// deviceHasVariableBlocks returns true if the device announced
+// featureVariableBlocks when it last connected. The features are remembered
+// across restarts, as we need to know them before the device connects.
+func (m *Model) deviceHasVariableBlocks(deviceID protocol.DeviceID) bool {
+	features, _ := m.deviceStatRef(deviceID).GetFeatures()
+	for _, f := range features {
+		if f == featureVariableBlocks {
+			return true
+		}
+	}
+	return false
+}
+
+// variableBlocks returns true if the folder may be scanned with block sizes
+// depending on the file size; that is, if all the devices it's shared with
+// are known to handle them.
+func (m *Model) variableBlocks(folder string) bool {
+	m.fmut.RLock()
+	devices := m.folderDevices[folder]
+	m.fmut.RUnlock()
+
+	for _, device := range devices {
+		if device != m.id && !m.deviceHasVariableBlocks(device) {
+			return false
+		}
+	}
+	return true
+}
+
+// getOption returns the value of the option with the given key, or the empty
+// string.
+func getOption(options []protocol.Option, key string) string {
+	for _, option := range options {
+		if option.Key == key {
+			return option.Value
+		}
+	}
+	return ""
+}
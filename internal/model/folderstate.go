@@ -20,6 +20,9 @@ const (
 	FolderScanning
 	FolderSyncing
 	FolderError
+	FolderOutOfDiskSpace
+	FolderPaused
+	FolderDeletionsPending
 )
 
 func (s folderState) String() string {
@@ -32,6 +35,12 @@ func (s folderState) String() string {
 		return "syncing"
 	case FolderError:
 		return "error"
+	case FolderOutOfDiskSpace:
+		return "outOfDiskSpace"
+	case FolderPaused:
+		return "paused"
+	case FolderDeletionsPending:
+		return "deletionsPending"
 	default:
 		return "unknown"
 	}
@@ -0,0 +1,203 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/db"
+	"github.com/syncthing/syncthing/internal/osutil"
+	"github.com/syncthing/syncthing/internal/scanner"
+)
+
+var (
+	errNotAFile         = errors.New("not a file")
+	errBlockUnavailable = errors.New("block not available locally or from any connected device")
+)
+
+// GlobalDirectory returns the entries directly within the given directory in
+// the global state of the folder, sorted by name. Deleted and invalid entries
+// are left out. The boolean is false if there is no such directory.
+func (m *Model) GlobalDirectory(folder, dir string) ([]db.FileInfoTruncated, bool) {
+	m.fmut.RLock()
+	files, ok := m.folderFiles[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		return nil, false
+	}
+
+	sep := string(filepath.Separator)
+	dir = strings.Trim(osutil.NativeFilename(dir), sep)
+	prefix := ""
+	if dir != "" {
+		gf, ok := files.GetGlobal(dir)
+		if !ok || gf.IsDeleted() || gf.IsInvalid() || !gf.IsDirectory() || gf.IsSymlink() {
+			return nil, false
+		}
+		prefix = dir + sep
+	}
+
+	var entries []db.FileInfoTruncated
+	files.WithPrefixedGlobalTruncated(prefix, func(fi db.FileIntf) bool {
+		f := fi.(db.FileInfoTruncated)
+		if f.IsInvalid() || f.IsDeleted() || f.Name == dir {
+			return true
+		}
+		if strings.Contains(f.Name[len(prefix):], sep) {
+			// Further down the tree
+			return true
+		}
+		entries = append(entries, f)
+		return true
+	})
+
+	sort.Sort(truncatedByName(entries))
+	return entries, true
+}
+
+type truncatedByName []db.FileInfoTruncated
+
+func (l truncatedByName) Len() int           { return len(l) }
+func (l truncatedByName) Less(a, b int) bool { return l[a].Name < l[b].Name }
+func (l truncatedByName) Swap(a, b int)      { l[a], l[b] = l[b], l[a] }
+
+// A GlobalFileReader reads the global version of a file. Blocks are read from
+// local copies where there are any, in any folder, and are otherwise
+// requested from the connected devices that have the file.
+type GlobalFileReader struct {
+	model   *Model
+	folder  string
+	file    protocol.FileInfo
+	offsets []int64
+	pos     int64
+
+	cur      []byte // the last block read
+	curBlock int
+}
+
+// NewGlobalFileReader returns a reader for the global version of the given
+// file.
+func (m *Model) NewGlobalFileReader(folder, name string) (*GlobalFileReader, error) {
+	f, ok := m.CurrentGlobalFile(folder, osutil.NativeFilename(name))
+	if !ok || f.IsDeleted() || f.IsInvalid() {
+		return nil, protocol.ErrNoSuchFile
+	}
+	if f.IsDirectory() || f.IsSymlink() {
+		return nil, errNotAFile
+	}
+
+	// Block offsets aren't kept in the database.
+	offsets := make([]int64, len(f.Blocks))
+	for i := 1; i < len(f.Blocks); i++ {
+		offsets[i] = offsets[i-1] + int64(f.Blocks[i-1].Size)
+	}
+	for i := range f.Blocks {
+		f.Blocks[i].Offset = offsets[i]
+	}
+
+	return &GlobalFileReader{
+		model:    m,
+		folder:   folder,
+		file:     f,
+		offsets:  offsets,
+		curBlock: -1,
+	}, nil
+}
+
+// File returns the file being read.
+func (r *GlobalFileReader) File() protocol.FileInfo {
+	return r.file
+}
+
+func (r *GlobalFileReader) Read(p []byte) (int, error) {
+	if r.pos >= r.file.Size() {
+		return 0, io.EOF
+	}
+
+	i := sort.Search(len(r.offsets), func(i int) bool {
+		return r.offsets[i] > r.pos
+	}) - 1
+	if i != r.curBlock {
+		data, err := r.model.globalBlock(r.folder, r.file, r.file.Blocks[i])
+		if err != nil {
+			return 0, err
+		}
+		r.cur = data
+		r.curBlock = i
+	}
+
+	n := copy(p, r.cur[r.pos-r.offsets[i]:])
+	r.pos += int64(n)
+	return n, nil
+}
+
+func (r *GlobalFileReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case os.SEEK_SET:
+	case os.SEEK_CUR:
+		offset += r.pos
+	case os.SEEK_END:
+		offset += r.file.Size()
+	default:
+		return r.pos, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return r.pos, errors.New("negative position")
+	}
+	r.pos = offset
+	return r.pos, nil
+}
+
+// globalBlock returns the data of the given block of the file, from disk if
+// we have it anywhere and otherwise from the devices that have the file.
+func (m *Model) globalBlock(folder string, file protocol.FileInfo, block protocol.BlockInfo) ([]byte, error) {
+	folderRoots := make(map[string]string)
+	m.fmut.RLock()
+	for id, cfg := range m.folderCfgs {
+		folderRoots[id] = cfg.Path()
+	}
+	m.fmut.RUnlock()
+
+	buf := make([]byte, block.Size)
+	found := m.finder.Iterate(block.Hash, func(folder, name string, index int32, blockSize int) bool {
+		fd, err := os.Open(filepath.Join(folderRoots[folder], name))
+		if err != nil {
+			return false
+		}
+		_, err = fd.ReadAt(buf, int64(blockSize)*int64(index))
+		fd.Close()
+		if err != nil {
+			return false
+		}
+		_, err = scanner.VerifyBuffer(buf, block)
+		return err == nil
+	})
+	if found {
+		return buf, nil
+	}
+
+	var lastErr error = errBlockUnavailable
+	for _, device := range m.Availability(folder, file.Name) {
+		data, err := m.requestGlobal(device, folder, file.Name, block.Offset, int(block.Size), block.Hash, 0, nil)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if _, err := scanner.VerifyBuffer(data, block); err != nil {
+			lastErr = err
+			continue
+		}
+		return data, nil
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,114 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/scanner"
+)
+
+func TestGlobalFileReader(t *testing.T) {
+	m, dir := setupFolderModel(t, config.FolderConfiguration{ID: "g"})
+	defer os.RemoveAll(dir)
+
+	local := bytes.Repeat([]byte("local data "), 30000)
+	if err := ioutil.WriteFile(filepath.Join(dir, "local"), local, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ScanFolder("g"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The other device has a file in a directory that we don't have.
+	remote := []byte("remote data")
+	blocks, err := scanner.Blocks(bytes.NewReader(remote), protocol.BlockSize, int64(len(remote)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	version := protocol.Vector{{ID: device1.Short(), Value: 1}}
+	m.Index(device1, "g", []protocol.FileInfo{
+		{Name: "dir", Flags: protocol.FlagDirectory | 0755, Version: version},
+		{Name: filepath.Join("dir", "remote"), Flags: 0644, Version: version, Blocks: blocks},
+	}, 0, nil)
+
+	fc := FakeConnection{
+		id:          device1,
+		requestData: remote,
+	}
+	m.AddConnection(fc, fc)
+
+	entries, ok := m.GlobalDirectory("g", "")
+	if !ok {
+		t.Fatal("root directory not found")
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	if !equalStrings(names, []string{"dir", "local"}) {
+		t.Errorf("unexpected root entries %v", names)
+	}
+	if entries, ok := m.GlobalDirectory("g", "dir/"); !ok || len(entries) != 1 || entries[0].Name != filepath.Join("dir", "remote") {
+		t.Errorf("unexpected dir entries %v", entries)
+	}
+	if _, ok := m.GlobalDirectory("g", "local"); ok {
+		t.Error("unexpected listing of a file")
+	}
+
+	// Local data is read from disk, across blocks.
+	r, err := m.NewGlobalFileReader("g", "local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Seek(protocol.BlockSize-5, os.SEEK_SET); err != nil {
+		t.Fatal(err)
+	}
+	bs, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bs, local[protocol.BlockSize-5:]) {
+		t.Error("local data mismatch")
+	}
+
+	// Remote data is requested from the device.
+	r, err = m.NewGlobalFileReader("g", "dir/remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs, err = ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bs, remote) {
+		t.Errorf("remote data mismatch, %q != %q", bs, remote)
+	}
+
+	// Data that doesn't match the index is refused.
+	fc.requestData = []byte("other data!")
+	m.Close(device1, protocol.ErrClosed)
+	m.AddConnection(fc, fc)
+	m.Index(device1, "g", []protocol.FileInfo{
+		{Name: "dir", Flags: protocol.FlagDirectory | 0755, Version: version},
+		{Name: filepath.Join("dir", "remote"), Flags: 0644, Version: version, Blocks: blocks},
+	}, 0, nil)
+	r, _ = m.NewGlobalFileReader("g", "dir/remote")
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Error("unexpected nil error reading mismatching data")
+	}
+
+	if _, err := m.NewGlobalFileReader("g", "dir"); err == nil {
+		t.Error("unexpected nil error reading a directory")
+	}
+}
@@ -25,10 +25,12 @@ import (
 	"github.com/syncthing/protocol"
 	"github.com/syncthing/syncthing/internal/config"
 	"github.com/syncthing/syncthing/internal/db"
+	"github.com/syncthing/syncthing/internal/encryption"
 	"github.com/syncthing/syncthing/internal/events"
 	"github.com/syncthing/syncthing/internal/ignore"
 	"github.com/syncthing/syncthing/internal/osutil"
 	"github.com/syncthing/syncthing/internal/scanner"
+	"github.com/syncthing/syncthing/internal/schedule"
 	"github.com/syncthing/syncthing/internal/stats"
 	"github.com/syncthing/syncthing/internal/symlinks"
 	"github.com/syncthing/syncthing/internal/sync"
@@ -69,6 +71,7 @@ type Model struct {
 	db              *leveldb.DB
 	finder          *db.BlockFinder
 	progressEmitter *ProgressEmitter
+	scheduler       *schedule.Scheduler
 	id              protocol.DeviceID
 	shortID         uint64
 
@@ -84,17 +87,37 @@ type Model struct {
 	folderIgnores  map[string]*ignore.Matcher                             // folder -> matcher object
 	folderRunners  map[string]service                                     // folder -> puller or scanner
 	folderStatRefs map[string]*stats.FolderStatisticsReference            // folder -> statsRef
+	folderKeys     map[string]*encryption.Key                             // folder -> key for encrypted devices
 	fmut           sync.RWMutex                                           // protects the above
 
-	protoConn map[protocol.DeviceID]protocol.Connection
-	rawConn   map[protocol.DeviceID]io.Closer
-	deviceVer map[protocol.DeviceID]string
-	pmut      sync.RWMutex // protects protoConn and rawConn
+	protoConn      map[protocol.DeviceID]protocol.Connection
+	rawConn        map[protocol.DeviceID]io.Closer
+	deviceVer      map[protocol.DeviceID]string
+	deviceFeatures map[protocol.DeviceID]map[string]bool                // deviceID -> announced optional features
+	tempIndexes    map[protocol.DeviceID]map[string]map[string]tempFile // deviceID -> folder -> file -> blocks in temp file
+	pmut           sync.RWMutex                                         // protects protoConn and rawConn
 
 	started bool
 
 	reqValidationCache map[string]time.Time // folder / file name => time when confirmed to exist
 	rvmut              sync.RWMutex         // protects reqValidationCache
+
+	rates    map[string]*transferRate // device ID or "total" => current rates
+	ratesMut sync.Mutex
+
+	deletions map[string]*pendingDeletions // folder => deletions held back for approval
+	delMut    sync.Mutex
+
+	decryptQueues map[protocol.DeviceID]*decryptQueue // encrypted device => indexes waiting to be decrypted
+	encMut        sync.Mutex
+
+	scans         map[string]*scanProgress // folder => progress of the scan in progress
+	verifying     map[string]bool          // folder => being verified
+	deferredScans map[string]*deferredScan // folder => files left for a later scan
+	scanMut       sync.Mutex
+
+	weakCache map[string]weakHashEntry // folder/name => weak hashes of the local version
+	weakMut   sync.Mutex
 }
 
 var (
@@ -130,18 +153,39 @@ func NewModel(cfg *config.Wrapper, id protocol.DeviceID, deviceName, clientName,
 		folderIgnores:      make(map[string]*ignore.Matcher),
 		folderRunners:      make(map[string]service),
 		folderStatRefs:     make(map[string]*stats.FolderStatisticsReference),
+		folderKeys:         make(map[string]*encryption.Key),
 		protoConn:          make(map[protocol.DeviceID]protocol.Connection),
 		rawConn:            make(map[protocol.DeviceID]io.Closer),
 		deviceVer:          make(map[protocol.DeviceID]string),
+		deviceFeatures:     make(map[protocol.DeviceID]map[string]bool),
+		tempIndexes:        make(map[protocol.DeviceID]map[string]map[string]tempFile),
 		reqValidationCache: make(map[string]time.Time),
-
-		fmut:  sync.NewRWMutex(),
-		pmut:  sync.NewRWMutex(),
-		rvmut: sync.NewRWMutex(),
-	}
+		rates:              make(map[string]*transferRate),
+		deletions:          make(map[string]*pendingDeletions),
+		decryptQueues:      make(map[protocol.DeviceID]*decryptQueue),
+		scans:              make(map[string]*scanProgress),
+		verifying:          make(map[string]bool),
+		deferredScans:      make(map[string]*deferredScan),
+		weakCache:          make(map[string]weakHashEntry),
+
+		fmut:     sync.NewRWMutex(),
+		pmut:     sync.NewRWMutex(),
+		rvmut:    sync.NewRWMutex(),
+		ratesMut: sync.NewMutex(),
+		delMut:   sync.NewMutex(),
+		encMut:   sync.NewMutex(),
+		scanMut:  sync.NewMutex(),
+		weakMut:  sync.NewMutex(),
+	}
+	opts := cfg.Options()
+	scanner.SetHashLimits(1024*int64(opts.MaxHashKiBs), opts.MaxHashers, opts.LowPriorityIO)
 	if cfg.Options().ProgressUpdateIntervalS > -1 {
 		go m.progressEmitter.Serve()
 	}
+	m.Add(newTempIndexSender(m))
+	m.Add(newRateSampler(m))
+	m.scheduler = schedule.NewScheduler(cfg.Raw().Schedules, m.scheduleChanged)
+	m.Add(m.scheduler)
 
 	return m
 }
@@ -179,17 +223,24 @@ func (m *Model) StartFolderRW(folder string) {
 			l.Fatalf("Requested versioning type %q that does not exist", cfg.Versioning.Type)
 		}
 
-		versioner := factory(folder, cfg.Path(), cfg.Versioning.Params)
-		if service, ok := versioner.(suture.Service); ok {
+		ver := factory(folder, cfg.Path(), cfg.Versioning.Params)
+		if service, ok := ver.(suture.Service); ok {
 			// The versioner implements the suture.Service interface, so
 			// expects to be run in the background in addition to being called
 			// when files are going to be archived.
 			m.Add(service)
 		}
-		p.versioner = versioner
+		if indexed, ok := ver.(versioner.IndexedVersioner); ok {
+			indexed.SetIndex(func(name string) (protocol.FileInfo, bool) {
+				return m.CurrentFolderFile(folder, name)
+			})
+		}
+		p.versioner = ver
 	}
 
 	m.Add(p)
+	m.startWatcher(cfg)
+	m.startVerifier(cfg)
 }
 
 // StartFolderRO starts read only processing on the current model. When in
@@ -211,10 +262,14 @@ func (m *Model) StartFolderRO(folder string) {
 	m.fmut.Unlock()
 
 	go s.Serve()
+	m.startWatcher(cfg)
+	m.startVerifier(cfg)
 }
 
 type ConnectionInfo struct {
 	protocol.Statistics
+	InBytesRate   float64 // bytes per second
+	OutBytesRate  float64
 	Address       string
 	ClientVersion string
 }
@@ -224,6 +279,8 @@ func (info ConnectionInfo) MarshalJSON() ([]byte, error) {
 		"at":            info.At,
 		"inBytesTotal":  info.InBytesTotal,
 		"outBytesTotal": info.OutBytesTotal,
+		"inBytesRate":   info.InBytesRate,
+		"outBytesRate":  info.OutBytesRate,
 		"address":       info.Address,
 		"clientVersion": info.ClientVersion,
 	})
@@ -252,13 +309,11 @@ func (m *Model) ConnectionStats() map[string]interface{} {
 		conns[device.String()] = ci
 	}
 
-	res["connections"] = conns
-
 	m.fmut.RUnlock()
 	m.pmut.RUnlock()
 
 	in, out := protocol.TotalInOut()
-	res["total"] = ConnectionInfo{
+	total := ConnectionInfo{
 		Statistics: protocol.Statistics{
 			At:            time.Now(),
 			InBytesTotal:  in,
@@ -266,6 +321,17 @@ func (m *Model) ConnectionStats() map[string]interface{} {
 		},
 	}
 
+	m.ratesMut.Lock()
+	for device, ci := range conns {
+		ci.InBytesRate, ci.OutBytesRate = m.rate(device)
+		conns[device] = ci
+	}
+	total.InBytesRate, total.OutBytesRate = m.rate("total")
+	m.ratesMut.Unlock()
+
+	res["connections"] = conns
+	res["total"] = total
+
 	return res
 }
 
@@ -490,6 +556,11 @@ func (m *Model) Index(deviceID protocol.DeviceID, folder string, fs []protocol.F
 		return
 	}
 
+	if _, ok := m.encryptedDevice(folder, deviceID); ok {
+		m.decryptIndex(deviceID, folder, fs, true)
+		return
+	}
+
 	m.fmut.RLock()
 	files, ok := m.folderFiles[folder]
 	runner := m.folderRunners[folder]
@@ -505,25 +576,10 @@ func (m *Model) Index(deviceID protocol.DeviceID, folder string, fs []protocol.F
 		l.Fatalf("Index for nonexistant folder %q", folder)
 	}
 
-	for i := 0; i < len(fs); {
-		if fs[i].Flags&^protocol.FlagsAll != 0 {
-			if debug {
-				l.Debugln("dropping update for file with unknown bits set", fs[i])
-			}
-			fs[i] = fs[len(fs)-1]
-			fs = fs[:len(fs)-1]
-		} else if symlinkInvalid(folder, fs[i]) {
-			if debug {
-				l.Debugln("dropping update for unsupported symlink", fs[i])
-			}
-			fs[i] = fs[len(fs)-1]
-			fs = fs[:len(fs)-1]
-		} else {
-			i++
-		}
-	}
+	fs = filterRemoteFiles(folder, fs)
 
 	files.Replace(deviceID, fs)
+	m.clearTempIndex(deviceID, folder, nil)
 
 	events.Default.Log(events.RemoteIndexUpdated, map[string]interface{}{
 		"device":  deviceID.String(),
@@ -536,6 +592,10 @@ func (m *Model) Index(deviceID protocol.DeviceID, folder string, fs []protocol.F
 // IndexUpdate is called for incremental updates to connected devices' indexes.
 // Implements the protocol.Model interface.
 func (m *Model) IndexUpdate(deviceID protocol.DeviceID, folder string, fs []protocol.FileInfo, flags uint32, options []protocol.Option) {
+	if flags == protocol.FlagIndexTemporary {
+		m.tempIndexUpdate(deviceID, folder, fs)
+		return
+	}
 	if flags != 0 {
 		l.Warnln("protocol error: unknown flags 0x%x in IndexUpdate message", flags)
 		return
@@ -550,6 +610,11 @@ func (m *Model) IndexUpdate(deviceID protocol.DeviceID, folder string, fs []prot
 		return
 	}
 
+	if _, ok := m.encryptedDevice(folder, deviceID); ok {
+		m.decryptIndex(deviceID, folder, fs, false)
+		return
+	}
+
 	m.fmut.RLock()
 	files := m.folderFiles[folder]
 	runner, ok := m.folderRunners[folder]
@@ -559,6 +624,24 @@ func (m *Model) IndexUpdate(deviceID protocol.DeviceID, folder string, fs []prot
 		l.Fatalf("IndexUpdate for nonexistant folder %q", folder)
 	}
 
+	fs = filterRemoteFiles(folder, fs)
+
+	files.Update(deviceID, fs)
+	m.clearTempIndex(deviceID, folder, fs)
+
+	events.Default.Log(events.RemoteIndexUpdated, map[string]interface{}{
+		"device":  deviceID.String(),
+		"folder":  folder,
+		"items":   len(fs),
+		"version": files.LocalVersion(deviceID),
+	})
+
+	runner.IndexUpdated()
+}
+
+// filterRemoteFiles drops the files of an index from a remote device that we
+// can't handle.
+func filterRemoteFiles(folder string, fs []protocol.FileInfo) []protocol.FileInfo {
 	for i := 0; i < len(fs); {
 		if fs[i].Flags&^protocol.FlagsAll != 0 {
 			if debug {
@@ -576,17 +659,7 @@ func (m *Model) IndexUpdate(deviceID protocol.DeviceID, folder string, fs []prot
 			i++
 		}
 	}
-
-	files.Update(deviceID, fs)
-
-	events.Default.Log(events.RemoteIndexUpdated, map[string]interface{}{
-		"device":  deviceID.String(),
-		"folder":  folder,
-		"items":   len(fs),
-		"version": files.LocalVersion(deviceID),
-	})
-
-	runner.IndexUpdated()
+	return fs
 }
 
 func (m *Model) folderSharedWith(folder string, deviceID protocol.DeviceID) bool {
@@ -607,6 +680,8 @@ func (m *Model) ClusterConfig(deviceID protocol.DeviceID, cm protocol.ClusterCon
 	} else {
 		m.deviceVer[deviceID] = cm.ClientName + " " + cm.ClientVersion
 	}
+	features := parseFeatures(cm)
+	m.deviceFeatures[deviceID] = features
 
 	event := map[string]string{
 		"id":            deviceID.String(),
@@ -620,6 +695,8 @@ func (m *Model) ClusterConfig(deviceID protocol.DeviceID, cm protocol.ClusterCon
 
 	m.pmut.Unlock()
 
+	m.deviceStatRef(deviceID).SetFeatures(featureList(features))
+
 	events.Default.Log(events.DeviceConnected, event)
 
 	l.Infof(`Device %s client is "%s %s"`, deviceID, cm.ClientName, cm.ClientVersion)
@@ -735,7 +812,15 @@ func (m *Model) Close(device protocol.DeviceID, err error) {
 	delete(m.protoConn, device)
 	delete(m.rawConn, device)
 	delete(m.deviceVer, device)
+	delete(m.deviceFeatures, device)
+	delete(m.tempIndexes, device)
 	m.pmut.Unlock()
+
+	m.stopDecrypting(device)
+
+	m.ratesMut.Lock()
+	delete(m.rates, device.String())
+	m.ratesMut.Unlock()
 }
 
 // Request returns the specified data segment by reading it from local disk.
@@ -750,11 +835,31 @@ func (m *Model) Request(deviceID protocol.DeviceID, folder, name string, offset
 		return nil, protocol.ErrNoSuchFile
 	}
 
+	if m.folderPaused(folder) {
+		return nil, protocol.ErrNoSuchFile
+	}
+
+	if key, ok := m.encryptedDevice(folder, deviceID); ok {
+		if flags != 0 || len(options) > 0 {
+			// Temporary files and weak hashes are not shared in
+			// encrypted form.
+			return nil, protocol.ErrNoSuchFile
+		}
+		return m.requestEncrypted(key, folder, name, offset, size)
+	}
+
+	if flags == protocol.FlagRequestTemporary {
+		return m.requestTemporary(folder, name, offset, size, hash)
+	}
 	if flags != 0 {
-		// We don't currently support or expect any flags.
+		// We don't currently support or expect any other flags.
 		return nil, fmt.Errorf("protocol error: unknown flags 0x%x in Request message", flags)
 	}
 
+	if getOption(options, "type") == featureWeakHashes {
+		return m.weakHashes(folder, name)
+	}
+
 	// Verify that the requested file exists in the local model. We only need
 	// to validate this file if we haven't done so recently, so we keep a
 	// cache of successfull results. "Recently" can be quite a long time, as
@@ -883,7 +988,18 @@ type cFiler struct {
 
 // Implements scanner.CurrentFiler
 func (cf cFiler) CurrentFile(file string) (protocol.FileInfo, bool) {
-	return cf.m.CurrentFolderFile(cf.r, file)
+	f, ok := cf.m.CurrentFolderFile(cf.r, file)
+	if isLocalChanged(f) {
+		// Compare against the local change, which is what we last saw on
+		// disk, so that it isn't rehashed on every scan.
+		f.Flags &^= protocol.FlagInvalid | flagLocalChanged
+	}
+	if isCorrupt(f) {
+		// A corrupted file is rescanned only once it changes, lest the
+		// corruption be taken for a local change.
+		f.Flags &^= protocol.FlagInvalid | flagLocalCorrupt
+	}
+	return f, ok
 }
 
 // ConnectedTo returns true if we are connected to the named device.
@@ -977,7 +1093,18 @@ func (m *Model) AddConnection(rawConn io.Closer, protoConn protocol.Connection)
 	m.fmut.RLock()
 	for _, folder := range m.deviceFolders[deviceID] {
 		fs := m.folderFiles[folder]
-		go sendIndexes(protoConn, folder, fs, m.folderIgnores[folder])
+		var enc *encrypter
+		if cfg := m.folderCfgs[folder]; isEncrypted(cfg, deviceID) {
+			key, ok := m.folderKeys[folder]
+			if !ok {
+				l.Warnf("Folder %q is shared with %s in encrypted mode but has no password; not sending index.", folder, deviceID)
+				continue
+			}
+			enc = newEncrypter(m.db, cfg, key)
+		}
+		go sendIndexes(protoConn, folder, fs, m.folderIgnores[folder], enc, func() bool {
+			return m.deviceHasVariableBlocks(deviceID)
+		})
 	}
 	m.fmut.RUnlock()
 	m.pmut.Unlock()
@@ -1018,7 +1145,11 @@ func (m *Model) receivedFile(folder string, file protocol.FileInfo) {
 	m.folderStatRef(folder).ReceivedFile(file)
 }
 
-func sendIndexes(conn protocol.Connection, folder string, fs *db.FileSet, ignores *ignore.Matcher) {
+// sendIndexes sends the index of the folder to the device, and updates to it
+// as they happen. The files are encrypted by enc, if set, for a device that
+// isn't trusted with the contents of the folder. Files with blocks larger than
+// protocol.BlockSize are held back until variableBlocks returns true.
+func sendIndexes(conn protocol.Connection, folder string, fs *db.FileSet, ignores *ignore.Matcher, enc *encrypter, variableBlocks func() bool) {
 	deviceID := conn.ID()
 	name := conn.Name()
 	var err error
@@ -1027,15 +1158,21 @@ func sendIndexes(conn protocol.Connection, folder string, fs *db.FileSet, ignore
 		l.Debugf("sendIndexes for %s-%s/%q starting", deviceID, name, folder)
 	}
 
-	minLocalVer, err := sendIndexTo(true, 0, conn, folder, fs, ignores)
+	variable := variableBlocks()
+	minLocalVer, err := sendIndexTo(true, 0, conn, folder, fs, ignores, enc, variable)
 
 	for err == nil {
 		time.Sleep(5 * time.Second)
-		if fs.LocalVersion(protocol.LocalDeviceID) <= minLocalVer {
+		if !variable && variableBlocks() {
+			// The device announced that it handles larger blocks after we
+			// started; send the files we held back.
+			variable = true
+			minLocalVer = 0
+		} else if fs.LocalVersion(protocol.LocalDeviceID) <= minLocalVer {
 			continue
 		}
 
-		minLocalVer, err = sendIndexTo(false, minLocalVer, conn, folder, fs, ignores)
+		minLocalVer, err = sendIndexTo(false, minLocalVer, conn, folder, fs, ignores, enc, variable)
 	}
 
 	if debug {
@@ -1043,7 +1180,7 @@ func sendIndexes(conn protocol.Connection, folder string, fs *db.FileSet, ignore
 	}
 }
 
-func sendIndexTo(initial bool, minLocalVer int64, conn protocol.Connection, folder string, fs *db.FileSet, ignores *ignore.Matcher) (int64, error) {
+func sendIndexTo(initial bool, minLocalVer int64, conn protocol.Connection, folder string, fs *db.FileSet, ignores *ignore.Matcher, enc *encrypter, variableBlocks bool) (int64, error) {
 	deviceID := conn.ID()
 	name := conn.Name()
 	batch := make([]protocol.FileInfo, 0, indexBatchSize)
@@ -1068,6 +1205,22 @@ func sendIndexTo(initial bool, minLocalVer int64, conn protocol.Connection, fold
 			return true
 		}
 
+		if isLocalChanged(f) {
+			if debug {
+				l.Debugln("not sending update for local change in receive only folder", f)
+			}
+			return true
+		}
+
+		if enc == nil && !variableBlocks && scanner.BlockSizeOf(f.Blocks) > protocol.BlockSize {
+			// The next scan rehashes it with smaller blocks. Encrypted
+			// devices only see the ciphertext, hashed to suit them below.
+			if debug {
+				l.Debugln("not sending update with large blocks to older device", f)
+			}
+			return true
+		}
+
 		if len(batch) == indexBatchSize || currentBatchSize > indexTargetSize {
 			if initial {
 				if err = conn.Index(folder, batch, 0, nil); err != nil {
@@ -1090,6 +1243,13 @@ func sendIndexTo(initial bool, minLocalVer int64, conn protocol.Connection, fold
 			currentBatchSize = 0
 		}
 
+		// Corrupted files are announced as invalid.
+		f.Flags &^= flagLocalCorrupt
+
+		if enc != nil {
+			f = enc.encryptedFile(f, variableBlocks)
+		}
+
 		batch = append(batch, f)
 		currentBatchSize += indexPerFileSize + len(f.Blocks)*indexPerBlockSize
 		return true
@@ -1142,6 +1302,10 @@ func (m *Model) requestGlobal(deviceID protocol.DeviceID, folder, name string, o
 		l.Debugf("%v REQ(out): %s: %q / %q o=%d s=%d h=%x f=%x op=%s", m, deviceID, folder, name, offset, size, hash, flags, options)
 	}
 
+	if key, ok := m.encryptedDevice(folder, deviceID); ok {
+		return m.requestDecrypted(nc, key, folder, name, offset, size)
+	}
+
 	return nc.Request(folder, name, offset, size, hash, flags, options)
 }
 
@@ -1167,6 +1331,10 @@ func (m *Model) AddFolder(cfg config.FolderConfiguration) {
 	_ = ignores.Load(filepath.Join(cfg.Path(), ".stignore")) // Ignore error, there might not be an .stignore
 	m.folderIgnores[cfg.ID] = ignores
 
+	if cfg.EncryptionPassword != "" {
+		m.folderKeys[cfg.ID] = encryption.NewKey(cfg.ID, cfg.EncryptionPassword)
+	}
+
 	m.fmut.Unlock()
 }
 
@@ -1187,6 +1355,10 @@ func (m *Model) ScanFolders() map[string]error {
 		folder := folder
 		go func() {
 			err := m.ScanFolder(folder)
+			if err == errFolderPaused {
+				// Paused folders are simply not scanned; that's not an error.
+				err = nil
+			}
 			if err != nil {
 				errorsMut.Lock()
 				errors[folder] = err
@@ -1224,6 +1396,10 @@ func (m *Model) ScanFolderSubs(folder string, subs []string) error {
 		return errors.New("no such folder")
 	}
 
+	if m.folderPaused(folder) {
+		return errFolderPaused
+	}
+
 	return runner.Scan(subs)
 }
 
@@ -1275,11 +1451,18 @@ nextSub:
 	}
 	subs = unifySubs
 
+	// Variable, depending on file size, unless an older device could get
+	// the files.
+	blockSize := 0
+	if !m.variableBlocks(folder) {
+		blockSize = protocol.BlockSize
+	}
+
 	w := &scanner.Walker{
 		Dir:           folderCfg.Path(),
 		Subs:          subs,
 		Matcher:       ignores,
-		BlockSize:     protocol.BlockSize,
+		BlockSize:     blockSize,
 		TempNamer:     defTempNamer,
 		TempLifetime:  time.Duration(m.cfg.Options().KeepTemporariesH) * time.Hour,
 		CurrentFiler:  cFiler{m, folder},
@@ -1288,8 +1471,14 @@ nextSub:
 		AutoNormalize: folderCfg.AutoNormalize,
 		Hashers:       m.numHashers(folder),
 		ShortID:       m.shortID,
+		Progress:      scanner.NewProgressCounter(),
+		MinFileAge:    time.Duration(folderCfg.MinFileAgeS) * time.Second,
 	}
 
+	// The walker removes temporary files older than TempLifetime; forget
+	// about resuming those downloads as well.
+	db.NewPullStateRepo(m.db, folder).Expire(w.TempLifetime)
+
 	runner.setState(FolderScanning)
 
 	fchan, err := w.Walk()
@@ -1304,6 +1493,9 @@ nextSub:
 		return err
 	}
 
+	progress := m.startScanProgress(folder, w.Progress)
+	defer m.stopScanProgress(folder, progress)
+
 	batchSizeFiles := 100
 	batchSizeBlocks := 2048 // about 256 MB
 
@@ -1320,10 +1512,18 @@ nextSub:
 			batch = batch[:0]
 			blocksHandled = 0
 		}
+		if folderCfg.ReceiveOnly {
+			f = receiveOnlyFile(fs, f)
+		}
 		batch = append(batch, f)
 		blocksHandled += len(f.Blocks)
 	}
 
+	// Files still being written to are picked up again once they settle.
+	if deferred := w.Deferred(); len(deferred) > 0 {
+		m.deferScan(folder, deferred, w.MinFileAge)
+	}
+
 	if err := m.CheckFolderHealth(folder); err != nil {
 		l.Infof("Stopping folder %s mid-scan due to folder error: %s", folder, err)
 		return err
@@ -1353,7 +1553,7 @@ nextSub:
 
 		seenPrefix = true
 		if !f.IsDeleted() {
-			if f.IsInvalid() {
+			if f.IsInvalid() && !isLocalChanged(f) && !isCorrupt(f) {
 				return true
 			}
 
@@ -1373,7 +1573,7 @@ nextSub:
 				}
 				nf := protocol.FileInfo{
 					Name:     f.Name,
-					Flags:    f.Flags | protocol.FlagInvalid,
+					Flags:    f.Flags&^(flagLocalChanged|flagLocalCorrupt) | protocol.FlagInvalid,
 					Modified: f.Modified,
 					Version:  f.Version, // The file is still the same, so don't bump version
 				}
@@ -1394,6 +1594,12 @@ nextSub:
 					Modified: f.Modified,
 					Version:  f.Version.Update(m.shortID),
 				}
+				if isCorrupt(f) {
+					nf.Flags &^= protocol.FlagInvalid | flagLocalCorrupt
+				}
+				if folderCfg.ReceiveOnly {
+					nf = receiveOnlyFile(fs, nf)
+				}
 				batch = append(batch, nf)
 			}
 		}
@@ -1427,7 +1633,8 @@ func (m *Model) DelayScan(folder string, next time.Duration) {
 }
 
 // numHashers returns the number of hasher routines to use for a given folder,
-// taking into account configuration and available CPU cores.
+// taking into account configuration and available CPU cores. How many of
+// them actually hash at once is limited by the shared pool of the scanner.
 func (m *Model) numHashers(folder string) int {
 	m.fmut.Lock()
 	folderCfg := m.folderCfgs[folder]
@@ -1439,11 +1646,16 @@ func (m *Model) numHashers(folder string) int {
 		return folderCfg.Hashers
 	}
 
-	if perFolder := runtime.GOMAXPROCS(-1) / numFolders; perFolder > 0 {
-		// We have CPUs to spare, divide them per folder.
+	// The hashers of all folders share a pool of one per CPU, unless
+	// configured otherwise. Divide it per folder, so that there are about
+	// as many hashers in total as there is room for in the pool.
+	pool := m.cfg.Options().MaxHashers
+	if pool <= 0 {
+		pool = runtime.GOMAXPROCS(-1)
+	}
+	if perFolder := pool / numFolders; perFolder > 0 {
 		return perFolder
 	}
-
 	return 1
 }
 
@@ -1457,6 +1669,10 @@ func (m *Model) clusterConfig(device protocol.DeviceID) protocol.ClusterConfigMe
 				Key:   "name",
 				Value: m.deviceName,
 			},
+			{
+				Key:   "features",
+				Value: strings.Join(localFeatures, ","),
+			},
 		},
 	}
 
@@ -1750,10 +1966,17 @@ func (m *Model) VerifyConfiguration(from, to config.Configuration) error {
 func (m *Model) CommitConfiguration(from, to config.Configuration) bool {
 	// TODO: This should not use reflect, and should take more care to try to handle stuff without restart.
 
-	// Adding, removing or changing folders requires restart
-	if !reflect.DeepEqual(from.Folders, to.Folders) {
+	m.scheduler.SetSchedules(to.Schedules)
+
+	// The hashing limits apply to hashing started from now on.
+	scanner.SetHashLimits(1024*int64(to.Options.MaxHashKiBs), to.Options.MaxHashers, to.Options.LowPriorityIO)
+
+	// Adding, removing or changing folders requires restart, except for
+	// pausing and resuming them.
+	if !reflect.DeepEqual(unpausedFolders(from.Folders), unpausedFolders(to.Folders)) {
 		return false
 	}
+	m.commitPaused(from, to)
 
 	// Removing a device requres restart
 	toDevs := make(map[protocol.DeviceID]bool, len(from.Devices))
@@ -1766,14 +1989,30 @@ func (m *Model) CommitConfiguration(from, to config.Configuration) bool {
 		}
 	}
 
-	// All of the generic options require restart
-	if !reflect.DeepEqual(from.Options, to.Options) {
+	// All of the generic options require restart, except the hashing limits
+	if !reflect.DeepEqual(withoutHashLimits(from.Options), withoutHashLimits(to.Options)) {
 		return false
 	}
 
 	return true
 }
 
+func withoutHashLimits(opts config.OptionsConfiguration) config.OptionsConfiguration {
+	opts.MaxHashKiBs = 0
+	opts.MaxHashers = 0
+	opts.LowPriorityIO = false
+	return opts
+}
+
+func unpausedFolders(folders []config.FolderConfiguration) []config.FolderConfiguration {
+	res := make([]config.FolderConfiguration, len(folders))
+	for i, folder := range folders {
+		folder.Paused = false
+		res[i] = folder
+	}
+	return res
+}
+
 func symlinkInvalid(folder string, fi db.FileIntf) bool {
 	if !symlinks.Supported && fi.IsSymlink() && !fi.IsInvalid() && !fi.IsDeleted() {
 		symlinkWarning.Do(func() {
@@ -57,6 +57,35 @@ func init() {
 	defaultConfig = config.Wrap("/tmp/test", _defaultConfig)
 }
 
+// setupFolderModel returns a model with the given folder started, and the
+// folder's directory. The directory is a new temporary one with just the
+// folder marker in it, to be removed by the caller. The folder is shared with
+// device1 unless it says otherwise.
+func setupFolderModel(t *testing.T, fcfg config.FolderConfiguration) (*Model, string) {
+	dir, err := ioutil.TempDir("", "model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ".stfolder"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fcfg.RawPath = dir
+	if len(fcfg.Devices) == 0 {
+		fcfg.Devices = []config.FolderDeviceConfiguration{{DeviceID: device1}}
+	}
+	cfg := config.Wrap("/tmp/test", config.Configuration{
+		Folders: []config.FolderConfiguration{fcfg},
+		Devices: []config.DeviceConfiguration{{DeviceID: device1}},
+	})
+
+	ldb, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel(cfg, protocol.LocalDeviceID, "device", "syncthing", "dev", ldb)
+	m.AddFolder(fcfg)
+	m.StartFolderRO(fcfg.ID)
+	return m, dir
+}
+
 var testDataExpected = map[string]protocol.FileInfo{
 	"foo": {
 		Name:     "foo",
@@ -1192,3 +1221,35 @@ func benchmarkTree(b *testing.B, n1, n2 int) {
 	}
 	b.ReportAllocs()
 }
+
+func TestVariableBlocks(t *testing.T) {
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel(defaultConfig, protocol.LocalDeviceID, "device", "syncthing", "dev", db)
+	m.AddFolder(defaultFolderConfig)
+
+	// Until we know better, device1 could be an older device.
+	if m.variableBlocks("default") {
+		t.Error("variable blocks before device1 connected")
+	}
+
+	m.ClusterConfig(device1, protocol.ClusterConfigMessage{
+		Options: []protocol.Option{{Key: "features", Value: featureWeakHashes}},
+	})
+	if m.variableBlocks("default") {
+		t.Error("variable blocks with a device without the feature")
+	}
+
+	m.ClusterConfig(device1, protocol.ClusterConfigMessage{
+		Options: []protocol.Option{{Key: "features", Value: featureWeakHashes + "," + featureVariableBlocks}},
+	})
+	if !m.variableBlocks("default") {
+		t.Error("no variable blocks with all devices having the feature")
+	}
+
+	// The features are remembered across restarts.
+	m = NewModel(defaultConfig, protocol.LocalDeviceID, "device", "syncthing", "dev", db)
+	m.AddFolder(defaultFolderConfig)
+	if !m.variableBlocks("default") {
+		t.Error("device features not remembered")
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"errors"
+
+	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/events"
+	"github.com/syncthing/syncthing/internal/schedule"
+)
+
+var errFolderPaused = errors.New("folder is paused")
+
+// folderPaused returns true if the folder is paused, in the configuration or
+// by schedule. A paused folder is neither pulled, scanned nor served to other
+// devices.
+func (m *Model) folderPaused(folder string) bool {
+	m.fmut.RLock()
+	paused := m.folderCfgs[folder].Paused
+	m.fmut.RUnlock()
+	return paused || m.scheduler.Current().PausedFolders[folder]
+}
+
+// ScheduledSettings returns the settings in effect by schedule.
+func (m *Model) ScheduledSettings() schedule.Settings {
+	return m.scheduler.Current()
+}
+
+// commitPaused applies changes to the paused state of folders and devices.
+// It's called from CommitConfiguration, so it must not touch m.cfg.
+func (m *Model) commitPaused(from, to config.Configuration) {
+	fromFolders := make(map[string]bool, len(from.Folders))
+	for _, folder := range from.Folders {
+		fromFolders[folder.ID] = folder.Paused
+	}
+	for _, folder := range to.Folders {
+		if paused, ok := fromFolders[folder.ID]; ok && paused != folder.Paused {
+			m.setFolderPaused(folder.ID, folder.Paused)
+		}
+	}
+
+	fromDevices := make(map[protocol.DeviceID]bool, len(from.Devices))
+	for _, dev := range from.Devices {
+		fromDevices[dev.DeviceID] = dev.Paused
+	}
+	scheduled := m.scheduler.Current().PausedDevices
+	for _, dev := range to.Devices {
+		paused, ok := fromDevices[dev.DeviceID]
+		if !ok || paused == dev.Paused || scheduled[dev.DeviceID] {
+			continue
+		}
+		m.devicePauseChanged(dev.DeviceID, dev.Paused)
+	}
+}
+
+// scheduleChanged applies changes to pausing by schedule.
+func (m *Model) scheduleChanged(from, to schedule.Settings) {
+	m.fmut.RLock()
+	var folders []string
+	for folder, cfg := range m.folderCfgs {
+		if !cfg.Paused && from.PausedFolders[folder] != to.PausedFolders[folder] {
+			folders = append(folders, folder)
+		}
+	}
+	m.fmut.RUnlock()
+	for _, folder := range folders {
+		m.folderPauseChanged(folder, to.PausedFolders[folder])
+	}
+
+	for id, cfg := range m.cfg.Devices() {
+		if !cfg.Paused && from.PausedDevices[id] != to.PausedDevices[id] {
+			m.devicePauseChanged(id, to.PausedDevices[id])
+		}
+	}
+}
+
+func (m *Model) setFolderPaused(folder string, paused bool) {
+	m.fmut.Lock()
+	cfg, ok := m.folderCfgs[folder]
+	if ok {
+		cfg.Paused = paused
+		m.folderCfgs[folder] = cfg
+	}
+	m.fmut.Unlock()
+
+	if ok && !m.scheduler.Current().PausedFolders[folder] {
+		m.folderPauseChanged(folder, paused)
+	}
+}
+
+func (m *Model) folderPauseChanged(folder string, paused bool) {
+	m.fmut.RLock()
+	runner := m.folderRunners[folder]
+	m.fmut.RUnlock()
+
+	if paused {
+		l.Infof("Folder %q paused", folder)
+		if runner != nil {
+			runner.setState(FolderPaused)
+		}
+		events.Default.Log(events.FolderPaused, map[string]interface{}{
+			"folder": folder,
+		})
+		return
+	}
+
+	l.Infof("Folder %q resumed", folder)
+	events.Default.Log(events.FolderResumed, map[string]interface{}{
+		"folder": folder,
+	})
+	if runner != nil {
+		runner.setState(FolderIdle)
+		// Catch up on what we've missed while paused. The runner may be
+		// busy, so don't wait for it.
+		go func() {
+			runner.DelayScan(0)
+			runner.IndexUpdated()
+		}()
+	}
+}
+
+func (m *Model) devicePauseChanged(device protocol.DeviceID, paused bool) {
+	if paused {
+		l.Infof("Device %v paused", device)
+		events.Default.Log(events.DevicePaused, map[string]interface{}{
+			"device": device.String(),
+		})
+		// The connection service won't connect to it again until it's
+		// resumed.
+		go m.disconnect(device)
+		return
+	}
+
+	l.Infof("Device %v resumed", device)
+	events.Default.Log(events.DeviceResumed, map[string]interface{}{
+		"device": device.String(),
+	})
+}
+
+// disconnect closes the connection to the device, if there is one.
+func (m *Model) disconnect(device protocol.DeviceID) {
+	m.pmut.RLock()
+	conn, ok := m.rawConn[device]
+	m.pmut.RUnlock()
+	if ok {
+		conn.Close()
+	}
+}
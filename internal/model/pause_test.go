@@ -0,0 +1,99 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/schedule"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestFolderPause(t *testing.T) {
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel(defaultConfig, protocol.LocalDeviceID, "device", "syncthing", "dev", db)
+	m.AddFolder(defaultFolderConfig)
+	m.StartFolderRO("default")
+	m.ScanFolder("default")
+
+	from := defaultConfig.Raw().Copy()
+	to := from.Copy()
+	to.Folders[0].Paused = true
+	to.Devices[0].Paused = true
+
+	if !m.CommitConfiguration(from, to) {
+		t.Fatal("Pausing should not require a restart")
+	}
+
+	if state, _, _ := m.State("default"); state != "paused" {
+		t.Errorf("Incorrect state %q for paused folder", state)
+	}
+	if err := m.ScanFolder("default"); err != errFolderPaused {
+		t.Errorf("Unexpected error %v scanning paused folder", err)
+	}
+	if errs := m.ScanFolders(); len(errs) != 0 {
+		t.Errorf("Unexpected errors %v scanning all folders", errs)
+	}
+	if _, _, err := m.State("default"); err != nil {
+		t.Errorf("Unexpected error %v on paused folder", err)
+	}
+	if _, err := m.Request(device1, "default", "foo", 0, 6, nil, 0, nil); err == nil {
+		t.Error("Unexpected nil error for request in paused folder")
+	}
+
+	if !m.CommitConfiguration(to, from) {
+		t.Fatal("Resuming should not require a restart")
+	}
+
+	if err := m.ScanFolder("default"); err != nil {
+		t.Errorf("Unexpected error %v scanning resumed folder", err)
+	}
+	bs, err := m.Request(device1, "default", "foo", 0, 6, nil, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != "foobar" {
+		t.Errorf("Incorrect data %q", bs)
+	}
+
+	// Other changes to the folder still need a restart.
+	to = from.Copy()
+	to.Folders[0].Paused = true
+	to.Folders[0].IgnorePerms = true
+	if m.CommitConfiguration(from, to) {
+		t.Error("Changing the folder should require a restart")
+	}
+}
+
+func TestScheduledPause(t *testing.T) {
+	raw := defaultConfig.Raw().Copy()
+	raw.Schedules = []config.ScheduleConfiguration{
+		{Start: "00:00", End: "00:00", Pullers: 3, PauseFolders: []string{"default"}},
+	}
+	cfg := config.Wrap("/tmp/test", raw)
+
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel(cfg, protocol.LocalDeviceID, "device", "syncthing", "dev", db)
+	m.AddFolder(defaultFolderConfig)
+	m.StartFolderRO("default")
+
+	if s := m.ScheduledSettings(); s.Pullers != 3 {
+		t.Errorf("Incorrect scheduled settings %+v", s)
+	}
+	if err := m.ScanFolder("default"); err != errFolderPaused {
+		t.Errorf("Unexpected error %v scanning folder paused by schedule", err)
+	}
+
+	m.scheduleChanged(m.ScheduledSettings(), schedule.Current(nil, time.Now()))
+	if state, _, _ := m.State("default"); state != "idle" {
+		t.Errorf("Incorrect state %q after schedule ended", state)
+	}
+}
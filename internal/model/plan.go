@@ -0,0 +1,247 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/db"
+	"github.com/syncthing/syncthing/internal/ignore"
+)
+
+// A PullPlan describes the changes that pulling a folder would make, or
+// that overriding it would make on the other devices.
+type PullPlan struct {
+	Create   []PlannedItem     `json:"create"`
+	Update   []PlannedItem     `json:"update"`
+	Rename   []PlannedRename   `json:"rename"`
+	Delete   []PlannedItem     `json:"delete"`
+	Conflict []PlannedConflict `json:"conflict"`
+
+	CreateBytes int64 `json:"createBytes"`
+	UpdateBytes int64 `json:"updateBytes"`
+	RenameBytes int64 `json:"renameBytes"`
+	DeleteBytes int64 `json:"deleteBytes"`
+
+	DeletionsHeld bool `json:"deletionsHeld"` // The deletions would be held back for approval
+}
+
+type PlannedItem struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // "file", "dir" or "symlink"
+	Size int64  `json:"size"`
+}
+
+type PlannedRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Size int64  `json:"size"`
+}
+
+type PlannedConflict struct {
+	Name       string `json:"name"`
+	Resolution string `json:"resolution"` // "keepBoth", "remoteWins" or "localWins"
+}
+
+func newPullPlan() PullPlan {
+	return PullPlan{
+		Create:   []PlannedItem{},
+		Update:   []PlannedItem{},
+		Rename:   []PlannedRename{},
+		Delete:   []PlannedItem{},
+		Conflict: []PlannedConflict{},
+	}
+}
+
+func (plan *PullPlan) create(f protocol.FileInfo) {
+	plan.Create = append(plan.Create, plannedItem(f))
+	plan.CreateBytes += f.Size()
+}
+
+func (plan *PullPlan) update(f protocol.FileInfo) {
+	plan.Update = append(plan.Update, plannedItem(f))
+	plan.UpdateBytes += f.Size()
+}
+
+func (plan *PullPlan) rename(from, to protocol.FileInfo) {
+	plan.Rename = append(plan.Rename, PlannedRename{From: from.Name, To: to.Name, Size: to.Size()})
+	plan.RenameBytes += to.Size()
+}
+
+// delete records the deletion of the given current file.
+func (plan *PullPlan) delete(cur protocol.FileInfo) {
+	plan.Delete = append(plan.Delete, plannedItem(cur))
+	plan.DeleteBytes += cur.Size()
+}
+
+func (plan *PullPlan) conflict(name string, res conflictResolution) {
+	plan.Conflict = append(plan.Conflict, PlannedConflict{Name: name, Resolution: res.String()})
+}
+
+func plannedItem(f protocol.FileInfo) PlannedItem {
+	typ := "file"
+	switch {
+	case f.IsSymlink():
+		typ = "symlink"
+	case f.IsDirectory():
+		typ = "dir"
+	}
+	return PlannedItem{Name: f.Name, Type: typ, Size: f.Size()}
+}
+
+// plan works out what the next puller iteration would do, going through
+// the needed files the same way as pullerIteration but without touching the
+// disk or the database.
+func (p *rwFolder) plan(fs *db.FileSet, ignores *ignore.Matcher) PullPlan {
+	plan := newPullPlan()
+
+	fileDeletions := map[string]protocol.FileInfo{}
+	var dirDeletions []protocol.FileInfo
+	var files []protocol.FileInfo
+	buckets := map[string][]protocol.FileInfo{}
+
+	fs.WithNeed(protocol.LocalDeviceID, func(intf db.FileIntf) bool {
+		file := intf.(protocol.FileInfo)
+
+		if p.skipNeeded(ignores, file) {
+			return true
+		}
+
+		switch {
+		case file.IsDeleted():
+			if file.IsDirectory() {
+				dirDeletions = append(dirDeletions, file)
+			} else {
+				fileDeletions[file.Name] = file
+				if df, ok := p.model.CurrentFolderFile(p.folder, file.Name); ok {
+					addRenameCandidate(buckets, df)
+				}
+			}
+		case file.IsDirectory() && !file.IsSymlink():
+			p.planChange(&plan, file)
+		default:
+			files = append(files, file)
+		}
+		return true
+	})
+
+	// As decided by the puller, which doesn't count approved deletions.
+	plan.DeletionsHeld = len(p.deletionsToHold(fileDeletions, dirDeletions)) > 0
+
+	for _, file := range files {
+		if candidate, ok := takeRenameCandidate(buckets, file); ok {
+			delete(fileDeletions, candidate.Name)
+			plan.rename(candidate, file)
+			continue
+		}
+		p.planChange(&plan, file)
+	}
+
+	names := make([]string, 0, len(fileDeletions))
+	for name := range fileDeletions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p.planDeletion(&plan, fileDeletions[name])
+	}
+	for i := range dirDeletions {
+		p.planDeletion(&plan, dirDeletions[len(dirDeletions)-i-1])
+	}
+
+	return plan
+}
+
+// planChange records the creation or update of the given file, applying the
+// conflict policy like handleFile would.
+func (p *rwFolder) planChange(plan *PullPlan, file protocol.FileInfo) {
+	cur, ok := p.model.CurrentFolderFile(p.folder, file.Name)
+	if ok && !file.IsDirectory() && p.inConflict(cur.Version, file.Version) {
+		res := p.resolveConflict(cur, file)
+		plan.conflict(file.Name, res)
+		if res == conflictLocalWins {
+			return
+		}
+	}
+
+	if ok && !cur.IsDeleted() {
+		plan.update(file)
+	} else {
+		plan.create(file)
+	}
+}
+
+// planDeletion records the deletion of the given file, applying the conflict
+// policy like deleteFile would.
+func (p *rwFolder) planDeletion(plan *PullPlan, file protocol.FileInfo) {
+	cur, ok := p.model.CurrentFolderFile(p.folder, file.Name)
+	if !ok || cur.IsDeleted() {
+		// Nothing to remove from disk.
+		return
+	}
+
+	if !file.IsDirectory() && p.inConflict(cur.Version, file.Version) {
+		res := p.resolveConflict(cur, file)
+		plan.conflict(file.Name, res)
+		if res == conflictLocalWins {
+			return
+		}
+	}
+
+	plan.delete(cur)
+}
+
+// PullPlan returns what pulling the given folder would do at this point.
+func (m *Model) PullPlan(folder string) (PullPlan, error) {
+	m.fmut.RLock()
+	fs, ok := m.folderFiles[folder]
+	runner := m.folderRunners[folder]
+	ignores := m.folderIgnores[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		return PullPlan{}, errors.New("no such folder")
+	}
+
+	p, ok := runner.(*rwFolder)
+	if !ok {
+		return PullPlan{}, errors.New("folder is read only")
+	}
+	return p.plan(fs, ignores), nil
+}
+
+// OverridePlan returns what overriding the given read only folder would do
+// on the other devices, which then pull our versions of the files.
+func (m *Model) OverridePlan(folder string) (PullPlan, error) {
+	m.fmut.RLock()
+	fs, ok := m.folderFiles[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		return PullPlan{}, errors.New("no such folder")
+	}
+
+	// Mirrors Override, which announces the files we don't have as deleted
+	// and our versions of the others as the newest.
+	plan := newPullPlan()
+	fs.WithNeed(protocol.LocalDeviceID, func(fi db.FileIntf) bool {
+		need := fi.(protocol.FileInfo)
+		have, ok := fs.Get(protocol.LocalDeviceID, need.Name)
+		switch {
+		case !ok || have.Name != need.Name || have.IsDeleted():
+			if !need.IsDeleted() {
+				plan.delete(need)
+			}
+		case need.IsDeleted():
+			plan.create(have)
+		default:
+			plan.update(have)
+		}
+		return true
+	})
+	return plan, nil
+}
@@ -0,0 +1,101 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/scanner"
+)
+
+func TestPullPlan(t *testing.T) {
+	m, dir := setupFolderModel(t, config.FolderConfiguration{ID: "p"})
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"a", "b", "c"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name+" data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.ScanFolder("p"); err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := func(data string) []protocol.BlockInfo {
+		bs, err := scanner.Blocks(bytes.NewReader([]byte(data)), protocol.BlockSize, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bs
+	}
+
+	a, _ := m.CurrentFolderFile("p", "a")
+	b, _ := m.CurrentFolderFile("p", "b")
+	c, _ := m.CurrentFolderFile("p", "c")
+
+	// a is renamed to d, b is changed, c is changed concurrently with our
+	// version of it and e is new.
+	deletedA := a
+	deletedA.Flags |= protocol.FlagDeleted
+	deletedA.Blocks = nil
+	deletedA.Version = a.Version.Update(device1.Short())
+	d := a
+	d.Name = "d"
+	d.Version = protocol.Vector{{ID: device1.Short(), Value: 1}}
+	b.Blocks = blocks("new b data")
+	b.Version = b.Version.Update(device1.Short())
+	c.Blocks = blocks("new c data")
+	c.Version = protocol.Vector{{ID: device1.Short(), Value: 1}}
+	e := protocol.FileInfo{
+		Name:    "e",
+		Flags:   0644,
+		Version: protocol.Vector{{ID: device1.Short(), Value: 1}},
+		Blocks:  blocks("e data"),
+	}
+	m.Index(device1, "p", []protocol.FileInfo{deletedA, b, c, d, e}, 0, nil)
+
+	p := rwFolder{model: m, folder: "p", dir: dir, shortID: m.shortID, maxDeletes: 1}
+	plan := p.plan(m.folderFiles["p"], nil)
+
+	if len(plan.Rename) != 1 || plan.Rename[0].From != "a" || plan.Rename[0].To != "d" {
+		t.Errorf("Incorrect renames %+v", plan.Rename)
+	}
+	if len(plan.Update) != 2 || plan.Update[0].Name != "b" || plan.Update[1].Name != "c" {
+		t.Errorf("Incorrect updates %+v", plan.Update)
+	}
+	if len(plan.Create) != 1 || plan.Create[0].Name != "e" || plan.CreateBytes != 6 {
+		t.Errorf("Incorrect creations %+v (%d bytes)", plan.Create, plan.CreateBytes)
+	}
+	if len(plan.Delete) != 0 {
+		t.Errorf("Unexpected deletions %+v", plan.Delete)
+	}
+	if len(plan.Conflict) != 1 || plan.Conflict[0].Name != "c" || plan.Conflict[0].Resolution != "keepBoth" {
+		t.Errorf("Incorrect conflicts %+v", plan.Conflict)
+	}
+	if plan.DeletionsHeld {
+		t.Error("A single deletion should not be held back")
+	}
+
+	// Overriding instead gives our versions of a, b and c to the others and
+	// deletes d and e.
+	plan, err := m.OverridePlan("p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Create) != 1 || len(plan.Update) != 2 || len(plan.Delete) != 2 {
+		t.Errorf("Incorrect override plan %+v", plan)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "d")); !os.IsNotExist(err) {
+		t.Error("Planning should not touch the disk")
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"reflect"
 	"time"
 
+	"github.com/syncthing/protocol"
 	"github.com/syncthing/syncthing/internal/config"
 	"github.com/syncthing/syncthing/internal/events"
 	"github.com/syncthing/syncthing/internal/sync"
@@ -147,6 +148,36 @@ func (t *ProgressEmitter) BytesCompleted(folder string) (bytes int64) {
 	return
 }
 
+// TemporaryFiles returns the files currently being pulled in the given
+// folder, each with the list of blocks that are available in its temporary
+// file.
+func (t *ProgressEmitter) TemporaryFiles(folder string) []protocol.FileInfo {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	var files []protocol.FileInfo
+	for _, s := range t.registry {
+		if s.folder == folder {
+			files = append(files, protocol.FileInfo{
+				Name:     s.file.Name,
+				Flags:    s.file.Flags,
+				Modified: s.file.Modified,
+				Version:  s.file.Version,
+				Blocks:   s.availableBlocks(),
+			})
+		}
+	}
+	return files
+}
+
+// IsPulling returns true if the given file is currently being pulled.
+func (t *ProgressEmitter) IsPulling(folder, file string) bool {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	_, ok := t.registry[filepath.Join(folder, file)]
+	return ok
+}
+
 func (t *ProgressEmitter) String() string {
 	return fmt.Sprintf("ProgressEmitter@%p", t)
 }
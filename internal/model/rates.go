@@ -0,0 +1,123 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/syncthing/protocol"
+)
+
+const (
+	// Rates are calculated over at least this long, so that they don't jump
+	// around.
+	rateSampleInterval = time.Second
+	// The connections are sampled this often, independently of how often
+	// the rates are asked for.
+	rateUpdateInterval = 2 * time.Second
+)
+
+// A transferRate calculates the current transfer rates of a connection from
+// consecutive samples of its byte counters.
+type transferRate struct {
+	sample  protocol.Statistics
+	in, out float64 // bytes per second
+}
+
+func (r *transferRate) update(stats protocol.Statistics) {
+	if r.sample.At.IsZero() {
+		r.sample = stats
+		return
+	}
+
+	secs := stats.At.Sub(r.sample.At).Seconds()
+	if secs < rateSampleInterval.Seconds() {
+		return
+	}
+
+	r.in = float64(stats.InBytesTotal-r.sample.InBytesTotal) / secs
+	r.out = float64(stats.OutBytesTotal-r.sample.OutBytesTotal) / secs
+	if r.in < 0 {
+		r.in = 0
+	}
+	if r.out < 0 {
+		r.out = 0
+	}
+	r.sample = stats
+}
+
+// sampleRates updates the rates of all connections, and the total.
+func (m *Model) sampleRates() {
+	m.pmut.RLock()
+	samples := make(map[string]protocol.Statistics, len(m.protoConn)+1)
+	for device, conn := range m.protoConn {
+		samples[device.String()] = conn.Statistics()
+	}
+	m.pmut.RUnlock()
+
+	in, out := protocol.TotalInOut()
+	samples["total"] = protocol.Statistics{
+		At:            time.Now(),
+		InBytesTotal:  in,
+		OutBytesTotal: out,
+	}
+
+	m.ratesMut.Lock()
+	for key, stats := range samples {
+		r, ok := m.rates[key]
+		if !ok {
+			r = &transferRate{}
+			m.rates[key] = r
+		}
+		r.update(stats)
+	}
+	m.ratesMut.Unlock()
+}
+
+// rate returns the current incoming and outgoing rate, in bytes per second,
+// for the given key. Must be called with ratesMut held.
+func (m *Model) rate(key string) (in, out float64) {
+	if r, ok := m.rates[key]; ok {
+		return r.in, r.out
+	}
+	return 0, 0
+}
+
+// A rateSampler samples the connection rates every rateUpdateInterval.
+type rateSampler struct {
+	model *Model
+	stop  chan struct{}
+}
+
+func newRateSampler(m *Model) *rateSampler {
+	return &rateSampler{
+		model: m,
+		stop:  make(chan struct{}),
+	}
+}
+
+func (s *rateSampler) Serve() {
+	t := time.NewTicker(rateUpdateInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			s.model.sampleRates()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *rateSampler) Stop() {
+	close(s.stop)
+}
+
+func (s *rateSampler) String() string {
+	return fmt.Sprintf("rateSampler@%p", s)
+}
@@ -0,0 +1,45 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syncthing/protocol"
+)
+
+func TestTransferRate(t *testing.T) {
+	t0 := time.Now()
+	cases := []struct {
+		after   time.Duration
+		in, out int64
+		expIn   float64
+		expOut  float64
+	}{
+		// The first sample only sets the baseline.
+		{0, 1000, 1000, 0, 0},
+		// Too soon after the previous sample to change anything.
+		{500 * time.Millisecond, 5000, 1000, 0, 0},
+		{2 * time.Second, 5000, 3000, 2000, 1000},
+		{3 * time.Second, 5000, 4000, 0, 1000},
+		// Counters that go backwards don't result in negative rates.
+		{4 * time.Second, 0, 0, 0, 0},
+	}
+
+	var r transferRate
+	for i, tc := range cases {
+		r.update(protocol.Statistics{
+			At:            t0.Add(tc.after),
+			InBytesTotal:  tc.in,
+			OutBytesTotal: tc.out,
+		})
+		if r.in != tc.expIn || r.out != tc.expOut {
+			t.Errorf("%d: rates %v/%v, expected %v/%v", i, r.in, r.out, tc.expIn, tc.expOut)
+		}
+	}
+}
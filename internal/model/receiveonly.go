@@ -0,0 +1,156 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/db"
+	"github.com/syncthing/syncthing/internal/scanner"
+)
+
+// In a receive only folder, changes found by the scanner are recorded in the
+// local index with flagLocalChanged set. Such files keep the version of the
+// file they replaced and are marked invalid, so they don't take part in the
+// global version and are not served to other devices. They are never sent
+// to other devices at all. The puller leaves them alone until the cluster
+// has a newer version of the file, or until the changes are reverted.
+//
+// flagLocalChanged is a local flag only; it's outside of protocol.FlagsAll.
+const flagLocalChanged uint32 = 1 << 31
+
+// receiveOnlyFile returns the file as it should be recorded in the local
+// index of a receive only folder, given that the scanner found it changed.
+func receiveOnlyFile(fs *db.FileSet, f protocol.FileInfo) protocol.FileInfo {
+	f.Flags &^= protocol.FlagInvalid | flagLocalChanged
+
+	if gf, ok := fs.GetGlobal(f.Name); ok && sameContents(gf, f) {
+		// The file has been changed back into what the cluster has.
+		f.Version = gf.Version
+		return f
+	}
+
+	if f.IsDeleted() {
+		if _, ok := fs.GetGlobal(f.Name); !ok {
+			// A local addition that is gone again.
+			f.Flags |= protocol.FlagInvalid
+			return f
+		}
+	}
+
+	cf, _ := fs.Get(protocol.LocalDeviceID, f.Name)
+	f.Version = cf.Version
+	f.Flags |= protocol.FlagInvalid | flagLocalChanged
+	return f
+}
+
+// sameContents returns true if the two files have the same type, contents
+// and permissions.
+func sameContents(a, b protocol.FileInfo) bool {
+	const typeMask = protocol.FlagDeleted | protocol.FlagDirectory | protocol.FlagSymlink | protocol.SymlinkTypeMask
+	if a.Flags&typeMask != b.Flags&typeMask {
+		return false
+	}
+	if a.Flags&protocol.FlagNoPermBits == 0 && b.Flags&protocol.FlagNoPermBits == 0 && a.Flags&0777 != b.Flags&0777 {
+		return false
+	}
+	return a.IsDeleted() || scanner.BlocksEqual(a.Blocks, b.Blocks)
+}
+
+// isLocalChanged returns true if the local file has been changed in a
+// receive only folder.
+func isLocalChanged(f db.FileIntf) bool {
+	switch f := f.(type) {
+	case protocol.FileInfo:
+		return f.Flags&flagLocalChanged != 0
+	case db.FileInfoTruncated:
+		return f.Flags&flagLocalChanged != 0
+	}
+	return false
+}
+
+// LocalChangedFiles returns the files that have been changed locally in the
+// given receive only folder.
+func (m *Model) LocalChangedFiles(folder string) []db.FileInfoTruncated {
+	m.fmut.RLock()
+	fs, ok := m.folderFiles[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	files := []db.FileInfoTruncated{}
+	fs.WithHaveTruncated(protocol.LocalDeviceID, func(fi db.FileIntf) bool {
+		if isLocalChanged(fi) {
+			files = append(files, fi.(db.FileInfoTruncated))
+		}
+		return true
+	})
+	return files
+}
+
+// Revert discards the local changes in a receive only folder. Files and
+// directories that don't exist in the cluster are removed, everything else is
+// pulled again.
+func (m *Model) Revert(folder string) {
+	m.fmut.RLock()
+	fs, ok := m.folderFiles[folder]
+	folderCfg := m.folderCfgs[folder]
+	runner := m.folderRunners[folder]
+	m.fmut.RUnlock()
+	if !ok || runner == nil || !folderCfg.ReceiveOnly {
+		return
+	}
+
+	runner.setState(FolderScanning)
+
+	var batch []protocol.FileInfo
+	var added []string
+	fs.WithHave(protocol.LocalDeviceID, func(fi db.FileIntf) bool {
+		f := fi.(protocol.FileInfo)
+		if !isLocalChanged(f) {
+			return true
+		}
+
+		if _, ok := fs.GetGlobal(f.Name); ok {
+			// Still invalid, so that we need the global version, but no
+			// longer protected from being overwritten by it.
+			f.Flags &^= flagLocalChanged
+		} else {
+			added = append(added, f.Name)
+			f.Flags = protocol.FlagDeleted | protocol.FlagInvalid
+			f.Blocks = nil
+		}
+		f.LocalVersion = 0
+		batch = append(batch, f)
+		return true
+	})
+
+	// Remove the local additions, deepest first so that directories are
+	// empty when we get to them.
+	sort.Sort(sort.Reverse(sort.StringSlice(added)))
+	for _, name := range added {
+		if err := os.Remove(filepath.Join(folderCfg.Path(), name)); err != nil && !os.IsNotExist(err) {
+			l.Infof("Revert %q / %q: %v", folder, name, err)
+		}
+	}
+
+	for len(batch) > 0 {
+		n := len(batch)
+		if n > indexBatchSize {
+			n = indexBatchSize
+		}
+		m.updateLocals(folder, batch[:n])
+		batch = batch[n:]
+	}
+
+	runner.setState(FolderIdle)
+	runner.IndexUpdated()
+}
@@ -0,0 +1,90 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/scanner"
+)
+
+func TestReceiveOnlyLocalChanges(t *testing.T) {
+	m, dir := setupFolderModel(t, config.FolderConfiguration{ID: "ro", ReceiveOnly: true})
+	defer os.RemoveAll(dir)
+
+	write := func(name, data string, mtime time.Time) {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	write("a", "cluster data\n", then)
+
+	blocks, err := scanner.Blocks(bytes.NewReader([]byte("cluster data\n")), protocol.BlockSize, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	global := protocol.FileInfo{
+		Name:     "a",
+		Flags:    0644,
+		Modified: then.Unix(),
+		Version:  protocol.Vector{{ID: device1.Short(), Value: 1}},
+		Blocks:   blocks,
+	}
+
+	m.Index(device1, "ro", []protocol.FileInfo{global}, 0, nil)
+
+	// The file we have is the one in the cluster, so it's not a local change.
+	if err := m.ScanFolder("ro"); err != nil {
+		t.Fatal(err)
+	}
+	if files := m.LocalChangedFiles("ro"); len(files) != 0 {
+		t.Fatalf("Unexpected local changes %v", files)
+	}
+
+	write("a", "local data\n", then.Add(time.Minute))
+	write("b", "local addition\n", then)
+	if err := m.ScanFolder("ro"); err != nil {
+		t.Fatal(err)
+	}
+
+	files := m.LocalChangedFiles("ro")
+	if len(files) != 2 || files[0].Name != "a" || files[1].Name != "b" {
+		t.Fatalf("Incorrect local changes %v", files)
+	}
+	lf, _ := m.CurrentFolderFile("ro", "a")
+	if !lf.Version.Equal(global.Version) || !lf.IsInvalid() {
+		t.Errorf("Local change should keep cluster version and be invalid: %v", lf)
+	}
+	if gf, _ := m.CurrentGlobalFile("ro", "a"); !gf.Version.Equal(global.Version) || !scanner.BlocksEqual(gf.Blocks, blocks) {
+		t.Errorf("Local change leaked into global version: %v", gf)
+	}
+
+	m.Revert("ro")
+
+	if files := m.LocalChangedFiles("ro"); len(files) != 0 {
+		t.Errorf("Unexpected local changes after revert %v", files)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b")); !os.IsNotExist(err) {
+		t.Errorf("Local addition should be removed by revert, got %v", err)
+	}
+	if need, _ := m.NeedSize("ro"); need != 1 {
+		t.Errorf("Should need the cluster version after revert, need %d files", need)
+	}
+}
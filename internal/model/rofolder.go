@@ -73,6 +73,12 @@ func (s *roFolder) Serve() {
 			return
 
 		case <-s.timer.C:
+			if s.model.folderPaused(s.folder) {
+				s.setState(FolderPaused)
+				reschedule()
+				continue
+			}
+
 			if err := s.model.CheckFolderHealth(s.folder); err != nil {
 				l.Infoln("Skipping folder", s.folder, "scan due to folder error:", err)
 				reschedule()
@@ -87,6 +87,20 @@ type rwFolder struct {
 	pullers     int
 	shortID     uint64
 	order       config.PullOrder
+	receiveOnly bool
+
+	conflictPolicy config.ConflictPolicy
+	conflictWinner protocol.DeviceID
+	conflictName   string
+	maxConflicts   int
+
+	minDiskFree config.Size
+	diskFull    bool // pulling is stopped for lack of disk space
+
+	maxDeletes    int
+	maxDeletesPct int
+
+	nextRetry time.Time // when the first failed item that is backing off is due
 
 	stop        chan struct{}
 	queue       *jobQueue
@@ -97,11 +111,21 @@ type rwFolder struct {
 	scanNow     chan rescanRequest
 	remoteIndex chan struct{} // An index update was received, we should re-evaluate needs
 
-	errors    map[string]string // path -> error string
-	errorsMut sync.Mutex
+	errors      map[string]string // path -> error string
+	newFailures []fileError       // new errors, yet to be recorded as failures
+	errorsMut   sync.Mutex
 }
 
 func newRWFolder(m *Model, shortID uint64, cfg config.FolderConfiguration) *rwFolder {
+	var conflictWinner protocol.DeviceID
+	if cfg.ConflictPolicy == config.ConflictDeviceWins {
+		var err error
+		conflictWinner, err = protocol.DeviceIDFromString(cfg.ConflictWinner)
+		if err != nil {
+			l.Warnf("Folder %q: conflict winner: %v", cfg.ID, err)
+		}
+	}
+
 	return &rwFolder{
 		stateTracker: stateTracker{
 			folder: cfg.ID,
@@ -120,6 +144,17 @@ func newRWFolder(m *Model, shortID uint64, cfg config.FolderConfiguration) *rwFo
 		pullers:     cfg.Pullers,
 		shortID:     shortID,
 		order:       cfg.Order,
+		receiveOnly: cfg.ReceiveOnly,
+
+		conflictPolicy: cfg.ConflictPolicy,
+		conflictWinner: conflictWinner,
+		conflictName:   cfg.ConflictName,
+		maxConflicts:   cfg.MaxConflicts,
+
+		minDiskFree: cfg.MinDiskFree,
+
+		maxDeletes:    cfg.MaxDeletes,
+		maxDeletesPct: cfg.MaxDeletesPct,
 
 		stop:        make(chan struct{}),
 		queue:       newJobQueue(),
@@ -190,6 +225,12 @@ func (p *rwFolder) Serve() {
 			}
 
 		case <-p.pullTimer.C:
+			if p.model.folderPaused(p.folder) {
+				p.setState(FolderPaused)
+				p.pullTimer.Reset(nextPullIntv)
+				continue
+			}
+
 			if !initialScanCompleted {
 				if debug {
 					l.Debugln(p, "skip (initial)")
@@ -214,7 +255,7 @@ func (p *rwFolder) Serve() {
 
 			// RemoteLocalVersion() is a fast call, doesn't touch the database.
 			curVer, ok := p.model.RemoteLocalVersion(p.folder)
-			if !ok || curVer == prevVer {
+			if !ok || (curVer == prevVer && !p.retryDue()) {
 				if debug {
 					l.Debugln(p, "skip (curVer == prevVer)", prevVer, ok)
 				}
@@ -226,14 +267,30 @@ func (p *rwFolder) Serve() {
 				l.Debugln(p, "pulling", prevVer, curVer)
 			}
 
+			if err := p.checkDiskSpace(0); err != nil {
+				p.outOfDiskSpace(err)
+				continue
+			}
+			if p.diskFull {
+				l.Infof("Folder %q: enough free disk space, resuming pulling", p.folder)
+				p.diskFull = false
+			}
+
 			p.setState(FolderSyncing)
 			p.clearErrors()
 			tries := 0
+			var diskErr error
 
 			for {
+				if p.model.folderPaused(p.folder) {
+					p.pullTimer.Reset(nextPullIntv)
+					break
+				}
+
 				tries++
 
 				changed := p.pullerIteration(curIgnores)
+				p.recordFailures()
 				if debug {
 					l.Debugln(p, "changed", changed)
 				}
@@ -263,6 +320,12 @@ func (p *rwFolder) Serve() {
 					break
 				}
 
+				if diskErr = p.checkDiskSpace(0); diskErr != nil {
+					// prevVer is left alone, so that we resume pulling
+					// where we stopped.
+					break
+				}
+
 				if tries > 10 {
 					// We've tried a bunch of times to get in sync, but
 					// we're not making it. Probably there are write
@@ -284,12 +347,27 @@ func (p *rwFolder) Serve() {
 					break
 				}
 			}
-			p.setState(FolderIdle)
+			switch {
+			case diskErr != nil:
+				p.outOfDiskSpace(diskErr)
+			case p.model.folderPaused(p.folder):
+				p.setState(FolderPaused)
+			case p.model.deletionsPending(p.folder):
+				p.setState(FolderDeletionsPending)
+			default:
+				p.setState(FolderIdle)
+			}
 
 		// The reason for running the scanner from within the puller is that
 		// this is the easiest way to make sure we are not doing both at the
 		// same time.
 		case <-p.scanTimer.C:
+			if p.model.folderPaused(p.folder) {
+				p.setState(FolderPaused)
+				rescheduleScan()
+				continue
+			}
+
 			if err := p.model.CheckFolderHealth(p.folder); err != nil {
 				l.Infoln("Skipping folder", p.folder, "scan due to folder error:", err)
 				rescheduleScan()
@@ -389,8 +467,13 @@ func (p *rwFolder) pullerIteration(ignores *ignore.Matcher) int {
 	pullWg := sync.NewWaitGroup()
 	doneWg := sync.NewWaitGroup()
 
+	pullers := p.pullers
+	if n := p.model.ScheduledSettings().Pullers; n > 0 {
+		pullers = n
+	}
+
 	if debug {
-		l.Debugln(p, "c", p.copiers, "p", p.pullers)
+		l.Debugln(p, "c", p.copiers, "p", pullers)
 	}
 
 	p.dbUpdates = make(chan dbUpdateJob)
@@ -410,7 +493,7 @@ func (p *rwFolder) pullerIteration(ignores *ignore.Matcher) int {
 		}()
 	}
 
-	for i := 0; i < p.pullers; i++ {
+	for i := 0; i < pullers; i++ {
 		pullWg.Add(1)
 		go func() {
 			// pullerRoutine finishes when pullChan is closed
@@ -438,6 +521,12 @@ func (p *rwFolder) pullerIteration(ignores *ignore.Matcher) int {
 
 	changed := 0
 
+	// Failed items are left alone while backing off.
+	failures := p.failures()
+	stillNeeded := make(map[string]bool, len(failures))
+	now := time.Now()
+	p.nextRetry = time.Time{}
+
 	fileDeletions := map[string]protocol.FileInfo{}
 	dirDeletions := []protocol.FileInfo{}
 	buckets := map[string][]protocol.FileInfo{}
@@ -449,11 +538,17 @@ func (p *rwFolder) pullerIteration(ignores *ignore.Matcher) int {
 
 		file := intf.(protocol.FileInfo)
 
-		if ignores.Match(file.Name) {
-			// This is an ignored file. Skip it, continue iteration.
+		if p.skipNeeded(ignores, file) {
 			return true
 		}
 
+		if f, ok := failures[file.Name]; ok {
+			stillNeeded[file.Name] = true
+			if p.backingOff(f, file, now) {
+				return true
+			}
+		}
+
 		if debug {
 			l.Debugln(p, "handling", file.Name)
 		}
@@ -470,10 +565,8 @@ func (p *rwFolder) pullerIteration(ignores *ignore.Matcher) int {
 				// number, hence the deletion coming in again as part of
 				// WithNeed, furthermore, the file can simply be of the wrong
 				// type if we haven't yet managed to pull it.
-				if ok && !df.IsDeleted() && !df.IsSymlink() && !df.IsDirectory() {
-					// Put files into buckets per first hash
-					key := string(df.Blocks[0].Hash)
-					buckets[key] = append(buckets[key], df)
+				if ok {
+					addRenameCandidate(buckets, df)
 				}
 			}
 		case file.IsDirectory() && !file.IsSymlink():
@@ -492,6 +585,10 @@ func (p *rwFolder) pullerIteration(ignores *ignore.Matcher) int {
 		return true
 	})
 
+	// Too many deletions are held back until approved. Files that turn out
+	// to have been renamed are not lost, so they are renamed regardless.
+	held := p.heldDeletions(fileDeletions, dirDeletions)
+
 	// Reorder the file queue according to configuration
 
 	switch p.order {
@@ -529,28 +626,32 @@ nextFile:
 		// number, hence the deletion coming in again as part of
 		// WithNeed, furthermore, the file can simply be of the wrong type if
 		// the global index changed while we were processing this iteration.
-		if !f.IsDeleted() && !f.IsSymlink() && !f.IsDirectory() {
-			key := string(f.Blocks[0].Hash)
-			for i, candidate := range buckets[key] {
-				if scanner.BlocksEqual(candidate.Blocks, f.Blocks) {
-					// Remove the candidate from the bucket
-					lidx := len(buckets[key]) - 1
-					buckets[key][i] = buckets[key][lidx]
-					buckets[key] = buckets[key][:lidx]
-
-					// candidate is our current state of the file, where as the
-					// desired state with the delete bit set is in the deletion
-					// map.
-					desired := fileDeletions[candidate.Name]
-					// Remove the pending deletion (as we perform it by renaming)
-					delete(fileDeletions, candidate.Name)
-
-					p.renameFile(desired, f)
-
-					p.queue.Done(fileName)
-					continue nextFile
+		if candidate, ok := takeRenameCandidate(buckets, f); ok {
+			// candidate is our current state of the file, where as the
+			// desired state with the delete bit set is in the deletion
+			// map.
+			desired := fileDeletions[candidate.Name]
+			// Remove the pending deletion (as we perform it by renaming)
+			delete(fileDeletions, candidate.Name)
+
+			p.renameFile(desired, f)
+
+			p.queue.Done(fileName)
+			continue nextFile
+		}
+
+		if err := p.checkDiskSpace(p.neededSpace(f)); err != nil {
+			// Don't start on any more files. The remaining ones are picked
+			// up again once there is space.
+			p.queue.Done(fileName)
+			for {
+				fileName, ok := p.queue.Pop()
+				if !ok {
+					break
 				}
+				p.queue.Done(fileName)
 			}
+			break
 		}
 
 		// Not a rename or a symlink, deal with it.
@@ -571,6 +672,10 @@ nextFile:
 	doneWg.Wait()
 
 	for _, file := range fileDeletions {
+		if held[file.Name] {
+			changed--
+			continue
+		}
 		if debug {
 			l.Debugln("Deleting file", file.Name)
 		}
@@ -579,6 +684,10 @@ nextFile:
 
 	for i := range dirDeletions {
 		dir := dirDeletions[len(dirDeletions)-i-1]
+		if held[dir.Name] {
+			changed--
+			continue
+		}
 		if debug {
 			l.Debugln("Deleting dir", dir.Name)
 		}
@@ -589,9 +698,61 @@ nextFile:
 	close(p.dbUpdates)
 	updateWg.Wait()
 
+	p.forgetFailures(failures, stillNeeded)
+
 	return changed
 }
 
+// skipNeeded returns true if the needed file should be left alone by the
+// puller.
+func (p *rwFolder) skipNeeded(ignores *ignore.Matcher, file protocol.FileInfo) bool {
+	if ignores.Match(file.Name) {
+		// This is an ignored file.
+		return true
+	}
+
+	if p.receiveOnly {
+		if cf, ok := p.model.CurrentFolderFile(p.folder, file.Name); ok && isLocalChanged(cf) && cf.Version.GreaterEqual(file.Version) {
+			// Changed locally and not yet reverted; there is nothing
+			// newer in the cluster to replace it with.
+			return true
+		}
+	}
+
+	return false
+}
+
+// addRenameCandidate remembers the current state of a file that is to be
+// deleted, in case the deletion turns out to be half of a rename.
+func addRenameCandidate(buckets map[string][]protocol.FileInfo, cur protocol.FileInfo) {
+	if cur.IsDeleted() || cur.IsSymlink() || cur.IsDirectory() || len(cur.Blocks) == 0 {
+		return
+	}
+	// Put files into buckets per first hash
+	key := string(cur.Blocks[0].Hash)
+	buckets[key] = append(buckets[key], cur)
+}
+
+// takeRenameCandidate returns and forgets the file to be deleted that has
+// the same contents as the given file, if there is one. The file can then be
+// renamed instead of being pulled.
+func takeRenameCandidate(buckets map[string][]protocol.FileInfo, f protocol.FileInfo) (protocol.FileInfo, bool) {
+	if f.IsDeleted() || f.IsSymlink() || f.IsDirectory() || len(f.Blocks) == 0 {
+		return protocol.FileInfo{}, false
+	}
+	key := string(f.Blocks[0].Hash)
+	for i, candidate := range buckets[key] {
+		if scanner.BlocksEqual(candidate.Blocks, f.Blocks) {
+			// Remove the candidate from the bucket
+			lidx := len(buckets[key]) - 1
+			buckets[key][i] = buckets[key][lidx]
+			buckets[key] = buckets[key][:lidx]
+			return candidate, true
+		}
+	}
+	return protocol.FileInfo{}, false
+}
+
 // handleDir creates or updates the given directory
 func (p *rwFolder) handleDir(file protocol.FileInfo) {
 	var err error
@@ -755,16 +916,28 @@ func (p *rwFolder) deleteFile(file protocol.FileInfo) {
 
 	realName := filepath.Join(p.dir, file.Name)
 
+	moved := false
 	cur, ok := p.model.CurrentFolderFile(p.folder, file.Name)
 	if ok && p.inConflict(cur.Version, file.Version) {
-		// There is a conflict here. Move the file to a conflict copy instead
-		// of deleting. Also merge with the version vector we had, to indicate
-		// we have resolved the conflict.
+		// There is a conflict here, resolve it according to the folder's
+		// policy. Unless the deletion wins the file is moved to a conflict
+		// copy instead of deleting. Also merge with the version vector we
+		// had, to indicate we have resolved the conflict.
+		resolution := p.resolveConflict(cur, file)
+		if resolution == conflictLocalWins {
+			p.keepLocal(cur, file)
+			return
+		}
+		moved, err = p.conflictCopy(realName, cur.Version, file, resolution)
 		file.Version = file.Version.Merge(cur.Version)
-		err = osutil.InWritableDir(moveForConflict, realName)
-	} else if p.versioner != nil {
+	}
+
+	switch {
+	case moved:
+		// Moved to a conflict copy instead.
+	case p.versioner != nil:
 		err = osutil.InWritableDir(p.versioner.Archive, realName)
-	} else {
+	default:
 		err = osutil.InWritableDir(osutil.Remove, realName)
 	}
 
@@ -944,6 +1117,17 @@ func (p *rwFolder) handleFile(file protocol.FileInfo, copyChan chan<- copyBlocks
 		return
 	}
 
+	resolution := conflictKeepBoth
+	if ok && p.inConflict(curFile.Version, file.Version) {
+		resolution = p.resolveConflict(curFile, file)
+		if resolution == conflictLocalWins {
+			// No need to pull anything, our file stays as it is.
+			p.queue.Done(file.Name)
+			p.keepLocal(curFile, file)
+			return
+		}
+	}
+
 	events.Default.Log(events.ItemStarted, map[string]string{
 		"folder": p.folder,
 		"item":   file.Name,
@@ -959,11 +1143,33 @@ func (p *rwFolder) handleFile(file protocol.FileInfo, copyChan chan<- copyBlocks
 
 	reused := 0
 	var blocks []protocol.BlockInfo
+	pullStates := db.NewPullStateRepo(p.model.db, p.folder)
+	available, resumable := pullStates.Get(file)
+	if resumable {
+		if info, err := os.Lstat(tempName); err != nil || !info.Mode().IsRegular() {
+			resumable = false
+		}
+	}
 
 	// Check for an old temporary file which might have some blocks we could
-	// reuse.
-	tempBlocks, err := scanner.HashFile(tempName, protocol.BlockSize)
-	if err == nil {
+	// reuse. If we've recorded which of its blocks are good we can resume
+	// right away, otherwise we hash it to find out.
+	var tempBlocks []protocol.BlockInfo
+	var err error
+	if resumable {
+		for i, block := range file.Blocks {
+			if !available[i] {
+				blocks = append(blocks, block)
+			}
+		}
+		reused = len(file.Blocks) - len(blocks)
+		if debug {
+			l.Debugf("%v resuming %s with %d blocks available", p, file.Name, reused)
+		}
+		if reused == 0 {
+			os.Remove(tempName)
+		}
+	} else if tempBlocks, err = scanner.HashFile(tempName, scanner.BlockSizeOf(file.Blocks)); err == nil {
 		// Check for any reusable blocks in the temp file
 		tempCopyBlocks, _ := scanner.BlockDiff(tempBlocks, file.Blocks)
 
@@ -974,10 +1180,13 @@ func (p *rwFolder) handleFile(file protocol.FileInfo, copyChan chan<- copyBlocks
 		}
 
 		// Since the blocks are already there, we don't need to get them.
-		for _, block := range file.Blocks {
+		available = make([]bool, len(file.Blocks))
+		for i, block := range file.Blocks {
 			_, ok := existingBlocks[block.String()]
 			if !ok {
 				blocks = append(blocks, block)
+			} else {
+				available[i] = true
 			}
 		}
 
@@ -993,6 +1202,9 @@ func (p *rwFolder) handleFile(file protocol.FileInfo, copyChan chan<- copyBlocks
 	} else {
 		blocks = file.Blocks
 	}
+	if len(available) != len(file.Blocks) || reused == 0 {
+		available = make([]bool, len(file.Blocks))
+	}
 
 	s := sharedPullerState{
 		file:        file,
@@ -1004,6 +1216,10 @@ func (p *rwFolder) handleFile(file protocol.FileInfo, copyChan chan<- copyBlocks
 		reused:      reused,
 		ignorePerms: p.ignorePermissions(file),
 		version:     curFile.Version,
+		resolution:  resolution,
+		pullStates:  pullStates,
+		available:   available,
+		checkpoint:  time.Now(),
 		mut:         sync.NewMutex(),
 	}
 
@@ -1065,7 +1281,7 @@ func (p *rwFolder) shortcutSymlink(file protocol.FileInfo) (err error) {
 // copierRoutine reads copierStates until the in channel closes and performs
 // the relevant copies when possible, or passes it to the puller routine.
 func (p *rwFolder) copierRoutine(in <-chan copyBlocksState, pullChan chan<- pullBlockState, out chan<- *sharedPullerState) {
-	buf := make([]byte, protocol.BlockSize)
+	var buf []byte
 
 	for state := range in {
 		dstFd, err := state.tempFile()
@@ -1086,15 +1302,23 @@ func (p *rwFolder) copierRoutine(in <-chan copyBlocksState, pullChan chan<- pull
 		}
 		p.model.fmut.RUnlock()
 
-		for _, block := range state.blocks {
+		// The shifted block finder is set up when we first miss a block, as
+		// it requires a pass over the whole old file.
+		var shifted *shiftedBlockFinder
+		var searchedShifted bool
+
+		for i, block := range state.blocks {
+			if cap(buf) < int(block.Size) {
+				buf = make([]byte, block.Size)
+			}
 			buf = buf[:int(block.Size)]
-			found := p.model.finder.Iterate(block.Hash, func(folder, file string, index int32) bool {
+			found := p.model.finder.Iterate(block.Hash, func(folder, file string, index int32, blockSize int) bool {
 				fd, err := os.Open(filepath.Join(folderRoots[folder], file))
 				if err != nil {
 					return false
 				}
 
-				_, err = fd.ReadAt(buf, protocol.BlockSize*int64(index))
+				_, err = fd.ReadAt(buf, int64(blockSize)*int64(index))
 				fd.Close()
 				if err != nil {
 					return false
@@ -1130,6 +1354,21 @@ func (p *rwFolder) copierRoutine(in <-chan copyBlocksState, pullChan chan<- pull
 				break
 			}
 
+			if !found {
+				if !searchedShifted {
+					shifted = p.newShiftedBlockFinder(state, state.blocks[i:])
+					searchedShifted = true
+				}
+				found, err = shifted.copy(block, buf, dstFd)
+				if err != nil {
+					state.fail("dst write", err)
+					break
+				}
+				if found {
+					state.copiedFromOrigin()
+				}
+			}
+
 			if !found {
 				state.pullStarted()
 				ps := pullBlockState{
@@ -1138,9 +1377,10 @@ func (p *rwFolder) copierRoutine(in <-chan copyBlocksState, pullChan chan<- pull
 				}
 				pullChan <- ps
 			} else {
-				state.copyDone()
+				state.copyDone(block)
 			}
 		}
+		shifted.close()
 		out <- state.sharedPullerState
 	}
 }
@@ -1162,12 +1402,13 @@ func (p *rwFolder) pullerRoutine(in <-chan pullBlockState, out chan<- *sharedPul
 		}
 
 		var lastError error
-		potentialDevices := p.model.Availability(p.folder, state.file.Name)
+		potentialDevices, temporary := p.blockAvailability(state.file, state.block)
 		for {
-			// Select the least busy device to pull the block from. If we found no
+			// Select the least busy device with room in its request window to
+			// pull the block from, waiting for one if necessary. If we found no
 			// feasible device at all, fail the block (and in the long run, the
 			// file).
-			selected := activity.leastBusy(potentialDevices)
+			selected := activity.acquire(potentialDevices)
 			if selected == (protocol.DeviceID{}) {
 				if lastError != nil {
 					state.fail("pull", lastError)
@@ -1179,11 +1420,18 @@ func (p *rwFolder) pullerRoutine(in <-chan pullBlockState, out chan<- *sharedPul
 
 			potentialDevices = removeDevice(potentialDevices, selected)
 
-			// Fetch the block, while marking the selected device as in use so that
-			// leastBusy can select another device when someone else asks.
-			activity.using(selected)
-			buf, lastError := p.model.requestGlobal(selected, p.folder, state.file.Name, state.block.Offset, int(state.block.Size), state.block.Hash, 0, nil)
+			var flags uint32
+			if temporary[selected] {
+				flags = protocol.FlagRequestTemporary
+			}
+
+			// Fetch the block, while the selected device is marked as in use so
+			// that other pullers select another device when it's busy.
+			buf, lastError := p.model.requestGlobal(selected, p.folder, state.file.Name, state.block.Offset, int(state.block.Size), state.block.Hash, flags, nil)
 			activity.done(selected)
+			if lastError == nil {
+				activity.transferred(selected, len(buf))
+			}
 			if lastError != nil {
 				if debug {
 					l.Debugln("request:", p.folder, state.file.Name, state.block.Offset, state.block.Size, "returned error:", lastError)
@@ -1206,7 +1454,7 @@ func (p *rwFolder) pullerRoutine(in <-chan pullBlockState, out chan<- *sharedPul
 			if err != nil {
 				state.fail("save", err)
 			} else {
-				state.pullDone()
+				state.pullDone(state.block)
 			}
 			break
 		}
@@ -1214,6 +1462,29 @@ func (p *rwFolder) pullerRoutine(in <-chan pullBlockState, out chan<- *sharedPul
 	}
 }
 
+// blockAvailability returns the devices the block can be pulled from. These
+// are the devices that have the file, followed by those that only have the
+// block in the temporary file of the same version, which are marked in the
+// returned map.
+func (p *rwFolder) blockAvailability(file protocol.FileInfo, block protocol.BlockInfo) ([]protocol.DeviceID, map[protocol.DeviceID]bool) {
+	devices := p.model.Availability(p.folder, file.Name)
+	var temporary map[protocol.DeviceID]bool
+nextDevice:
+	for _, device := range p.model.TemporaryAvailability(p.folder, file, block.Hash) {
+		for _, have := range devices {
+			if have == device {
+				continue nextDevice
+			}
+		}
+		if temporary == nil {
+			temporary = make(map[protocol.DeviceID]bool)
+		}
+		temporary[device] = true
+		devices = append(devices, device)
+	}
+	return devices, temporary
+}
+
 func (p *rwFolder) performFinish(state *sharedPullerState) error {
 	// Set the correct permission bits on the new file
 	if !p.ignorePermissions(state.file) {
@@ -1234,20 +1505,21 @@ func (p *rwFolder) performFinish(state *sharedPullerState) error {
 	}
 
 	var err error
+	moved := false
 	if p.inConflict(state.version, state.file.Version) {
-		// The new file has been changed in conflict with the existing one. We
-		// should file it away as a conflict instead of just removing or
-		// archiving. Also merge with the version vector we had, to indicate
-		// we have resolved the conflict.
+		// The new file has been changed in conflict with the existing one.
+		// Unless the new file wins outright, we should file the existing one
+		// away as a conflict instead of just removing or archiving. Also
+		// merge with the version vector we had, to indicate we have resolved
+		// the conflict.
+		moved, err = p.conflictCopy(state.realName, state.version, state.file, state.resolution)
 		state.file.Version = state.file.Version.Merge(state.version)
-		err = osutil.InWritableDir(moveForConflict, state.realName)
-	} else if p.versioner != nil {
+	}
+	if err == nil && !moved && p.versioner != nil {
 		// If we should use versioning, let the versioner archive the old
 		// file before we replace it. Archiving a non-existent file is not
 		// an error.
 		err = p.versioner.Archive(state.realName)
-	} else {
-		err = nil
 	}
 	if err != nil {
 		return err
@@ -1302,6 +1574,8 @@ func (p *rwFolder) finisherRoutine(in <-chan *sharedPullerState) {
 			if err != nil {
 				l.Infoln("Puller: final:", err)
 				p.newError(state.file.Name, err)
+			} else if state.pullStates != nil {
+				state.pullStates.Delete(state.file.Name)
 			}
 			events.Default.Log(events.ItemFinished, map[string]interface{}{
 				"folder": p.folder,
@@ -1435,21 +1709,6 @@ func removeDevice(devices []protocol.DeviceID, device protocol.DeviceID) []proto
 	return devices
 }
 
-func moveForConflict(name string) error {
-	ext := filepath.Ext(name)
-	withoutExt := name[:len(name)-len(ext)]
-	newName := withoutExt + time.Now().Format(".sync-conflict-20060102-150405") + ext
-	err := os.Rename(name, newName)
-	if os.IsNotExist(err) {
-		// We were supposed to move a file away but it does not exist. Either
-		// the user has already moved it away, or the conflict was between a
-		// remote modification and a local delete. In either way it does not
-		// matter, go ahead as if the move succeeded.
-		return nil
-	}
-	return err
-}
-
 func (p *rwFolder) newError(path string, err error) {
 	p.errorsMut.Lock()
 	defer p.errorsMut.Unlock()
@@ -1462,6 +1721,21 @@ func (p *rwFolder) newError(path string, err error) {
 	}
 
 	p.errors[path] = err.Error()
+	p.newFailures = append(p.newFailures, fileError{path, err.Error()})
+}
+
+// recordFailures persists the errors since the last call as failures. It's
+// called between puller iterations, to keep the database out of the way of
+// the pullers.
+func (p *rwFolder) recordFailures() {
+	p.errorsMut.Lock()
+	failures := p.newFailures
+	p.newFailures = nil
+	p.errorsMut.Unlock()
+
+	for _, f := range failures {
+		p.recordFailure(f.Path, f.Err)
+	}
 }
 
 func (p *rwFolder) clearErrors() {
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/db"
 	"github.com/syncthing/syncthing/internal/scanner"
 	"github.com/syncthing/syncthing/internal/sync"
 
@@ -155,6 +156,52 @@ func TestHandleFileWithTemp(t *testing.T) {
 	}
 }
 
+func TestHandleFileResume(t *testing.T) {
+	// The recorded pull state says blocks 1 and 3 are already in the temp
+	// file, so we should trust that without hashing it and
+	// Copy: 2, 4, 5, 6, 7, 8
+
+	requiredFile := protocol.FileInfo{
+		Name:   "file",
+		Blocks: blocks[1:],
+	}
+
+	ldb, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel(defaultConfig, protocol.LocalDeviceID, "device", "syncthing", "dev", ldb)
+	m.AddFolder(defaultFolderConfig)
+
+	available := make([]bool, len(requiredFile.Blocks))
+	available[0] = true
+	available[2] = true
+	db.NewPullStateRepo(ldb, "default").Put(requiredFile, available)
+
+	p := rwFolder{
+		folder:    "default",
+		dir:       "testdata",
+		model:     m,
+		errors:    make(map[string]string),
+		errorsMut: sync.NewMutex(),
+	}
+
+	copyChan := make(chan copyBlocksState, 1)
+
+	p.handleFile(requiredFile, copyChan, nil)
+
+	toCopy := <-copyChan
+
+	if toCopy.reused != 2 {
+		t.Errorf("Unexpected count of reused blocks: %d != 2", toCopy.reused)
+	}
+	if len(toCopy.blocks) != 6 {
+		t.Fatalf("Unexpected count of copy blocks: %d != 6", len(toCopy.blocks))
+	}
+	for i, eq := range []int{2, 4, 5, 6, 7, 8} {
+		if string(toCopy.blocks[i].Hash) != string(blocks[eq].Hash) {
+			t.Errorf("Block mismatch: %s != %s", toCopy.blocks[i].String(), blocks[eq].String())
+		}
+	}
+}
+
 func TestCopierFinder(t *testing.T) {
 	// After diff between required and existing we should:
 	// Copy: 1, 2, 3, 4, 6, 7, 8
@@ -191,7 +238,7 @@ func TestCopierFinder(t *testing.T) {
 	// Update index
 	m.updateLocals("default", []protocol.FileInfo{existingFile})
 
-	iterFn := func(folder, file string, index int32) bool {
+	iterFn := func(folder, file string, index int32, blockSize int) bool {
 		return true
 	}
 
@@ -258,7 +305,7 @@ func TestCopierFinder(t *testing.T) {
 
 // Test that updating a file removes it's old blocks from the blockmap
 func TestCopierCleanup(t *testing.T) {
-	iterFn := func(folder, file string, index int32) bool {
+	iterFn := func(folder, file string, index int32, blockSize int) bool {
 		return true
 	}
 
@@ -329,7 +376,7 @@ func TestLastResortPulling(t *testing.T) {
 	// with a different name (causing to copy that particular block)
 	file.Name = "newfile"
 
-	iterFn := func(folder, file string, index int32) bool {
+	iterFn := func(folder, file string, index int32, blockSize int) bool {
 		return true
 	}
 
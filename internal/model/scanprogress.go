@@ -0,0 +1,111 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"time"
+
+	"github.com/syncthing/syncthing/internal/events"
+	"github.com/syncthing/syncthing/internal/scanner"
+)
+
+// FolderScanProgress events are emitted this often during a scan.
+var scanProgressInterval = 2 * time.Second
+
+// FolderScanProgress is the progress of a scan in progress, with the rate of
+// hashing so far and the estimated time left to hash what's been discovered.
+type FolderScanProgress struct {
+	scanner.ScanProgress
+	Started time.Time `json:"started"`
+	Rate    float64   `json:"rate"` // bytes hashed per second
+	ETA     float64   `json:"eta"`  // seconds, or -1 when unknown
+}
+
+type scanProgress struct {
+	counter *scanner.ProgressCounter
+	started time.Time
+	stop    chan struct{}
+}
+
+func (s *scanProgress) current() FolderScanProgress {
+	p := FolderScanProgress{
+		ScanProgress: s.counter.Progress(),
+		Started:      s.started,
+		ETA:          -1,
+	}
+	if secs := time.Since(s.started).Seconds(); secs > 0 {
+		p.Rate = float64(p.BytesHashed) / secs
+	}
+	if p.Rate > 0 {
+		left := p.BytesDiscovered - p.BytesHashed
+		if left < 0 {
+			left = 0
+		}
+		p.ETA = float64(left) / p.Rate
+	}
+	return p
+}
+
+// startScanProgress registers the counter of a scan of the folder, whose
+// progress is emitted as events until stopScanProgress is called.
+func (m *Model) startScanProgress(folder string, counter *scanner.ProgressCounter) *scanProgress {
+	s := &scanProgress{
+		counter: counter,
+		started: time.Now(),
+		stop:    make(chan struct{}),
+	}
+
+	m.scanMut.Lock()
+	m.scans[folder] = s
+	m.scanMut.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(scanProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				p := s.current()
+				events.Default.Log(events.FolderScanProgress, map[string]interface{}{
+					"folder":          folder,
+					"filesDiscovered": p.FilesDiscovered,
+					"bytesDiscovered": p.BytesDiscovered,
+					"filesHashed":     p.FilesHashed,
+					"bytesHashed":     p.BytesHashed,
+					"rate":            p.Rate,
+					"eta":             p.ETA,
+				})
+			}
+		}
+	}()
+
+	return s
+}
+
+func (m *Model) stopScanProgress(folder string, s *scanProgress) {
+	close(s.stop)
+
+	m.scanMut.Lock()
+	if m.scans[folder] == s {
+		delete(m.scans, folder)
+	}
+	m.scanMut.Unlock()
+}
+
+// ScanProgress returns the progress of the current scan of the folder, if
+// any.
+func (m *Model) ScanProgress(folder string) (FolderScanProgress, bool) {
+	m.scanMut.Lock()
+	s, ok := m.scans[folder]
+	m.scanMut.Unlock()
+	if !ok {
+		return FolderScanProgress{}, false
+	}
+	return s.current(), true
+}
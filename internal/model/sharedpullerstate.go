@@ -10,12 +10,18 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/syncthing/protocol"
 	"github.com/syncthing/syncthing/internal/db"
+	"github.com/syncthing/syncthing/internal/scanner"
 	"github.com/syncthing/syncthing/internal/sync"
 )
 
+// The set of available blocks in a temp file is recorded at most this often
+// while the file is being pulled.
+const pullStateInterval = 10 * time.Second
+
 // A sharedPullerState is kept for each file that is being synced and is kept
 // updated along the way.
 type sharedPullerState struct {
@@ -26,7 +32,9 @@ type sharedPullerState struct {
 	realName    string
 	reused      int // Number of blocks reused from temporary file
 	ignorePerms bool
-	version     protocol.Vector // The current (old) version
+	version     protocol.Vector    // The current (old) version
+	resolution  conflictResolution // How to resolve a conflict with the current version, if any
+	pullStates  *db.PullStateRepo  // If not nil, records available blocks so that we can resume
 
 	// Mutable, must be locked for access
 	err        error      // The first error we hit
@@ -37,6 +45,8 @@ type sharedPullerState struct {
 	copyNeeded int        // Number of copy actions still pending
 	pullNeeded int        // Number of block pulls still pending
 	closed     bool       // True if the file has been finalClosed.
+	available  []bool     // Which blocks, by index, are in the temp file
+	checkpoint time.Time  // When available was last recorded in pullStates
 	mut        sync.Mutex // Protects the above
 }
 
@@ -174,8 +184,9 @@ func (s *sharedPullerState) failed() error {
 	return s.err
 }
 
-func (s *sharedPullerState) copyDone() {
+func (s *sharedPullerState) copyDone(block protocol.BlockInfo) {
 	s.mut.Lock()
+	s.blockAvailableLocked(block)
 	s.copyNeeded--
 	if debug {
 		l.Debugln("sharedPullerState", s.folder, s.file.Name, "copyNeeded ->", s.copyNeeded)
@@ -201,8 +212,9 @@ func (s *sharedPullerState) pullStarted() {
 	s.mut.Unlock()
 }
 
-func (s *sharedPullerState) pullDone() {
+func (s *sharedPullerState) pullDone(block protocol.BlockInfo) {
 	s.mut.Lock()
+	s.blockAvailableLocked(block)
 	s.pullNeeded--
 	if debug {
 		l.Debugln("sharedPullerState", s.folder, s.file.Name, "pullNeeded done ->", s.pullNeeded)
@@ -230,6 +242,10 @@ func (s *sharedPullerState) finalClose() (bool, error) {
 	}
 
 	if s.fd != nil {
+		if s.err != nil {
+			// Record how far we got, so that the next attempt can resume.
+			s.checkpointLocked()
+		}
 		if closeErr := s.fd.Close(); closeErr != nil && s.err == nil {
 			// This is our error if we weren't errored before. Otherwise we
 			// keep the earlier error.
@@ -243,12 +259,62 @@ func (s *sharedPullerState) finalClose() (bool, error) {
 	return true, s.err
 }
 
+// blockAvailableLocked marks the block as written to the temp file. The set
+// of available blocks is recorded at regular intervals.
+func (s *sharedPullerState) blockAvailableLocked(block protocol.BlockInfo) {
+	if len(s.available) == 0 {
+		return
+	}
+
+	index := int(block.Offset / int64(scanner.BlockSizeOf(s.file.Blocks)))
+	if index >= len(s.available) {
+		return
+	}
+	s.available[index] = true
+
+	if time.Since(s.checkpoint) > pullStateInterval {
+		s.checkpointLocked()
+	}
+}
+
+// availableBlocks returns the blocks of the new file that have been written
+// to the temp file.
+func (s *sharedPullerState) availableBlocks() []protocol.BlockInfo {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	var blocks []protocol.BlockInfo
+	for i, ok := range s.available {
+		if ok && i < len(s.file.Blocks) {
+			blocks = append(blocks, s.file.Blocks[i])
+		}
+	}
+	return blocks
+}
+
+// checkpointLocked syncs the temp file to disk and records which blocks are
+// available in it. The sync makes sure we never record a block as available
+// that could be lost in a crash.
+func (s *sharedPullerState) checkpointLocked() {
+	if s.pullStates == nil || s.fd == nil {
+		return
+	}
+	if err := s.fd.Sync(); err != nil {
+		if debug {
+			l.Debugln("sharedPullerState", s.folder, s.file.Name, "sync:", err)
+		}
+		return
+	}
+	s.pullStates.Put(s.file, s.available)
+	s.checkpoint = time.Now()
+}
+
 // Returns the momentarily progress for the puller
 func (s *sharedPullerState) Progress() *pullerProgress {
 	s.mut.Lock()
 	defer s.mut.Unlock()
 	total := s.reused + s.copyTotal + s.pullTotal
 	done := total - s.copyNeeded - s.pullNeeded
+	blockSize := scanner.BlockSizeOf(s.file.Blocks)
 	return &pullerProgress{
 		Total:               total,
 		Reused:              s.reused,
@@ -256,7 +322,7 @@ func (s *sharedPullerState) Progress() *pullerProgress {
 		CopiedFromElsewhere: s.copyTotal - s.copyNeeded - s.copyOrigin,
 		Pulled:              s.pullTotal - s.pullNeeded,
 		Pulling:             s.pullNeeded,
-		BytesTotal:          db.BlocksToSize(total),
-		BytesDone:           db.BlocksToSize(done),
+		BytesTotal:          db.BlocksToSize(total, blockSize),
+		BytesDone:           db.BlocksToSize(done, blockSize),
 	}
 }
@@ -0,0 +1,267 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/scanner"
+)
+
+// Devices that support featureTempIndexes tell each other which blocks they
+// already have of the files they are pulling, so that a file can be pulled
+// from every device that is downloading it at the same time, and not only
+// from those that have it in full.
+
+// Temporary indexes are sent at most this often.
+const tempIndexInterval = 5 * time.Second
+
+// A tempFile is the set of blocks a device has in the temporary file of a
+// given version of a file.
+type tempFile struct {
+	version protocol.Vector
+	blocks  map[string]struct{} // block hash -> present
+}
+
+// tempIndexUpdate records the blocks the device has in its temporary files. A
+// file without blocks is no longer being pulled.
+func (m *Model) tempIndexUpdate(deviceID protocol.DeviceID, folder string, fs []protocol.FileInfo) {
+	if debug {
+		l.Debugf("%v TMPIDX(in): %s / %q: %d files", m, deviceID, folder, len(fs))
+	}
+
+	if !m.folderSharedWith(folder, deviceID) {
+		return
+	}
+	if _, ok := m.encryptedDevice(folder, deviceID); ok {
+		// Encrypted devices only have encrypted files to offer.
+		return
+	}
+
+	m.pmut.Lock()
+	defer m.pmut.Unlock()
+
+	if _, ok := m.protoConn[deviceID]; !ok {
+		return
+	}
+
+	folders, ok := m.tempIndexes[deviceID]
+	if !ok {
+		folders = make(map[string]map[string]tempFile)
+		m.tempIndexes[deviceID] = folders
+	}
+	files, ok := folders[folder]
+	if !ok {
+		files = make(map[string]tempFile)
+		folders[folder] = files
+	}
+
+	for _, f := range fs {
+		if len(f.Blocks) == 0 {
+			delete(files, f.Name)
+			continue
+		}
+		blocks := make(map[string]struct{}, len(f.Blocks))
+		for _, b := range f.Blocks {
+			blocks[string(b.Hash)] = struct{}{}
+		}
+		files[f.Name] = tempFile{
+			version: f.Version,
+			blocks:  blocks,
+		}
+	}
+}
+
+// clearTempIndex forgets about the temporary files of the device for the
+// given files, which it has announced in its regular index. A nil list clears
+// all files in the folder.
+func (m *Model) clearTempIndex(deviceID protocol.DeviceID, folder string, fs []protocol.FileInfo) {
+	m.pmut.Lock()
+	defer m.pmut.Unlock()
+
+	if fs == nil {
+		delete(m.tempIndexes[deviceID], folder)
+		return
+	}
+	files := m.tempIndexes[deviceID][folder]
+	for _, f := range fs {
+		delete(files, f.Name)
+	}
+}
+
+// TemporaryAvailability returns the connected devices that have the given
+// block of the file in the temporary file for the same version of it. Those
+// devices may be asked for the block with FlagRequestTemporary.
+func (m *Model) TemporaryAvailability(folder string, file protocol.FileInfo, hash []byte) []protocol.DeviceID {
+	m.pmut.RLock()
+	defer m.pmut.RUnlock()
+
+	var devices []protocol.DeviceID
+	for device, folders := range m.tempIndexes {
+		if _, ok := m.protoConn[device]; !ok {
+			continue
+		}
+		tf, ok := folders[folder][file.Name]
+		if !ok || !tf.version.Equal(file.Version) {
+			continue
+		}
+		if _, ok := tf.blocks[string(hash)]; ok {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
+// requestTemporary returns the requested block from the temporary file of a
+// file we are currently pulling. The block must be requested by hash, and is
+// only returned if it's really there.
+func (m *Model) requestTemporary(folder, name string, offset int64, size int, hash []byte) ([]byte, error) {
+	if size > scanner.MaxBlockSize || len(hash) != sha256.Size {
+		return nil, protocol.ErrInvalid
+	}
+	if !m.progressEmitter.IsPulling(folder, name) {
+		return nil, protocol.ErrNoSuchFile
+	}
+
+	m.fmut.RLock()
+	folderPath := m.folderCfgs[folder].Path()
+	m.fmut.RUnlock()
+
+	fd, err := os.Open(filepath.Join(folderPath, defTempNamer.TempName(name)))
+	if err != nil {
+		return nil, protocol.ErrNoSuchFile
+	}
+	defer fd.Close()
+
+	buf := make([]byte, size)
+	if _, err := fd.ReadAt(buf, offset); err != nil {
+		return nil, protocol.ErrNoSuchFile
+	}
+	if sum := sha256.Sum256(buf); !bytes.Equal(sum[:], hash) {
+		// We haven't got this block yet.
+		return nil, protocol.ErrNoSuchFile
+	}
+	return buf, nil
+}
+
+// The tempIndexSender periodically tells the connected devices that support
+// it which blocks are available in our temporary files.
+type tempIndexSender struct {
+	model *Model
+	sent  map[protocol.DeviceID]map[string]map[string]int // device -> folder -> file -> number of blocks announced
+	stop  chan struct{}
+}
+
+func newTempIndexSender(m *Model) *tempIndexSender {
+	return &tempIndexSender{
+		model: m,
+		sent:  make(map[protocol.DeviceID]map[string]map[string]int),
+		stop:  make(chan struct{}),
+	}
+}
+
+func (s *tempIndexSender) Serve() {
+	t := time.NewTicker(tempIndexInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			s.send()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *tempIndexSender) Stop() {
+	close(s.stop)
+}
+
+func (s *tempIndexSender) String() string {
+	return fmt.Sprintf("tempIndexSender@%p", s)
+}
+
+func (s *tempIndexSender) send() {
+	m := s.model
+
+	conns := make(map[protocol.DeviceID]protocol.Connection)
+	m.pmut.RLock()
+	for device, conn := range m.protoConn {
+		if m.deviceFeatures[device][featureTempIndexes] {
+			conns[device] = conn
+		}
+	}
+	m.pmut.RUnlock()
+
+	for device := range s.sent {
+		if _, ok := conns[device]; !ok {
+			delete(s.sent, device)
+		}
+	}
+
+	current := make(map[string][]protocol.FileInfo)
+	for device, conn := range conns {
+		m.fmut.RLock()
+		folders := m.deviceFolders[device]
+		m.fmut.RUnlock()
+
+		sent, ok := s.sent[device]
+		if !ok {
+			sent = make(map[string]map[string]int)
+			s.sent[device] = sent
+		}
+
+		for _, folder := range folders {
+			if _, ok := m.encryptedDevice(folder, device); ok {
+				continue
+			}
+
+			files, ok := current[folder]
+			if !ok {
+				files = m.progressEmitter.TemporaryFiles(folder)
+				current[folder] = files
+			}
+
+			prev := sent[folder]
+			next := make(map[string]int, len(files))
+			var updates []protocol.FileInfo
+			for _, f := range files {
+				if len(f.Blocks) == 0 {
+					continue
+				}
+				next[f.Name] = len(f.Blocks)
+				if n, ok := prev[f.Name]; !ok || n != len(f.Blocks) {
+					updates = append(updates, f)
+				}
+			}
+			for name := range prev {
+				if _, ok := next[name]; !ok {
+					// Done or given up; tell the other device to forget it.
+					updates = append(updates, protocol.FileInfo{Name: name})
+				}
+			}
+			sent[folder] = next
+
+			if len(updates) == 0 {
+				continue
+			}
+			if debug {
+				l.Debugf("%v TMPIDX(out): %s / %q: %d files", m, device, folder, len(updates))
+			}
+			if err := conn.IndexUpdate(folder, updates, protocol.FlagIndexTemporary, nil); err != nil {
+				delete(s.sent, device)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/scanner"
+	"github.com/syncthing/syncthing/internal/sync"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestTemporaryAvailability(t *testing.T) {
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel(defaultConfig, protocol.LocalDeviceID, "device", "syncthing", "dev", db)
+	m.AddFolder(defaultFolderConfig)
+	m.StartFolderRO("default")
+	fc := FakeConnection{id: device1}
+	m.AddConnection(fc, fc)
+
+	blocks := []protocol.BlockInfo{
+		{Offset: 0, Size: protocol.BlockSize, Hash: []byte("hash0")},
+		{Offset: protocol.BlockSize, Size: protocol.BlockSize, Hash: []byte("hash1")},
+	}
+	file := protocol.FileInfo{
+		Name:    "partial",
+		Version: protocol.Vector{{ID: device1.Short(), Value: 1}},
+		Blocks:  blocks,
+	}
+	partial := file
+	partial.Blocks = blocks[:1]
+
+	m.IndexUpdate(device1, "default", []protocol.FileInfo{partial}, protocol.FlagIndexTemporary, nil)
+
+	if devs := m.TemporaryAvailability("default", file, blocks[0].Hash); !reflect.DeepEqual(devs, []protocol.DeviceID{device1}) {
+		t.Errorf("Incorrect availability %v for available block", devs)
+	}
+	if devs := m.TemporaryAvailability("default", file, blocks[1].Hash); len(devs) != 0 {
+		t.Errorf("Incorrect availability %v for missing block", devs)
+	}
+
+	other := file
+	other.Version = protocol.Vector{{ID: device1.Short(), Value: 2}}
+	if devs := m.TemporaryAvailability("default", other, blocks[0].Hash); len(devs) != 0 {
+		t.Errorf("Incorrect availability %v for other version", devs)
+	}
+
+	// Once the file shows up in the regular index, the temporary file is
+	// gone.
+	m.IndexUpdate(device1, "default", []protocol.FileInfo{file}, 0, nil)
+	if devs := m.TemporaryAvailability("default", file, blocks[0].Hash); len(devs) != 0 {
+		t.Errorf("Incorrect availability %v after index update", devs)
+	}
+}
+
+func TestRequestTemporary(t *testing.T) {
+	data := []byte("some block data\n")
+	blocks, err := scanner.Blocks(bytes.NewReader(data), protocol.BlockSize, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := protocol.FileInfo{
+		Name:    "pulling",
+		Version: protocol.Vector{{ID: device1.Short(), Value: 1}},
+		Blocks:  blocks,
+	}
+
+	tempName := filepath.Join("testdata", defTempNamer.TempName("pulling"))
+	if err := ioutil.WriteFile(tempName, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tempName)
+
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel(defaultConfig, protocol.LocalDeviceID, "device", "syncthing", "dev", db)
+	m.AddFolder(defaultFolderConfig)
+
+	request := func() ([]byte, error) {
+		return m.Request(device1, "default", "pulling", 0, len(data), blocks[0].Hash, protocol.FlagRequestTemporary, nil)
+	}
+
+	if _, err := request(); err == nil {
+		t.Error("Unexpected nil error for file that is not being pulled")
+	}
+
+	state := &sharedPullerState{
+		file:   file,
+		folder: "default",
+		mut:    sync.NewMutex(),
+	}
+	m.progressEmitter.Register(state)
+	defer m.progressEmitter.Deregister(state)
+
+	bs, err := request()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bs, data) {
+		t.Errorf("Incorrect data %q", bs)
+	}
+
+	if _, err := m.Request(device1, "default", "pulling", 0, len(data), []byte("wrong"), protocol.FlagRequestTemporary, nil); err == nil {
+		t.Error("Unexpected nil error for wrong hash")
+	}
+}
@@ -0,0 +1,374 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/juju/ratelimit"
+	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/db"
+	"github.com/syncthing/syncthing/internal/events"
+	"github.com/syncthing/syncthing/internal/scanner"
+)
+
+// Verifying a folder rehashes the local files that are unchanged since they
+// were scanned and compares them to the blocks in the index, to find the
+// corruption that the scanner can't see. A corrupted file is marked invalid,
+// so that other devices don't request it, and gets flagLocalCorrupt. Its
+// record keeps the version and blocks of what the file should contain, so
+// that the damaged blocks can be pulled again from the devices that have the
+// same version. The scanner leaves it alone until it changes on disk.
+//
+// flagLocalCorrupt is a local flag only; it's outside of protocol.FlagsAll.
+const flagLocalCorrupt uint32 = 1 << 30
+
+var (
+	errAlreadyVerifying = errors.New("folder is already being verified")
+	errVerifyStopped    = errors.New("verification stopped")
+	errChangedOnDisk    = errors.New("file changed since it was scanned")
+)
+
+// isCorrupt returns true if the local file was found corrupted.
+func isCorrupt(f db.FileIntf) bool {
+	switch f := f.(type) {
+	case protocol.FileInfo:
+		return f.Flags&flagLocalCorrupt != 0
+	case db.FileInfoTruncated:
+		return f.Flags&flagLocalCorrupt != 0
+	}
+	return false
+}
+
+// VerifyFolder rehashes the local files of the folder that are unchanged
+// since they were scanned, and returns the number of corrupted files.
+func (m *Model) VerifyFolder(folder string) (int, error) {
+	return m.verifyFolder(folder, nil)
+}
+
+// StartVerifyFolder starts verifying the folder in the background, returning
+// an error if it can't be verified. The outcome is logged.
+func (m *Model) StartVerifyFolder(folder string) error {
+	fs, cfg, err := m.beginVerify(folder)
+	if err != nil {
+		return err
+	}
+	go func() {
+		n, err := m.runVerify(folder, fs, cfg, nil)
+		if err != nil {
+			l.Infof("Verifying folder %q: %v", folder, err)
+			return
+		}
+		l.Infof("Verified folder %q: %d corrupted files found", folder, n)
+	}()
+	return nil
+}
+
+func (m *Model) verifyFolder(folder string, stop chan struct{}) (int, error) {
+	fs, cfg, err := m.beginVerify(folder)
+	if err != nil {
+		return 0, err
+	}
+	return m.runVerify(folder, fs, cfg, stop)
+}
+
+// beginVerify checks that the folder can be verified and marks it as being
+// verified. runVerify must be called if it succeeds.
+func (m *Model) beginVerify(folder string) (*db.FileSet, config.FolderConfiguration, error) {
+	m.fmut.RLock()
+	fs, ok := m.folderFiles[folder]
+	cfg := m.folderCfgs[folder]
+	_, running := m.folderRunners[folder]
+	m.fmut.RUnlock()
+	if !ok || !running {
+		return nil, cfg, errors.New("no such folder")
+	}
+	if m.folderPaused(folder) {
+		return nil, cfg, errFolderPaused
+	}
+
+	m.scanMut.Lock()
+	defer m.scanMut.Unlock()
+	if m.verifying[folder] {
+		return nil, cfg, errAlreadyVerifying
+	}
+	m.verifying[folder] = true
+	return fs, cfg, nil
+}
+
+func (m *Model) runVerify(folder string, fs *db.FileSet, cfg config.FolderConfiguration, stop chan struct{}) (int, error) {
+	defer func() {
+		m.scanMut.Lock()
+		delete(m.verifying, folder)
+		m.scanMut.Unlock()
+	}()
+
+	var names []string
+	fs.WithHaveTruncated(protocol.LocalDeviceID, func(fi db.FileIntf) bool {
+		f := fi.(db.FileInfoTruncated)
+		if !f.IsDirectory() && !f.IsDeleted() && !f.IsSymlink() && (!f.IsInvalid() || isCorrupt(f)) {
+			names = append(names, f.Name)
+		}
+		return true
+	})
+
+	var bucket *ratelimit.Bucket
+	if cfg.VerifyRateKiBs > 0 {
+		rate := float64(1024 * cfg.VerifyRateKiBs)
+		bucket = ratelimit.NewBucketWithRate(rate, int64(rate))
+	}
+	mtimes := db.NewVirtualMtimeRepo(m.db, folder)
+
+	if debug {
+		l.Debugf("verifying %d files in folder %q", len(names), folder)
+	}
+
+	corrupted := 0
+	for _, name := range names {
+		select {
+		case <-stop:
+			return corrupted, errVerifyStopped
+		default:
+		}
+		if m.folderPaused(folder) {
+			return corrupted, errFolderPaused
+		}
+
+		f, ok := fs.Get(protocol.LocalDeviceID, name)
+		if !ok || f.IsDeleted() || (f.IsInvalid() && !isCorrupt(f)) {
+			// Changed since we started.
+			continue
+		}
+
+		path := filepath.Join(cfg.Path(), name)
+		damaged, err := verifyFile(path, f, mtimes, bucket)
+		if err != nil {
+			// The file has changed, and the scanner will take care of it,
+			// or we can't read it, which the puller will complain about.
+			if debug {
+				l.Debugf("verify: %s: %v", path, err)
+			}
+			continue
+		}
+
+		if len(damaged) == 0 {
+			if isCorrupt(f) {
+				// Fixed since, by a repair or otherwise.
+				m.markCorrupt(folder, f, false)
+			}
+			continue
+		}
+
+		corrupted++
+		repaired := false
+		if cfg.VerifyRepair {
+			if err := m.repairFile(folder, path, f, damaged); err != nil {
+				l.Infof("Folder %q: repairing %s: %v", folder, name, err)
+			} else {
+				l.Infof("Folder %q: repaired %d corrupted blocks of %s", folder, len(damaged), name)
+				repaired = true
+			}
+		}
+
+		if isCorrupt(f) {
+			if repaired {
+				m.markCorrupt(folder, f, false)
+			}
+			continue
+		}
+
+		if !repaired {
+			l.Warnf("Folder %q: %s is corrupted; %d of its %d blocks don't match the index", folder, name, len(damaged), len(f.Blocks))
+			m.markCorrupt(folder, f, true)
+		}
+		events.Default.Log(events.ItemCorrupted, map[string]interface{}{
+			"folder":   folder,
+			"item":     name,
+			"blocks":   len(damaged),
+			"repaired": repaired,
+		})
+	}
+
+	m.folderStatRef(folder).Verified()
+	return corrupted, nil
+}
+
+// verifyFile returns the indexes of the blocks of the file that don't match
+// the index, or an error if the file isn't the one in the index any more.
+func verifyFile(path string, f protocol.FileInfo, mtimes *db.VirtualMtimeRepo, bucket *ratelimit.Bucket) ([]int, error) {
+	unchanged := func() error {
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		mtime := mtimes.GetMtime(f.Name, info.ModTime())
+		if !info.Mode().IsRegular() || info.Size() != f.Size() || mtime.Unix() != f.Modified {
+			return errChangedOnDisk
+		}
+		return nil
+	}
+
+	if err := unchanged(); err != nil {
+		return nil, err
+	}
+
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	// Within the global hashing rate, if any.
+	r := scanner.LimitReader(fd)
+	if bucket != nil {
+		r = ratelimit.Reader(r, bucket)
+	}
+
+	var damaged []int
+	buf := make([]byte, scanner.BlockSizeOf(f.Blocks))
+	for i, block := range f.Blocks {
+		if int(block.Size) > len(buf) {
+			return nil, errChangedOnDisk
+		}
+		bs := buf[:block.Size]
+		if _, err := io.ReadFull(r, bs); err != nil {
+			return nil, err
+		}
+		if _, err := scanner.VerifyBuffer(bs, block); err != nil {
+			damaged = append(damaged, i)
+		}
+	}
+
+	// A file that was written to while we read it is the scanner's business.
+	if err := unchanged(); err != nil {
+		return nil, err
+	}
+	return damaged, nil
+}
+
+// repairFile replaces the damaged blocks of the file with the data of the
+// same version from other devices.
+func (m *Model) repairFile(folder, path string, f protocol.FileInfo, damaged []int) error {
+	gf, ok := m.CurrentGlobalFile(folder, f.Name)
+	if !ok || !gf.Version.Equal(f.Version) || !scanner.BlocksEqual(gf.Blocks, f.Blocks) {
+		return errors.New("no other device has this version")
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	offsets := make([]int64, len(f.Blocks))
+	var offset int64
+	for i, block := range f.Blocks {
+		offsets[i] = offset
+		offset += int64(block.Size)
+	}
+
+	fd, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	for _, i := range damaged {
+		block := f.Blocks[i]
+		block.Offset = offsets[i]
+		data, err := m.globalBlock(folder, gf, block)
+		if err != nil {
+			fd.Close()
+			return fmt.Errorf("block %d: %v", i, err)
+		}
+		if _, err := fd.WriteAt(data, block.Offset); err != nil {
+			fd.Close()
+			return err
+		}
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+
+	// Keep the modification time, so that the file is still seen as the one
+	// in the index.
+	return os.Chtimes(path, info.ModTime(), info.ModTime())
+}
+
+// markCorrupt sets or clears the corruption of the local file in the index.
+func (m *Model) markCorrupt(folder string, f protocol.FileInfo, corrupt bool) {
+	if corrupt {
+		f.Flags |= protocol.FlagInvalid | flagLocalCorrupt
+	} else {
+		f.Flags &^= protocol.FlagInvalid | flagLocalCorrupt
+	}
+	m.updateLocals(folder, []protocol.FileInfo{f})
+}
+
+// startVerifier starts the periodic verification of the folder, if enabled.
+func (m *Model) startVerifier(cfg config.FolderConfiguration) {
+	if cfg.VerifyIntervalH <= 0 {
+		return
+	}
+
+	m.Add(&folderVerifier{
+		model:    m,
+		folder:   cfg.ID,
+		interval: time.Duration(cfg.VerifyIntervalH) * time.Hour,
+		stop:     make(chan struct{}),
+	})
+}
+
+// A folderVerifier verifies a folder at the configured interval.
+type folderVerifier struct {
+	model    *Model
+	folder   string
+	interval time.Duration
+	stop     chan struct{}
+}
+
+func (v *folderVerifier) Serve() {
+	if debug {
+		l.Debugln(v, "starting")
+		defer l.Debugln(v, "exiting")
+	}
+
+	for {
+		// Leave a minute after startup or failure, and otherwise wait for
+		// the interval since the last complete verification.
+		next := v.interval - time.Since(v.model.folderStatRef(v.folder).GetLastVerify())
+		if next < time.Minute {
+			next = time.Minute
+		}
+
+		select {
+		case <-v.stop:
+			return
+		case <-time.After(next):
+		}
+
+		n, err := v.model.verifyFolder(v.folder, v.stop)
+		if err != nil {
+			if debug {
+				l.Debugf("%v: %v", v, err)
+			}
+			continue
+		}
+		l.Infof("Verified folder %q: %d corrupted files found", v.folder, n)
+	}
+}
+
+func (v *folderVerifier) Stop() {
+	close(v.stop)
+}
+
+func (v *folderVerifier) String() string {
+	return fmt.Sprintf("folderVerifier/%s@%p", v.folder, v)
+}
@@ -0,0 +1,142 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/config"
+)
+
+// corrupt flips a byte of the file, keeping its modification time.
+func corrupt(t *testing.T, path string, offset int64) {
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs := make([]byte, 1)
+	fd.ReadAt(bs, offset)
+	bs[0] ^= 0xff
+	fd.WriteAt(bs, offset)
+	fd.Close()
+	os.Chtimes(path, info.ModTime(), info.ModTime())
+}
+
+func TestVerifyFolder(t *testing.T) {
+	m, dir := setupFolderModel(t, config.FolderConfiguration{ID: "v"})
+	defer os.RemoveAll(dir)
+
+	data := bytes.Repeat([]byte("verify "), 100000)
+	for _, name := range []string{"good", "bad"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.ScanFolder("v"); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := m.CurrentFolderFile("v", "bad")
+
+	corrupt(t, filepath.Join(dir, "bad"), 12345)
+
+	n, err := m.VerifyFolder("v")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("%d corrupted files found, expected 1", n)
+	}
+	if f, _ := m.CurrentFolderFile("v", "good"); f.IsInvalid() {
+		t.Error("good file marked invalid")
+	}
+	f, _ := m.CurrentFolderFile("v", "bad")
+	if !f.IsInvalid() || !isCorrupt(f) || !f.Version.Equal(before.Version) {
+		t.Errorf("corrupted file not marked as such: %v", f)
+	}
+
+	// The corruption isn't taken for a local change by the scanner.
+	if err := m.ScanFolder("v"); err != nil {
+		t.Fatal(err)
+	}
+	if f, _ := m.CurrentFolderFile("v", "bad"); !isCorrupt(f) || !f.Version.Equal(before.Version) {
+		t.Errorf("corrupted file rescanned: %v", f)
+	}
+
+	// Once the file is fixed, it's valid again.
+	if err := ioutil.WriteFile(filepath.Join(dir, "bad"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Unix(before.Modified, 0)
+	os.Chtimes(filepath.Join(dir, "bad"), mtime, mtime)
+	if n, err := m.VerifyFolder("v"); err != nil || n != 0 {
+		t.Errorf("unexpected result %d, %v after fixing", n, err)
+	}
+	if f, _ := m.CurrentFolderFile("v", "bad"); f.IsInvalid() || isCorrupt(f) {
+		t.Errorf("fixed file still marked as corrupted: %v", f)
+	}
+}
+
+func TestVerifyFolderRepair(t *testing.T) {
+	m, dir := setupFolderModel(t, config.FolderConfiguration{ID: "v", VerifyRepair: true})
+	defer os.RemoveAll(dir)
+
+	data := []byte("data to repair")
+	path := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ScanFolder("v"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The other device has the same version.
+	f, _ := m.CurrentFolderFile("v", "file")
+	m.Index(device1, "v", []protocol.FileInfo{f}, 0, nil)
+	fc := FakeConnection{
+		id:          device1,
+		requestData: data,
+	}
+	m.AddConnection(fc, fc)
+
+	corrupt(t, path, 3)
+
+	if n, err := m.VerifyFolder("v"); err != nil || n != 1 {
+		t.Errorf("unexpected result %d, %v", n, err)
+	}
+	if bs, _ := ioutil.ReadFile(path); !bytes.Equal(bs, data) {
+		t.Errorf("file not repaired: %q", bs)
+	}
+	if cf, _ := m.CurrentFolderFile("v", "file"); cf.IsInvalid() || isCorrupt(cf) {
+		t.Errorf("repaired file marked as corrupted: %v", cf)
+	}
+}
+
+func TestStartVerifyFolder(t *testing.T) {
+	m, dir := setupFolderModel(t, config.FolderConfiguration{ID: "v"})
+	defer os.RemoveAll(dir)
+
+	if err := m.StartVerifyFolder("nonexistent"); err == nil {
+		t.Error("unexpected nil error for an unknown folder")
+	}
+
+	m.scanMut.Lock()
+	m.verifying["v"] = true
+	m.scanMut.Unlock()
+	if err := m.StartVerifyFolder("v"); err != errAlreadyVerifying {
+		t.Errorf("unexpected error %v while already verifying", err)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"errors"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/osutil"
+	"github.com/syncthing/syncthing/internal/versioner"
+)
+
+var errNoVersioning = errors.New("folder has no versioning")
+
+func (m *Model) folderVersioner(folder string) (versioner.Versioner, error) {
+	m.fmut.RLock()
+	runner, ok := m.folderRunners[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		return nil, errors.New("no such folder")
+	}
+
+	p, ok := runner.(*rwFolder)
+	if !ok || p.versioner == nil {
+		return nil, errNoVersioning
+	}
+	return p.versioner, nil
+}
+
+// FolderVersions returns the archived versions of the files in the folder
+// whose names start with the given prefix.
+func (m *Model) FolderVersions(folder, prefix string) (map[string][]versioner.FileVersion, error) {
+	v, err := m.folderVersioner(folder)
+	if err != nil {
+		return nil, err
+	}
+	return v.Versions(osutil.NativeFilename(prefix))
+}
+
+// RestoreFolderVersion replaces the named file with the version archived at
+// the given time, and rescans it so that the restored version is announced.
+func (m *Model) RestoreFolderVersion(folder, name string, versionTime time.Time) error {
+	v, err := m.folderVersioner(folder)
+	if err != nil {
+		return err
+	}
+
+	name = osutil.NativeFilename(name)
+	if err := v.Restore(name, versionTime); err != nil {
+		return err
+	}
+	return m.ScanFolderSubs(folder, []string{name})
+}
+
+// FolderArchiveStats returns the number and size of the archived versions
+// of the folder.
+func (m *Model) FolderArchiveStats(folder string) (versioner.ArchiveStats, error) {
+	v, err := m.folderVersioner(folder)
+	if err != nil {
+		return versioner.ArchiveStats{}, err
+	}
+	sv, ok := v.(versioner.StatsVersioner)
+	if !ok {
+		return versioner.ArchiveStats{}, errors.New("versioner has no archive stats")
+	}
+	return sv.ArchiveStats()
+}
@@ -0,0 +1,99 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/watcher"
+)
+
+// A watchScanner receives batches of changed paths from a filesystem watcher
+// and rescans them. The regular periodic scan of the folder stays in place
+// as a safety net for changes the watcher misses.
+type watchScanner struct {
+	model   *Model
+	folder  string
+	watcher *watcher.Watcher
+	stop    chan struct{}
+}
+
+// startWatcher starts a filesystem watcher for the folder if that is enabled
+// in the folder configuration.
+func (m *Model) startWatcher(cfg config.FolderConfiguration) {
+	if !cfg.FSWatcherEnabled {
+		return
+	}
+
+	delay := time.Duration(cfg.FSWatcherDelayS) * time.Second
+	w := watcher.New(cfg.ID, cfg.Path(), delay, m.watchFilter(cfg.ID))
+	m.Add(w)
+	m.Add(&watchScanner{
+		model:   m,
+		folder:  cfg.ID,
+		watcher: w,
+		stop:    make(chan struct{}),
+	})
+}
+
+// watchFilter returns a function that reports whether a change to the given
+// path is of interest to the scanner of the folder, applying the same rules
+// as the scanner itself.
+func (m *Model) watchFilter(folder string) func(string) bool {
+	return func(rn string) bool {
+		if defTempNamer.IsTemporary(rn) {
+			return false
+		}
+		if sn := filepath.Base(rn); sn == ".stignore" || sn == ".stfolder" ||
+			strings.HasPrefix(rn, ".stversions") {
+			return false
+		}
+
+		m.fmut.RLock()
+		ignores := m.folderIgnores[folder]
+		m.fmut.RUnlock()
+
+		return ignores == nil || !ignores.Match(rn)
+	}
+}
+
+func (s *watchScanner) Serve() {
+	if debug {
+		l.Debugln(s, "starting")
+		defer l.Debugln(s, "exiting")
+	}
+
+	for {
+		select {
+		case <-s.stop:
+			return
+
+		case subs := <-s.watcher.C():
+			if s.model.folderPaused(s.folder) {
+				// The folder is rescanned in full when it's resumed.
+				continue
+			}
+			if debug {
+				l.Debugf("%v rescanning %d changed paths", s, len(subs))
+			}
+			if err := s.model.ScanFolderSubs(s.folder, subs); err != nil {
+				l.Infof("Rescanning folder %q after change notification: %v", s.folder, err)
+			}
+		}
+	}
+}
+
+func (s *watchScanner) Stop() {
+	close(s.stop)
+}
+
+func (s *watchScanner) String() string {
+	return "watchScanner/" + s.folder
+}
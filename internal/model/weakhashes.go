@@ -0,0 +1,229 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/scanner"
+	"github.com/syncthing/syncthing/internal/weakhash"
+)
+
+var errNoWeakHashDevice = errors.New("no connected device supports weak hashes")
+
+// Weak hashes are kept for this many files, so that a file pulled by several
+// devices is only read once per version.
+const maxWeakHashCache = 64
+
+type weakHashEntry struct {
+	version  protocol.Vector
+	modified int64
+	hashes   []byte
+}
+
+// weakHashes returns the weak hash of each block of the local version of the
+// file, as one big endian uint32 per block.
+func (m *Model) weakHashes(folder, name string) ([]byte, error) {
+	m.fmut.RLock()
+	fs, ok := m.folderFiles[folder]
+	folderPath := m.folderCfgs[folder].Path()
+	m.fmut.RUnlock()
+	if !ok {
+		return nil, protocol.ErrNoSuchFile
+	}
+
+	lf, ok := fs.Get(protocol.LocalDeviceID, name)
+	if !ok || lf.IsDirectory() || lf.IsSymlink() {
+		return nil, protocol.ErrNoSuchFile
+	}
+	if lf.IsInvalid() || lf.IsDeleted() {
+		return nil, protocol.ErrInvalid
+	}
+
+	key := folder + "/" + name
+	m.weakMut.Lock()
+	e, ok := m.weakCache[key]
+	m.weakMut.Unlock()
+	if ok && e.version.Equal(lf.Version) && e.modified == lf.Modified {
+		return e.hashes, nil
+	}
+
+	res, err := readWeakHashes(filepath.Join(folderPath, name), lf.Blocks)
+	if err != nil {
+		return nil, err
+	}
+
+	m.weakMut.Lock()
+	if len(m.weakCache) >= maxWeakHashCache {
+		// Evict an arbitrary entry.
+		for k := range m.weakCache {
+			delete(m.weakCache, k)
+			break
+		}
+	}
+	m.weakCache[key] = weakHashEntry{
+		version:  lf.Version,
+		modified: lf.Modified,
+		hashes:   res,
+	}
+	m.weakMut.Unlock()
+	return res, nil
+}
+
+func readWeakHashes(path string, blocks []protocol.BlockInfo) ([]byte, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	res := make([]byte, 4*len(blocks))
+	var buf []byte
+	for i, block := range blocks {
+		if cap(buf) < int(block.Size) {
+			buf = make([]byte, block.Size)
+		}
+		buf = buf[:block.Size]
+		if _, err := io.ReadFull(fd, buf); err != nil {
+			return nil, err
+		}
+		binary.BigEndian.PutUint32(res[4*i:], weakhash.Block(buf))
+	}
+	return res, nil
+}
+
+// requestWeakHashes asks a connected device that has the given version of
+// the file for the weak hashes of its blocks.
+func (m *Model) requestWeakHashes(folder string, file protocol.FileInfo) ([]uint32, error) {
+	options := []protocol.Option{{Key: "type", Value: featureWeakHashes}}
+
+	var lastErr error = errNoWeakHashDevice
+	for _, device := range m.Availability(folder, file.Name) {
+		if !m.deviceHasFeature(device, featureWeakHashes) {
+			continue
+		}
+		if _, ok := m.encryptedDevice(folder, device); ok {
+			continue
+		}
+
+		data, err := m.requestGlobal(device, folder, file.Name, 0, 0, nil, 0, options)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if len(data) != 4*len(file.Blocks) {
+			// The device has a different version of the file than what we
+			// asked about.
+			lastErr = errors.New("weak hash list length mismatch")
+			continue
+		}
+
+		res := make([]uint32, len(file.Blocks))
+		for i := range res {
+			res[i] = binary.BigEndian.Uint32(data[4*i:])
+		}
+		return res, nil
+	}
+	return nil, lastErr
+}
+
+// A shiftedBlockFinder locates blocks of a new file version in the old
+// version of the file, at any offset, using the rolling weak hash to find
+// candidates and the block hash to verify them.
+type shiftedBlockFinder struct {
+	fd   *os.File
+	weak map[int64]uint32   // block offset in new file -> weak hash
+	hits map[uint32][]int64 // weak hash -> candidate offsets in old file
+}
+
+// newShiftedBlockFinder searches the old version of the file for the given
+// blocks of the new version. It returns nil when there is no old version to
+// search, no weak hashes are available or nothing was found.
+func (p *rwFolder) newShiftedBlockFinder(state copyBlocksState, blocks []protocol.BlockInfo) *shiftedBlockFinder {
+	if len(blocks) == 0 {
+		return nil
+	}
+
+	info, err := os.Lstat(state.realName)
+	if err != nil || !info.Mode().IsRegular() || info.Size() < int64(blocks[0].Size) {
+		return nil
+	}
+
+	weak, err := p.model.requestWeakHashes(p.folder, state.file)
+	if err != nil {
+		if debug {
+			l.Debugf("%v weak hashes for %q: %v", p, state.file.Name, err)
+		}
+		return nil
+	}
+
+	f := &shiftedBlockFinder{
+		weak: make(map[int64]uint32, len(blocks)),
+	}
+	for i, block := range state.file.Blocks {
+		f.weak[block.Offset] = weak[i]
+	}
+
+	// All blocks but the last one of a file have the same size, so we
+	// search for those. The last block is left to the regular pull.
+	size := state.file.Blocks[0].Size
+	var hashes []uint32
+	for _, block := range blocks {
+		if block.Size == size {
+			hashes = append(hashes, f.weak[block.Offset])
+		}
+	}
+
+	f.fd, err = os.Open(state.realName)
+	if err != nil {
+		return nil
+	}
+	f.hits, err = weakhash.Find(f.fd, hashes, int(size))
+	if err != nil || len(f.hits) == 0 {
+		f.fd.Close()
+		return nil
+	}
+
+	if debug {
+		l.Debugf("%v found %d weak hash candidates for %q", p, len(f.hits), state.file.Name)
+	}
+	return f
+}
+
+// copy writes the block to dst if it can be found in the old file, and
+// returns whether it did so.
+func (f *shiftedBlockFinder) copy(block protocol.BlockInfo, buf []byte, dst io.WriterAt) (bool, error) {
+	if f == nil {
+		return false, nil
+	}
+
+	for _, offset := range f.hits[f.weak[block.Offset]] {
+		buf = buf[:block.Size]
+		if _, err := f.fd.ReadAt(buf, offset); err != nil {
+			continue
+		}
+		if _, err := scanner.VerifyBuffer(buf, block); err != nil {
+			continue
+		}
+		if _, err := dst.WriteAt(buf, block.Offset); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func (f *shiftedBlockFinder) close() {
+	if f != nil {
+		f.fd.Close()
+	}
+}
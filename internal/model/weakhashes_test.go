@@ -0,0 +1,144 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/scanner"
+	"github.com/syncthing/syncthing/internal/sync"
+	"github.com/syncthing/syncthing/internal/weakhash"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestRequestWeakHashes(t *testing.T) {
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel(defaultConfig, protocol.LocalDeviceID, "device", "syncthing", "dev", db)
+	m.AddFolder(defaultFolderConfig)
+	m.StartFolderRO("default")
+	m.ScanFolder("default")
+
+	options := []protocol.Option{{Key: "type", Value: featureWeakHashes}}
+	bs, err := m.Request(device1, "default", "foo", 0, 0, nil, 0, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := make([]byte, 4)
+	binary.BigEndian.PutUint32(expected, weakhash.Block([]byte("foobar\n")))
+	if !bytes.Equal(bs, expected) {
+		t.Errorf("Incorrect weak hashes %x != expected %x", bs, expected)
+	}
+
+	if _, err := m.Request(device1, "default", "nonexistent", 0, 0, nil, 0, options); err == nil {
+		t.Error("Unexpected nil error for nonexistent file")
+	}
+}
+
+func TestCopierShiftedBlocks(t *testing.T) {
+	// The old file is three blocks of random data. The new file is the same
+	// data with a few bytes inserted at the start, so none of the blocks are
+	// at their old offsets any more.
+	old := make([]byte, 3*protocol.BlockSize)
+	rand.New(rand.NewSource(42)).Read(old)
+	data := append([]byte("12345"), old...)
+
+	oldName := filepath.Join("testdata", "shifted")
+	tempName := filepath.Join("testdata", defTempNamer.TempName("shifted"))
+	if err := ioutil.WriteFile(oldName, old, 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(oldName)
+	defer os.Remove(tempName)
+
+	blocks, err := scanner.Blocks(bytes.NewReader(data), protocol.BlockSize, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := protocol.FileInfo{
+		Name:    "shifted",
+		Version: protocol.Vector{{ID: device1.Short(), Value: 1}},
+		Blocks:  blocks,
+	}
+
+	weak := make([]byte, 4*len(blocks))
+	for i, b := range blocks {
+		binary.BigEndian.PutUint32(weak[4*i:], weakhash.Block(data[b.Offset:b.Offset+int64(b.Size)]))
+	}
+
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel(defaultConfig, protocol.LocalDeviceID, "device", "syncthing", "dev", db)
+	m.AddFolder(defaultFolderConfig)
+	fc := FakeConnection{
+		id:          device1,
+		requestData: weak,
+	}
+	m.AddConnection(fc, fc)
+	m.ClusterConfig(device1, protocol.ClusterConfigMessage{
+		Options: []protocol.Option{{Key: "features", Value: featureWeakHashes}},
+	})
+	m.Index(device1, "default", []protocol.FileInfo{file}, 0, nil)
+
+	p := rwFolder{
+		folder:    "default",
+		dir:       "testdata",
+		model:     m,
+		errors:    make(map[string]string),
+		errorsMut: sync.NewMutex(),
+	}
+
+	copyChan := make(chan copyBlocksState)
+	pullChan := make(chan pullBlockState, 4)
+	finisherChan := make(chan *sharedPullerState, 1)
+
+	go p.copierRoutine(copyChan, pullChan, finisherChan)
+
+	p.handleFile(file, copyChan, finisherChan)
+
+	// The first block contains the inserted data and the last one is short;
+	// the two in between are copied from the old file.
+	pulls := []pullBlockState{<-pullChan, <-pullChan}
+	finish := <-finisherChan
+	defer finish.fd.Close()
+
+	select {
+	case ps := <-pullChan:
+		t.Fatalf("Unexpected pull of %v", ps.block)
+	default:
+	}
+
+	for i, eq := range []int{0, 3} {
+		if pulls[i].block.Offset != blocks[eq].Offset {
+			t.Errorf("Pulled block %v, expected %v", pulls[i].block, blocks[eq])
+		}
+	}
+	if finish.copyOrigin != 2 {
+		t.Errorf("Copied %d blocks from origin, expected 2", finish.copyOrigin)
+	}
+
+	copied := make([]byte, protocol.BlockSize)
+	fd, err := os.Open(tempName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	for _, eq := range []int{1, 2} {
+		if _, err := fd.ReadAt(copied, blocks[eq].Offset); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := scanner.VerifyBuffer(copied, blocks[eq]); err != nil {
+			t.Errorf("Block %d: %v", eq, err)
+		}
+	}
+}
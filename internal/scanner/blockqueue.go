@@ -7,25 +7,35 @@
 package scanner
 
 import (
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
 
 	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/db"
 	"github.com/syncthing/syncthing/internal/sync"
 )
 
+var errFileChanged = errors.New("file changed while being hashed")
+
 // The parallell hasher reads FileInfo structures from the inbox, hashes the
 // file to populate the Blocks element and sends it to the outbox. A number of
 // workers are used in parallel. The outbox will become closed when the inbox
-// is closed and all items handled.
+// is closed and all items handled. The hashing done is counted by the
+// counter, if not nil. The workers share the process wide hashing limits with
+// all other hashers. Files that have changed since they were walked, or
+// while being hashed, are not sent to the outbox but added to the deferred
+// files, to be scanned again later. The modification times seen by the walker
+// are translated by mtimes, if not nil.
 
-func newParallelHasher(dir string, blockSize, workers int, outbox, inbox chan protocol.FileInfo) {
+func newParallelHasher(dir string, blockSize, workers int, outbox, inbox chan protocol.FileInfo, counter *ProgressCounter, deferred *deferredFiles, mtimes *db.VirtualMtimeRepo) {
 	wg := sync.NewWaitGroup()
 	wg.Add(workers)
 
 	for i := 0; i < workers; i++ {
 		go func() {
-			hashFiles(dir, blockSize, outbox, inbox)
+			hashFiles(dir, blockSize, outbox, inbox, counter, deferred, mtimes)
 			wg.Done()
 		}()
 	}
@@ -37,6 +47,12 @@ func newParallelHasher(dir string, blockSize, workers int, outbox, inbox chan pr
 }
 
 func HashFile(path string, blockSize int) ([]protocol.BlockInfo, error) {
+	return hashFile(path, blockSize, nil, nil)
+}
+
+// hashFile hashes the file, counting the bytes read and limiting the rate
+// of reading if the counter and limits are given.
+func hashFile(path string, blockSize int, counter *ProgressCounter, limits *hashLimits) ([]protocol.BlockInfo, error) {
 	fd, err := os.Open(path)
 	if err != nil {
 		if debug {
@@ -54,17 +70,70 @@ func HashFile(path string, blockSize int) ([]protocol.BlockInfo, error) {
 		return []protocol.BlockInfo{}, err
 	}
 	defer fd.Close()
-	return Blocks(fd, blockSize, fi.Size())
+
+	var r io.Reader = fd
+	if limits != nil {
+		r = limits.reader(r)
+	}
+	if counter != nil {
+		r = countingReader{r, counter}
+	}
+	return Blocks(r, blockSize, fi.Size())
+}
+
+// hashStableFile hashes the file like hashFile, but returns errFileChanged if
+// the file was modified since it was walked or while it was being read.
+func hashStableFile(path string, f protocol.FileInfo, mtimes *db.VirtualMtimeRepo, blockSize int, counter *ProgressCounter, limits *hashLimits) ([]protocol.BlockInfo, error) {
+	before, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	mtime := before.ModTime()
+	if mtimes != nil {
+		mtime = mtimes.GetMtime(f.Name, mtime)
+	}
+	if mtime.Unix() != f.Modified {
+		return nil, errFileChanged
+	}
+
+	blocks, err := hashFile(path, blockSize, counter, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	var size int64
+	for _, b := range blocks {
+		size += int64(b.Size)
+	}
+	if after.Size() != before.Size() || !after.ModTime().Equal(before.ModTime()) || size != after.Size() {
+		return nil, errFileChanged
+	}
+	return blocks, nil
 }
 
-func hashFiles(dir string, blockSize int, outbox, inbox chan protocol.FileInfo) {
+func hashFiles(dir string, blockSize int, outbox, inbox chan protocol.FileInfo, counter *ProgressCounter, deferred *deferredFiles, mtimes *db.VirtualMtimeRepo) {
 	for f := range inbox {
 		if f.IsDirectory() || f.IsDeleted() || f.IsSymlink() {
 			outbox <- f
 			continue
 		}
 
-		blocks, err := HashFile(filepath.Join(dir, f.Name), blockSize)
+		limits := currentHashLimits()
+		done := limits.start()
+		blocks, err := hashStableFile(filepath.Join(dir, f.Name), f, mtimes, blockSize, counter, limits)
+		done()
+		counter.hashedFile()
+		if err == errFileChanged && deferred != nil {
+			if debug {
+				l.Debugln("changed while hashing:", f.Name)
+			}
+			deferred.add(f.Name)
+			continue
+		}
 		if err != nil {
 			if debug {
 				l.Debugln("hash error:", f.Name, err)
@@ -17,8 +17,48 @@ import (
 
 var SHA256OfNothing = []uint8{0xe3, 0xb0, 0xc4, 0x42, 0x98, 0xfc, 0x1c, 0x14, 0x9a, 0xfb, 0xf4, 0xc8, 0x99, 0x6f, 0xb9, 0x24, 0x27, 0xae, 0x41, 0xe4, 0x64, 0x9b, 0x93, 0x4c, 0xa4, 0x95, 0x99, 0x1b, 0x78, 0x52, 0xb8, 0x55}
 
-// Blocks returns the blockwise hash of the reader.
+const (
+	// MinBlockSize is the smallest block size we use. It's also the only
+	// block size used by older devices.
+	MinBlockSize = protocol.BlockSize
+	// MaxBlockSize is the largest block size we use.
+	MaxBlockSize = 16 << 20
+	// Files are cut into at most this many blocks, unless that would require
+	// blocks larger than MaxBlockSize.
+	maxBlocksPerFile = 2000
+)
+
+// BlockSize returns the block size to use for a file of the given size; the
+// smallest power of two between MinBlockSize and MaxBlockSize that results in
+// no more than maxBlocksPerFile blocks.
+func BlockSize(fileSize int64) int {
+	blockSize := MinBlockSize
+	for blockSize < MaxBlockSize && fileSize > int64(blockSize)*maxBlocksPerFile {
+		blockSize *= 2
+	}
+	return blockSize
+}
+
+// BlockSizeOf returns the block size that was used to create the given block
+// list. All blocks but the last one of a file have the same size. A file with
+// a single block could have been hashed with any block size at least as large
+// as the block, in which case we return what we would have used.
+func BlockSizeOf(blocks []protocol.BlockInfo) int {
+	if len(blocks) > 1 {
+		return int(blocks[0].Size)
+	}
+	if len(blocks) == 1 {
+		return BlockSize(int64(blocks[0].Size))
+	}
+	return MinBlockSize
+}
+
+// Blocks returns the blockwise hash of the reader. If blocksize is zero, the
+// block size is chosen based on the size hint.
 func Blocks(r io.Reader, blocksize int, sizehint int64) ([]protocol.BlockInfo, error) {
+	if blocksize <= 0 {
+		blocksize = BlockSize(sizehint)
+	}
 	var blocks []protocol.BlockInfo
 	if sizehint > 0 {
 		blocks = make([]protocol.BlockInfo, 0, int(sizehint/int64(blocksize)))
@@ -95,6 +135,10 @@ func BlockDiff(src, tgt []protocol.BlockInfo) (have, need []protocol.BlockInfo)
 // Verify returns nil or an error describing the mismatch between the block
 // list and actual reader contents
 func Verify(r io.Reader, blocksize int, blocks []protocol.BlockInfo) error {
+	// Verifying is reading at the rate of hashing, but without taking a
+	// hasher from the pool, lest a long scan hold up the pullers.
+	r = LimitReader(r)
+
 	hf := sha256.New()
 	for i, block := range blocks {
 		lr := &io.LimitedReader{R: r, N: int64(blocksize)}
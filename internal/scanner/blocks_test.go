@@ -122,3 +122,42 @@ func TestDiff(t *testing.T) {
 		}
 	}
 }
+
+func TestBlockSize(t *testing.T) {
+	cases := []struct {
+		fileSize  int64
+		blockSize int
+	}{
+		{0, MinBlockSize},
+		{1, MinBlockSize},
+		{MinBlockSize * maxBlocksPerFile, MinBlockSize},
+		{MinBlockSize*maxBlocksPerFile + 1, 2 * MinBlockSize},
+		{4 * MinBlockSize * maxBlocksPerFile, 4 * MinBlockSize},
+		{1 << 50, MaxBlockSize},
+	}
+
+	for _, tc := range cases {
+		if bs := BlockSize(tc.fileSize); bs != tc.blockSize {
+			t.Errorf("BlockSize(%d) = %d, expected %d", tc.fileSize, bs, tc.blockSize)
+		}
+	}
+}
+
+func TestBlockSizeOf(t *testing.T) {
+	big := make([]byte, 3*MinBlockSize+10)
+	blocks, err := Blocks(bytes.NewReader(big), 2*MinBlockSize, int64(len(big)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bs := BlockSizeOf(blocks); bs != 2*MinBlockSize {
+		t.Errorf("Incorrect block size %d != %d", bs, 2*MinBlockSize)
+	}
+
+	blocks, err = Blocks(bytes.NewReader([]byte("contents")), 0, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bs := BlockSizeOf(blocks); bs != MinBlockSize {
+		t.Errorf("Incorrect block size %d != %d", bs, MinBlockSize)
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package scanner
+
+import "syscall"
+
+// From linux/ioprio.h
+const (
+	ioprioClassIdle  = 3
+	ioprioClassShift = 13
+	ioprioWhoProcess = 1
+)
+
+// setLowIOPriority puts the calling thread in the idle IO scheduling class,
+// returning a function that restores its previous priority.
+func setLowIOPriority() (func(), error) {
+	prev, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_GET, ioprioWhoProcess, 0, 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, 0, ioprioClassIdle<<ioprioClassShift); errno != 0 {
+		return nil, errno
+	}
+	return func() {
+		syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, 0, prev)
+	}, nil
+}
@@ -0,0 +1,16 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build !linux
+
+package scanner
+
+import "errors"
+
+// setLowIOPriority is only supported on Linux.
+func setLowIOPriority() (func(), error) {
+	return nil, errors.New("IO priority not supported on this platform")
+}
@@ -0,0 +1,97 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package scanner
+
+import (
+	"io"
+	"runtime"
+
+	"github.com/juju/ratelimit"
+	"github.com/syncthing/syncthing/internal/sync"
+)
+
+// Hashing is limited process wide, for all walkers and folders together. At
+// most a given number of files are hashed at once, reading at most a given
+// number of bytes per second in total, and optionally with a low IO
+// priority.
+type hashLimits struct {
+	bucket      *ratelimit.Bucket // nil for unlimited
+	pool        chan struct{}
+	lowPriority bool
+}
+
+var (
+	limits    = newHashLimits(0, 0, false)
+	limitsMut = sync.NewMutex()
+)
+
+// SetHashLimits sets the limits on hashing. A rate of zero is unlimited,
+// and zero hashers means one per CPU. Hashing in progress keeps the limits
+// it was started with.
+func SetHashLimits(bytesPerSec int64, hashers int, lowPriorityIO bool) {
+	h := newHashLimits(bytesPerSec, hashers, lowPriorityIO)
+	limitsMut.Lock()
+	limits = h
+	limitsMut.Unlock()
+}
+
+func newHashLimits(bytesPerSec int64, hashers int, lowPriorityIO bool) *hashLimits {
+	if hashers <= 0 {
+		hashers = runtime.GOMAXPROCS(-1)
+	}
+	h := &hashLimits{
+		pool:        make(chan struct{}, hashers),
+		lowPriority: lowPriorityIO,
+	}
+	if bytesPerSec > 0 {
+		h.bucket = ratelimit.NewBucketWithRate(float64(bytesPerSec), bytesPerSec)
+	}
+	return h
+}
+
+func currentHashLimits() *hashLimits {
+	limitsMut.Lock()
+	defer limitsMut.Unlock()
+	return limits
+}
+
+// start waits for a free hasher and returns a function to call when done
+// hashing. In between, the calling goroutine has a low IO priority, if set.
+func (h *hashLimits) start() func() {
+	h.pool <- struct{}{}
+	if !h.lowPriority {
+		return func() { <-h.pool }
+	}
+
+	// The IO priority is a property of the thread.
+	runtime.LockOSThread()
+	restore, err := setLowIOPriority()
+	if err != nil && debug {
+		l.Debugln("setting IO priority:", err)
+	}
+	return func() {
+		if restore != nil {
+			restore()
+		}
+		runtime.UnlockOSThread()
+		<-h.pool
+	}
+}
+
+// reader returns the reader limited to the rate of hashing.
+func (h *hashLimits) reader(r io.Reader) io.Reader {
+	if h.bucket == nil {
+		return r
+	}
+	return ratelimit.Reader(r, h.bucket)
+}
+
+// LimitReader returns the reader limited to the rate set by SetHashLimits,
+// for reading files to hash them.
+func LimitReader(r io.Reader) io.Reader {
+	return currentHashLimits().reader(r)
+}
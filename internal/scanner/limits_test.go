@@ -0,0 +1,57 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package scanner
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestHashLimits(t *testing.T) {
+	defer SetHashLimits(0, 0, false)
+
+	// The first second's worth is read at once, the rest at the rate.
+	SetHashLimits(64<<10, 1, true)
+	data := make([]byte, 128<<10)
+	blocks, err := Blocks(bytes.NewReader(data), 0, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t0 := time.Now()
+	if err := Verify(bytes.NewReader(data), BlockSize(int64(len(data))), blocks); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(t0); d < 900*time.Millisecond {
+		t.Errorf("verified in %v, faster than the limit", d)
+	}
+
+	// There's one hasher, which is free again.
+	limits := currentHashLimits()
+	if cap(limits.pool) != 1 || len(limits.pool) != 0 {
+		t.Errorf("unexpected hasher pool %d/%d", len(limits.pool), cap(limits.pool))
+	}
+
+	// Verifying doesn't wait for a hasher.
+	limits.pool <- struct{}{}
+	defer func() { <-limits.pool }()
+	small := []byte("verify")
+	blocks, _ = Blocks(bytes.NewReader(small), 0, int64(len(small)))
+	done := make(chan error, 1)
+	go func() {
+		done <- Verify(bytes.NewReader(small), BlockSize(int64(len(small))), blocks)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("verifying waited for a hasher")
+	}
+}
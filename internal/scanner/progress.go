@@ -0,0 +1,78 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package scanner
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// ScanProgress is the amount of work found and done by a scan. Files and
+// bytes are discovered when the walker finds a file that needs hashing.
+type ScanProgress struct {
+	FilesDiscovered int64 `json:"filesDiscovered"`
+	BytesDiscovered int64 `json:"bytesDiscovered"`
+	FilesHashed     int64 `json:"filesHashed"`
+	BytesHashed     int64 `json:"bytesHashed"`
+}
+
+// A ProgressCounter is updated by the walker and the hashers as a scan
+// proceeds. It's safe for concurrent use, and a nil counter counts nothing.
+type ProgressCounter struct {
+	p ScanProgress
+}
+
+func NewProgressCounter() *ProgressCounter {
+	return &ProgressCounter{}
+}
+
+// Progress returns the current progress.
+func (c *ProgressCounter) Progress() ScanProgress {
+	if c == nil {
+		return ScanProgress{}
+	}
+	return ScanProgress{
+		FilesDiscovered: atomic.LoadInt64(&c.p.FilesDiscovered),
+		BytesDiscovered: atomic.LoadInt64(&c.p.BytesDiscovered),
+		FilesHashed:     atomic.LoadInt64(&c.p.FilesHashed),
+		BytesHashed:     atomic.LoadInt64(&c.p.BytesHashed),
+	}
+}
+
+func (c *ProgressCounter) discovered(size int64) {
+	if c == nil {
+		return
+	}
+	atomic.AddInt64(&c.p.FilesDiscovered, 1)
+	atomic.AddInt64(&c.p.BytesDiscovered, size)
+}
+
+func (c *ProgressCounter) hashedFile() {
+	if c == nil {
+		return
+	}
+	atomic.AddInt64(&c.p.FilesHashed, 1)
+}
+
+func (c *ProgressCounter) hashedBytes(n int64) {
+	if c == nil {
+		return
+	}
+	atomic.AddInt64(&c.p.BytesHashed, n)
+}
+
+// A countingReader counts the bytes read through it as hashed.
+type countingReader struct {
+	io.Reader
+	counter *ProgressCounter
+}
+
+func (r countingReader) Read(bs []byte) (int, error) {
+	n, err := r.Reader.Read(bs)
+	r.counter.hashedBytes(int64(n))
+	return n, err
+}
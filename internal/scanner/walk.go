@@ -20,6 +20,7 @@ import (
 	"github.com/syncthing/syncthing/internal/ignore"
 	"github.com/syncthing/syncthing/internal/osutil"
 	"github.com/syncthing/syncthing/internal/symlinks"
+	"github.com/syncthing/syncthing/internal/sync"
 	"golang.org/x/text/unicode/norm"
 )
 
@@ -43,7 +44,8 @@ type Walker struct {
 	Dir string
 	// Limit walking to these paths within Dir, or no limit if Sub is empty
 	Subs []string
-	// BlockSize controls the size of the block used when hashing.
+	// BlockSize controls the size of the block used when hashing. If zero,
+	// the block size is chosen for each file based on its size.
 	BlockSize int
 	// If Matcher is not nil, it is used to identify files to ignore which were specified by the user.
 	Matcher *ignore.Matcher
@@ -64,8 +66,34 @@ type Walker struct {
 	AutoNormalize bool
 	// Number of routines to use for hashing
 	Hashers int
+	// If Progress is not nil, it counts the files to hash and the hashing
+	// done.
+	Progress *ProgressCounter
+	// Files modified more recently than MinFileAge are not hashed, but left
+	// for a later scan, as they may still be being written.
+	MinFileAge time.Duration
 	// Our vector clock id
 	ShortID uint64
+
+	deferred *deferredFiles
+}
+
+// deferredFiles collects the names of the files left for a later scan.
+type deferredFiles struct {
+	names []string
+	mut   sync.Mutex
+}
+
+func newDeferredFiles() *deferredFiles {
+	return &deferredFiles{
+		mut: sync.NewMutex(),
+	}
+}
+
+func (d *deferredFiles) add(name string) {
+	d.mut.Lock()
+	d.names = append(d.names, name)
+	d.mut.Unlock()
 }
 
 type TempNamer interface {
@@ -94,7 +122,8 @@ func (w *Walker) Walk() (chan protocol.FileInfo, error) {
 
 	files := make(chan protocol.FileInfo)
 	hashedFiles := make(chan protocol.FileInfo)
-	newParallelHasher(w.Dir, w.BlockSize, w.Hashers, hashedFiles, files)
+	w.deferred = newDeferredFiles()
+	newParallelHasher(w.Dir, w.BlockSize, w.Hashers, hashedFiles, files, w.Progress, w.deferred, w.MtimeRepo)
 
 	go func() {
 		hashFiles := w.walkAndHashFiles(files)
@@ -111,6 +140,19 @@ func (w *Walker) Walk() (chan protocol.FileInfo, error) {
 	return hashedFiles, nil
 }
 
+// Deferred returns the files that were modified too recently, or while they
+// were being hashed, to be returned by the walk. They should be scanned
+// again later. The list is complete once the channel returned by Walk is
+// closed.
+func (w *Walker) Deferred() []string {
+	if w.deferred == nil {
+		return nil
+	}
+	w.deferred.mut.Lock()
+	defer w.deferred.mut.Unlock()
+	return append([]string(nil), w.deferred.names...)
+}
+
 func (w *Walker) walkAndHashFiles(fchan chan protocol.FileInfo) filepath.WalkFunc {
 	now := time.Now()
 	return func(p string, info os.FileInfo, err error) error {
@@ -331,10 +373,12 @@ func (w *Walker) walkAndHashFiles(fchan chan protocol.FileInfo) filepath.WalkFun
 				//  - was not a symlink (since it's a file now)
 				//  - was not invalid (since it looks valid now)
 				//  - has the same size as previously
+				//  - was hashed with the block size we use, if it's fixed
 				cf, ok = w.CurrentFiler.CurrentFile(rn)
 				permUnchanged := w.IgnorePerms || !cf.HasPermissionBits() || PermsEqual(cf.Flags, curMode)
+				blockSizeUnchanged := w.BlockSize <= 0 || BlockSizeOf(cf.Blocks) == w.BlockSize
 				if ok && permUnchanged && !cf.IsDeleted() && cf.Modified == mtime.Unix() && !cf.IsDirectory() &&
-					!cf.IsSymlink() && !cf.IsInvalid() && cf.Size() == info.Size() {
+					!cf.IsSymlink() && !cf.IsInvalid() && cf.Size() == info.Size() && blockSizeUnchanged {
 					return nil
 				}
 
@@ -343,6 +387,16 @@ func (w *Walker) walkAndHashFiles(fchan chan protocol.FileInfo) filepath.WalkFun
 				}
 			}
 
+			if age := time.Since(info.ModTime()); w.MinFileAge > 0 && age >= 0 && age < w.MinFileAge {
+				// Possibly still being written to. Files dated in the future
+				// are not held back, as they would never get old enough.
+				if debug {
+					l.Debugln("too recent:", p, age)
+				}
+				w.deferred.add(rn)
+				return nil
+			}
+
 			var flags = curMode & uint32(maskModePerm)
 			if w.IgnorePerms {
 				flags = protocol.FlagNoPermBits | 0666
@@ -357,6 +411,7 @@ func (w *Walker) walkAndHashFiles(fchan chan protocol.FileInfo) filepath.WalkFun
 			if debug {
 				l.Debugln("to hash:", p, f)
 			}
+			w.Progress.discovered(info.Size())
 			fchan <- f
 		}
 
@@ -9,6 +9,7 @@ package scanner
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -16,6 +17,7 @@ import (
 	rdebug "runtime/debug"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/syncthing/protocol"
 	"github.com/syncthing/syncthing/internal/ignore"
@@ -89,6 +91,107 @@ func TestWalkSub(t *testing.T) {
 	}
 }
 
+func TestWalkProgress(t *testing.T) {
+	ignores := ignore.New(false)
+	err := ignores.Load("testdata/.stignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := Walker{
+		Dir:       "testdata",
+		Subs:      []string{"dir2"},
+		BlockSize: 128 * 1024,
+		Matcher:   ignores,
+		Hashers:   2,
+		Progress:  NewProgressCounter(),
+	}
+	fchan, err := w.Walk()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range fchan {
+	}
+
+	// Only dir2/cfile is hashed; directories aren't counted.
+	expected := ScanProgress{FilesDiscovered: 1, BytesDiscovered: 4, FilesHashed: 1, BytesHashed: 4}
+	if p := w.Progress.Progress(); p != expected {
+		t.Errorf("Incorrect progress %+v != %+v", p, expected)
+	}
+}
+
+func TestWalkMinFileAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	mtimes := map[string]time.Time{
+		"old":    now.Add(-time.Hour),
+		"new":    now.Add(-time.Second),
+		"future": now.Add(time.Hour),
+	}
+	for name, mtime := range mtimes {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := Walker{
+		Dir:        dir,
+		BlockSize:  128 * 1024,
+		Hashers:    2,
+		MinFileAge: time.Minute,
+	}
+	fchan, err := w.Walk()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hashed []string
+	for f := range fchan {
+		hashed = append(hashed, f.Name)
+	}
+	sort.Strings(hashed)
+
+	if !reflect.DeepEqual(hashed, []string{"future", "old"}) {
+		t.Errorf("Incorrect files hashed: %v", hashed)
+	}
+	if deferred := w.Deferred(); !reflect.DeepEqual(deferred, []string{"new"}) {
+		t.Errorf("Incorrect files deferred: %v", deferred)
+	}
+}
+
+func TestHashStableFile(t *testing.T) {
+	fd, err := ioutil.TempFile("", "stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd.WriteString("data")
+	fd.Close()
+	defer os.Remove(fd.Name())
+
+	info, err := os.Stat(fd.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := protocol.FileInfo{Name: filepath.Base(fd.Name()), Modified: info.ModTime().Unix()}
+	if _, err := hashStableFile(fd.Name(), f, nil, 0, nil, nil); err != nil {
+		t.Error(err)
+	}
+
+	// Modified since it was walked.
+	f.Modified--
+	if _, err := hashStableFile(fd.Name(), f, nil, 0, nil, nil); err != errFileChanged {
+		t.Errorf("unexpected error %v for a file changed since the walk", err)
+	}
+}
+
 func TestWalk(t *testing.T) {
 	ignores := ignore.New(false)
 	err := ignores.Load("testdata/.stignore")
@@ -0,0 +1,240 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package schedule evaluates the weekly time windows in the configuration
+// that override bandwidth limits, puller concurrency and pausing.
+package schedule
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/config"
+)
+
+const (
+	minutesPerDay  = 24 * 60
+	minutesPerWeek = 7 * minutesPerDay
+)
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Settings are the overrides in effect at a given time.
+type Settings struct {
+	MaxSendKbps   int // Zero for no override, negative for unlimited.
+	MaxRecvKbps   int // Zero for no override, negative for unlimited.
+	Pullers       int // Zero for no override.
+	PausedFolders map[string]bool
+	PausedDevices map[protocol.DeviceID]bool
+}
+
+// SendKbps returns the send limit in effect, given the global one.
+func (s Settings) SendKbps(global int) int {
+	return override(global, s.MaxSendKbps)
+}
+
+// RecvKbps returns the receive limit in effect, given the global one.
+func (s Settings) RecvKbps(global int) int {
+	return override(global, s.MaxRecvKbps)
+}
+
+func override(global, val int) int {
+	switch {
+	case val > 0:
+		return val
+	case val < 0:
+		return 0
+	default:
+		return global
+	}
+}
+
+func (s Settings) Equal(other Settings) bool {
+	return reflect.DeepEqual(s, other)
+}
+
+// A window is a span of minutes, counted from the start of the week.
+type window struct {
+	start, length int
+}
+
+func (w window) contains(minute int) bool {
+	return (minute-w.start+minutesPerWeek)%minutesPerWeek < w.length
+}
+
+// parse returns the weekly windows described by the schedule.
+func parse(sched config.ScheduleConfiguration) ([]window, error) {
+	start, err := parseClock(sched.Start)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(sched.End)
+	if err != nil {
+		return nil, err
+	}
+	length := (end - start + minutesPerDay) % minutesPerDay
+	if length == 0 {
+		length = minutesPerDay
+	}
+
+	days, err := parseDays(sched.Days)
+	if err != nil {
+		return nil, err
+	}
+
+	windows := make([]window, len(days))
+	for i, day := range days {
+		windows[i] = window{day*minutesPerDay + start, length}
+	}
+	return windows, nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// parseDays parses a comma separated list of weekdays and ranges of
+// weekdays, like "mon-fri,sun". The empty string is every day.
+func parseDays(s string) ([]int, error) {
+	if strings.TrimSpace(s) == "" {
+		return []int{0, 1, 2, 3, 4, 5, 6}, nil
+	}
+
+	seen := make(map[int]bool)
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.SplitN(item, "-", 2)
+		first, err := parseDay(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if len(parts) == 2 {
+			if last, err = parseDay(parts[1]); err != nil {
+				return nil, err
+			}
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			seen[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+
+	days := make([]int, 0, len(seen))
+	for day := range seen {
+		days = append(days, day)
+	}
+	sort.Ints(days)
+	return days, nil
+}
+
+func parseDay(s string) (int, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) >= 3 {
+		for i, day := range weekdays {
+			if strings.HasPrefix(s, day) {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("invalid weekday %q", s)
+}
+
+func minuteOfWeek(t time.Time) int {
+	return int(t.Weekday())*minutesPerDay + t.Hour()*60 + t.Minute()
+}
+
+// Validate returns an error if the schedule can't be parsed.
+func Validate(sched config.ScheduleConfiguration) error {
+	_, err := parse(sched)
+	return err
+}
+
+// Active returns true if the time is within the schedule. Schedules that
+// can't be parsed are never active.
+func Active(sched config.ScheduleConfiguration, t time.Time) bool {
+	windows, err := parse(sched)
+	if err != nil {
+		return false
+	}
+	minute := minuteOfWeek(t)
+	for _, w := range windows {
+		if w.contains(minute) {
+			return true
+		}
+	}
+	return false
+}
+
+// Current returns the settings in effect at the given time. When several
+// schedules are active the later ones take precedence, while pausing adds
+// up.
+func Current(scheds []config.ScheduleConfiguration, t time.Time) Settings {
+	s := Settings{
+		PausedFolders: make(map[string]bool),
+		PausedDevices: make(map[protocol.DeviceID]bool),
+	}
+	for _, sched := range scheds {
+		if !Active(sched, t) {
+			continue
+		}
+		if sched.MaxSendKbps != 0 {
+			s.MaxSendKbps = sched.MaxSendKbps
+		}
+		if sched.MaxRecvKbps != 0 {
+			s.MaxRecvKbps = sched.MaxRecvKbps
+		}
+		if sched.Pullers != 0 {
+			s.Pullers = sched.Pullers
+		}
+		for _, folder := range sched.PauseFolders {
+			s.PausedFolders[folder] = true
+		}
+		for _, device := range sched.PauseDevices {
+			s.PausedDevices[device] = true
+		}
+	}
+	return s
+}
+
+// Next returns the first time after t at which a schedule starts or ends, or
+// the zero time if there are no valid schedules.
+func Next(scheds []config.ScheduleConfiguration, t time.Time) time.Time {
+	minute := minuteOfWeek(t)
+	next := 0
+	for _, sched := range scheds {
+		windows, err := parse(sched)
+		if err != nil {
+			continue
+		}
+		for _, w := range windows {
+			for _, edge := range []int{w.start, w.start + w.length} {
+				delta := (edge - minute + minutesPerWeek) % minutesPerWeek
+				if delta == 0 {
+					delta = minutesPerWeek
+				}
+				if next == 0 || delta < next {
+					next = delta
+				}
+			}
+		}
+	}
+	if next == 0 {
+		return time.Time{}
+	}
+
+	base := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, t.Location())
+	return base.Add(time.Duration(next) * time.Minute)
+}
@@ -0,0 +1,102 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/config"
+)
+
+// 2015-07-06 is a Monday.
+func at(day, hour, min int) time.Time {
+	return time.Date(2015, 7, 5+day, hour, min, 30, 0, time.Local)
+}
+
+func TestActive(t *testing.T) {
+	office := config.ScheduleConfiguration{Days: "mon-fri", Start: "08:00", End: "17:30"}
+	night := config.ScheduleConfiguration{Days: "sat,Sunday", Start: "22:00", End: "06:00"}
+	always := config.ScheduleConfiguration{Start: "00:00", End: "00:00"}
+
+	cases := []struct {
+		sched  config.ScheduleConfiguration
+		t      time.Time
+		active bool
+	}{
+		{office, at(1, 8, 0), true},
+		{office, at(5, 17, 29), true},
+		{office, at(5, 17, 30), false},
+		{office, at(1, 7, 59), false},
+		{office, at(6, 12, 0), false},
+		{night, at(6, 23, 0), true},
+		{night, at(0, 5, 59), true}, // Saturday night
+		{night, at(1, 5, 59), true}, // Sunday night
+		{night, at(2, 5, 59), false},
+		{night, at(0, 6, 0), false},
+		{always, at(3, 12, 0), true},
+		{config.ScheduleConfiguration{Days: "someday", Start: "00:00", End: "01:00"}, at(0, 0, 30), false},
+		{config.ScheduleConfiguration{Start: "25:00", End: "01:00"}, at(0, 0, 30), false},
+	}
+
+	for i, tc := range cases {
+		if active := Active(tc.sched, tc.t); active != tc.active {
+			t.Errorf("%d: %v active %v, expected %v", i, tc.t, active, tc.active)
+		}
+	}
+}
+
+func TestCurrent(t *testing.T) {
+	scheds := []config.ScheduleConfiguration{
+		{Days: "mon-fri", Start: "08:00", End: "18:00", MaxSendKbps: 100, MaxRecvKbps: 200, PauseFolders: []string{"a"}},
+		{Days: "mon", Start: "12:00", End: "13:00", MaxSendKbps: -1, Pullers: 2, PauseDevices: []protocol.DeviceID{protocol.LocalDeviceID}, PauseFolders: []string{"b"}},
+	}
+
+	s := Current(scheds, at(2, 12, 30))
+	if s.SendKbps(50) != 100 || s.RecvKbps(50) != 200 || s.Pullers != 0 || !s.PausedFolders["a"] || len(s.PausedDevices) != 0 {
+		t.Errorf("Incorrect settings on Tuesday %+v", s)
+	}
+
+	s = Current(scheds, at(1, 12, 30))
+	if s.SendKbps(50) != 0 || s.RecvKbps(50) != 200 || s.Pullers != 2 || !s.PausedFolders["a"] || !s.PausedFolders["b"] || !s.PausedDevices[protocol.LocalDeviceID] {
+		t.Errorf("Incorrect settings on Monday %+v", s)
+	}
+
+	s = Current(scheds, at(6, 12, 30))
+	if s.SendKbps(50) != 50 || s.RecvKbps(0) != 0 || !s.Equal(Current(nil, at(6, 12, 30))) {
+		t.Errorf("Incorrect settings on Saturday %+v", s)
+	}
+}
+
+func TestNext(t *testing.T) {
+	scheds := []config.ScheduleConfiguration{
+		{Days: "mon-fri", Start: "08:00", End: "18:00"},
+		{Days: "sat", Start: "22:00", End: "06:00"},
+	}
+
+	cases := []struct {
+		t, next time.Time
+	}{
+		{at(1, 7, 0), at(1, 8, 0)},
+		{at(1, 8, 0), at(1, 18, 0)},
+		{at(5, 18, 0), at(6, 22, 0)},
+		{at(6, 23, 0), at(7, 6, 0)},
+		{at(7, 6, 0), at(8, 8, 0)},
+	}
+
+	for _, tc := range cases {
+		expected := tc.next.Truncate(time.Minute)
+		if next := Next(scheds, tc.t); !next.Equal(expected) {
+			t.Errorf("Next after %v is %v, expected %v", tc.t, next, expected)
+		}
+	}
+
+	if next := Next(nil, at(1, 0, 0)); !next.IsZero() {
+		t.Errorf("Unexpected next change %v without schedules", next)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package schedule
+
+import (
+	"sort"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/events"
+	"github.com/syncthing/syncthing/internal/sync"
+)
+
+// A Scheduler keeps track of the settings in effect by schedule, applying
+// changes at window boundaries. It's a suture.Service.
+type Scheduler struct {
+	scheds  []config.ScheduleConfiguration
+	current Settings
+	changed func(from, to Settings)
+	mut     sync.Mutex
+
+	reload chan struct{}
+	stop   chan struct{}
+}
+
+// NewScheduler returns a Scheduler for the given schedules. The changed
+// function is called from the scheduler's own routine whenever the settings
+// in effect change.
+func NewScheduler(scheds []config.ScheduleConfiguration, changed func(from, to Settings)) *Scheduler {
+	warnInvalid(scheds)
+	return &Scheduler{
+		scheds:  scheds,
+		current: Current(scheds, time.Now()),
+		changed: changed,
+		mut:     sync.NewMutex(),
+		reload:  make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+}
+
+func (s *Scheduler) Serve() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-s.reload:
+		case <-timer.C:
+		}
+
+		s.apply()
+
+		s.mut.Lock()
+		next := Next(s.scheds, time.Now())
+		s.mut.Unlock()
+		if next.IsZero() {
+			// Nothing to do until the schedules change.
+			timer.Stop()
+			continue
+		}
+		if debug {
+			l.Debugln("schedule: next change at", next)
+		}
+		timer.Reset(next.Sub(time.Now()))
+	}
+}
+
+func (s *Scheduler) Stop() {
+	close(s.stop)
+}
+
+func (s *Scheduler) String() string {
+	return "schedule.Scheduler"
+}
+
+// Current returns the settings in effect. The maps in it must not be
+// modified.
+func (s *Scheduler) Current() Settings {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.current
+}
+
+// SetSchedules replaces the schedules. The new settings take effect
+// asynchronously.
+func (s *Scheduler) SetSchedules(scheds []config.ScheduleConfiguration) {
+	warnInvalid(scheds)
+	s.mut.Lock()
+	s.scheds = scheds
+	s.mut.Unlock()
+
+	select {
+	case s.reload <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) apply() {
+	s.mut.Lock()
+	from := s.current
+	to := Current(s.scheds, time.Now())
+	s.current = to
+	s.mut.Unlock()
+
+	if from.Equal(to) {
+		return
+	}
+
+	if debug {
+		l.Debugf("schedule: settings changed from %+v to %+v", from, to)
+	}
+
+	folders := make([]string, 0, len(to.PausedFolders))
+	for folder := range to.PausedFolders {
+		folders = append(folders, folder)
+	}
+	sort.Strings(folders)
+	devices := make([]string, 0, len(to.PausedDevices))
+	for device := range to.PausedDevices {
+		devices = append(devices, device.String())
+	}
+	sort.Strings(devices)
+
+	events.Default.Log(events.ScheduleChanged, map[string]interface{}{
+		"maxSendKbps":   to.MaxSendKbps,
+		"maxRecvKbps":   to.MaxRecvKbps,
+		"pullers":       to.Pullers,
+		"pausedFolders": folders,
+		"pausedDevices": devices,
+	})
+
+	if s.changed != nil {
+		s.changed(from, to)
+	}
+}
+
+func warnInvalid(scheds []config.ScheduleConfiguration) {
+	for _, sched := range scheds {
+		if err := Validate(sched); err != nil {
+			l.Warnf("Ignoring schedule %s %s-%s: %v", sched.Days, sched.Start, sched.End, err)
+		}
+	}
+}
@@ -7,6 +7,7 @@
 package stats
 
 import (
+	"strings"
 	"time"
 
 	"github.com/syncthing/protocol"
@@ -51,6 +52,26 @@ func (s *DeviceStatisticsReference) WasSeen() {
 	s.ns.PutTime("lastSeen", time.Now())
 }
 
+// GetFeatures returns the optional protocol features the device announced
+// when it last connected, and false if it never did.
+func (s *DeviceStatisticsReference) GetFeatures() ([]string, bool) {
+	v, ok := s.ns.String("features")
+	if !ok {
+		return nil, false
+	}
+	if v == "" {
+		return nil, true
+	}
+	return strings.Split(v, ","), true
+}
+
+func (s *DeviceStatisticsReference) SetFeatures(features []string) {
+	if debug {
+		l.Debugln("stats.DeviceStatisticsReference.SetFeatures:", s.device, features)
+	}
+	s.ns.PutString("features", strings.Join(features, ","))
+}
+
 func (s *DeviceStatisticsReference) GetStatistics() DeviceStatistics {
 	return DeviceStatistics{
 		LastSeen: s.GetLastSeen(),
@@ -16,7 +16,8 @@ import (
 )
 
 type FolderStatistics struct {
-	LastFile LastFile `json:"lastFile"`
+	LastFile   LastFile  `json:"lastFile"`
+	LastVerify time.Time `json:"lastVerify"`
 }
 
 type FolderStatisticsReference struct {
@@ -64,8 +65,23 @@ func (s *FolderStatisticsReference) ReceivedFile(file protocol.FileInfo) {
 	s.ns.PutBool("lastFileDeleted", file.IsDeleted())
 }
 
+// GetLastVerify returns when the folder was last verified completely, or
+// the zero time if never.
+func (s *FolderStatisticsReference) GetLastVerify() time.Time {
+	at, _ := s.ns.Time("lastVerifyAt")
+	return at
+}
+
+func (s *FolderStatisticsReference) Verified() {
+	if debug {
+		l.Debugln("stats.FolderStatisticsReference.Verified:", s.folder)
+	}
+	s.ns.PutTime("lastVerifyAt", time.Now())
+}
+
 func (s *FolderStatisticsReference) GetStatistics() FolderStatistics {
 	return FolderStatistics{
-		LastFile: s.GetLastFile(),
+		LastFile:   s.GetLastFile(),
+		LastVerify: s.GetLastVerify(),
 	}
 }
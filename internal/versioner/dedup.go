@@ -0,0 +1,539 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package versioner
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/osutil"
+	"github.com/syncthing/syncthing/internal/scanner"
+	"github.com/syncthing/syncthing/internal/symlinks"
+	"github.com/syncthing/syncthing/internal/sync"
+)
+
+func init() {
+	// Register the constructor for this type of versioner with the name "dedup"
+	Factories["dedup"] = NewDedup
+}
+
+// The dedup versioner keeps the archived versions as lists of blocks, in
+// files named like the staggered versions. The blocks themselves are kept
+// once in a content addressed store in the .blocks directory of the archive,
+// named by their hash. Blocks that no version refers to any more are removed
+// when cleaning, which also expires versions like the staggered versioner.
+const dedupBlocksDir = ".blocks"
+
+var errBlockMismatch = errors.New("file changed while archiving")
+
+// An IndexedVersioner can make use of the files in the index, to avoid
+// hashing files it archives.
+type IndexedVersioner interface {
+	SetIndex(lookup func(name string) (protocol.FileInfo, bool))
+}
+
+type Dedup struct {
+	folderPath    string
+	versionsPath  string
+	cleanInterval time.Duration
+	staggered     Staggered // for the expiry rules
+	retention     retention
+	index         func(name string) (protocol.FileInfo, bool)
+	mutex         sync.Mutex
+	stop          chan struct{}
+}
+
+// A dedupVersion is what's kept of an archived version.
+type dedupVersion struct {
+	Size     int64        `json:"size"`
+	Modified int64        `json:"modified"`
+	Mode     uint32       `json:"mode"`
+	Target   string       `json:"target,omitempty"` // for symlinks
+	Flags    uint32       `json:"flags,omitempty"`  // of the symlink target
+	Blocks   []dedupBlock `json:"blocks"`
+}
+
+type dedupBlock struct {
+	Hash string `json:"hash"`
+	Size int32  `json:"size"`
+}
+
+func NewDedup(folderID, folderPath string, params map[string]string) Versioner {
+	maxAge, err := strconv.ParseInt(params["maxAge"], 10, 0)
+	if err != nil {
+		maxAge = 31536000 // Default: ~1 year
+	}
+	cleanInterval, err := strconv.ParseInt(params["cleanInterval"], 10, 0)
+	if err != nil {
+		cleanInterval = 3600 // Default: clean once per hour
+	}
+
+	versionsPath := filepath.Join(folderPath, ".stversions")
+	v := &Dedup{
+		folderPath:    folderPath,
+		versionsPath:  versionsPath,
+		cleanInterval: time.Duration(cleanInterval) * time.Second,
+		staggered: Staggered{
+			versionsPath: versionsPath,
+			folderPath:   folderPath,
+			interval:     staggeredIntervals(maxAge),
+		},
+		retention: newRetention(params),
+		mutex:     sync.NewMutex(),
+		stop:      make(chan struct{}),
+	}
+
+	if debug {
+		l.Debugf("instantiated %#v", v)
+	}
+	return v
+}
+
+// SetIndex sets the lookup of the files in the index, whose blocks are used
+// when the file to archive is unchanged since it was indexed.
+func (v *Dedup) SetIndex(lookup func(name string) (protocol.FileInfo, bool)) {
+	v.index = lookup
+}
+
+func (v *Dedup) Serve() {
+	if debug {
+		l.Debugln(v, "starting")
+		defer l.Debugln(v, "stopping")
+	}
+
+	timer := time.NewTimer(time.Minute)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-v.stop:
+			return
+
+		case <-timer.C:
+			if err := v.clean(); err != nil {
+				l.Infoln("Cleaning versions:", err)
+			}
+			timer.Reset(v.cleanInterval)
+		}
+	}
+}
+
+func (v *Dedup) Stop() {
+	close(v.stop)
+}
+
+func (v *Dedup) String() string {
+	return fmt.Sprintf("dedup@%p", v)
+}
+
+// Archive moves the named file away to a version archive. If this function
+// returns nil, the named file does not exist any more (has been archived).
+func (v *Dedup) Archive(filePath string) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	return v.archive(filePath)
+}
+
+func (v *Dedup) archive(filePath string) error {
+	info, err := osutil.Lstat(filePath)
+	if os.IsNotExist(err) {
+		if debug {
+			l.Debugln("not archiving nonexistent file", filePath)
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	name, err := filepath.Rel(v.folderPath, filePath)
+	if err != nil {
+		return err
+	}
+
+	if debug {
+		l.Debugln("archiving", filePath)
+	}
+
+	version := dedupVersion{
+		Size:     info.Size(),
+		Modified: info.ModTime().Unix(),
+		Mode:     uint32(info.Mode() & os.ModePerm),
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		version.Target, version.Flags, err = symlinks.Read(filePath)
+		if err != nil {
+			return err
+		}
+	} else {
+		blocks, err := v.storeBlocks(name, filePath, info)
+		if err == errBlockMismatch {
+			// The index is out of date; hash the file ourselves.
+			blocks, err = v.storeBlocks("", filePath, info)
+		}
+		if err != nil {
+			return err
+		}
+		for _, b := range blocks {
+			version.Blocks = append(version.Blocks, dedupBlock{
+				Hash: hex.EncodeToString(b.Hash),
+				Size: b.Size,
+			})
+		}
+	}
+
+	dir := filepath.Join(v.versionsPath, filepath.Dir(name))
+	if err := osutil.MkdirAll(dir, 0755); err != nil && !os.IsExist(err) {
+		return err
+	}
+	osutil.HideFile(v.versionsPath)
+
+	bs, err := json.Marshal(version)
+	if err != nil {
+		return err
+	}
+	dst := filepath.Join(dir, taggedFilename(filepath.Base(name), time.Now().Format(TimeFormat)))
+	if debug {
+		l.Debugln("writing block list to", dst)
+	}
+	if err := writeFileAtomic(dst, bs); err != nil {
+		return err
+	}
+
+	if err := os.Remove(filePath); err != nil {
+		os.Remove(dst)
+		return err
+	}
+
+	versions, err := osutil.Glob(filepath.Join(dir, taggedFilename(filepath.Base(name), TimeGlob)))
+	if err != nil {
+		l.Warnln("globbing:", err)
+		return nil
+	}
+	v.staggered.expire(uniqueSortedStrings(versions))
+
+	return nil
+}
+
+// storeBlocks adds the blocks of the file to the store and returns them. The
+// blocks of the named file in the index are used if it is unchanged since it
+// was indexed; blocks that are already in the store are then not read.
+func (v *Dedup) storeBlocks(name, filePath string, info os.FileInfo) ([]protocol.BlockInfo, error) {
+	var blocks []protocol.BlockInfo
+	if name != "" && v.index != nil {
+		if f, ok := v.index(name); ok && !f.IsDeleted() && !f.IsInvalid() && f.Size() == info.Size() && f.Modified == info.ModTime().Unix() {
+			blocks = f.Blocks
+		}
+	}
+
+	if blocks == nil {
+		var err error
+		blocks, err = scanner.HashFile(filePath, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fd, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var offset int64
+	for _, b := range blocks {
+		path := v.blockPath(b.Hash)
+		if _, err := os.Stat(path); err == nil {
+			offset += int64(b.Size)
+			continue
+		}
+
+		buf := make([]byte, b.Size)
+		if _, err := fd.ReadAt(buf, offset); err != nil {
+			return nil, err
+		}
+		if hash := sha256.Sum256(buf); !bytes.Equal(hash[:], b.Hash) {
+			return nil, errBlockMismatch
+		}
+
+		if err := osutil.MkdirAll(filepath.Dir(path), 0755); err != nil && !os.IsExist(err) {
+			return nil, err
+		}
+		if err := writeFileAtomic(path, buf); err != nil {
+			return nil, err
+		}
+		offset += int64(b.Size)
+	}
+
+	return blocks, nil
+}
+
+func (v *Dedup) blockPath(hash []byte) string {
+	h := hex.EncodeToString(hash)
+	return filepath.Join(v.versionsPath, dedupBlocksDir, h[:2], h[2:])
+}
+
+// Versions returns the archived versions of the files whose names start with
+// the given prefix. The version time of a version is the time it was
+// archived.
+func (v *Dedup) Versions(prefix string) (map[string][]FileVersion, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	versions, err := taggedVersions(v.versionsPath, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string][]FileVersion, len(versions))
+	for name, vs := range versions {
+		for _, av := range vs {
+			version, err := readDedupVersion(av.path)
+			if err != nil {
+				continue
+			}
+			res[name] = append(res[name], FileVersion{
+				VersionTime: av.VersionTime,
+				ModTime:     time.Unix(version.Modified, 0),
+				Size:        version.Size,
+			})
+		}
+	}
+	return res, nil
+}
+
+// Restore replaces the named file with the given archived version.
+func (v *Dedup) Restore(name string, versionTime time.Time) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	versions, err := taggedVersions(v.versionsPath, name)
+	if err != nil {
+		return err
+	}
+	path, err := findVersion(versions, name, versionTime)
+	if err != nil {
+		return err
+	}
+	version, err := readDedupVersion(path)
+	if err != nil {
+		return err
+	}
+
+	// The block list is kept aside while restoring, as archiving the
+	// current file could otherwise replace it.
+	held := path + ".restoring"
+	if err := osutil.TryRename(path, held); err != nil {
+		return err
+	}
+
+	tmp := path + ".data.restoring"
+	err = v.assemble(version, tmp)
+	if err == nil {
+		err = replaceFile(v.folderPath, name, tmp, v.archive)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		osutil.TryRename(held, path)
+		return err
+	}
+
+	// The version has been moved out of the archive, like with the other
+	// versioners. Its blocks go away on the next cleaning unless used by
+	// other versions.
+	os.Remove(held)
+
+	if debug {
+		l.Debugln("restored", path, "to", name)
+	}
+	return nil
+}
+
+// assemble writes the archived version to the given path.
+func (v *Dedup) assemble(version dedupVersion, path string) error {
+	if version.Target != "" {
+		return symlinks.Create(path, version.Target, version.Flags)
+	}
+
+	fd, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, os.FileMode(version.Mode)|0600)
+	if err != nil {
+		return err
+	}
+	for _, b := range version.Blocks {
+		hash, err := hex.DecodeString(b.Hash)
+		if err != nil {
+			fd.Close()
+			return err
+		}
+		buf, err := ioutil.ReadFile(v.blockPath(hash))
+		if err != nil {
+			fd.Close()
+			return err
+		}
+		if _, err := scanner.VerifyBuffer(buf, protocol.BlockInfo{Size: b.Size, Hash: hash}); err != nil {
+			fd.Close()
+			return err
+		}
+		if _, err := fd.Write(buf); err != nil {
+			fd.Close()
+			return err
+		}
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+
+	t := time.Unix(version.Modified, 0)
+	return os.Chtimes(path, t, t)
+}
+
+// clean expires versions according to the staggered intervals and removes
+// the blocks that no remaining version refers to.
+func (v *Dedup) clean() error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if _, err := osutil.Lstat(v.versionsPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	versions, err := taggedVersions(v.versionsPath, "")
+	if err != nil {
+		return err
+	}
+	for _, vs := range versions {
+		paths := make([]string, len(vs))
+		for i, av := range vs {
+			paths[i] = av.path
+		}
+		v.staggered.expire(uniqueSortedStrings(paths))
+	}
+
+	// Count the references to each block from the versions that are left.
+	versions, err = taggedVersions(v.versionsPath, "")
+	if err != nil {
+		return err
+	}
+	refs := make(map[string]int)
+	manifests := make(map[string]dedupVersion)
+	for _, vs := range versions {
+		for _, av := range vs {
+			version, err := readDedupVersion(av.path)
+			if err != nil {
+				// We can't tell what blocks it uses, so we can't tell
+				// which blocks are unused.
+				return fmt.Errorf("reading %s: %v", av.path, err)
+			}
+			for _, b := range version.Blocks {
+				refs[b.Hash]++
+			}
+			manifests[av.path] = version
+		}
+	}
+
+	if v.retention.active() {
+		// Removing a version frees its manifest and the blocks that no
+		// other version uses.
+		v.retention.apply(versions, diskUsage(v.versionsPath), func(av archivedVersion) (int64, error) {
+			if err := os.Remove(av.path); err != nil {
+				return 0, err
+			}
+			freed := av.Size
+			for _, b := range manifests[av.path].Blocks {
+				refs[b.Hash]--
+				if refs[b.Hash] > 0 {
+					continue
+				}
+				hash, err := hex.DecodeString(b.Hash)
+				if err != nil {
+					continue
+				}
+				if err := os.Remove(v.blockPath(hash)); err == nil {
+					freed += int64(b.Size)
+				}
+			}
+			return freed, nil
+		})
+	}
+
+	blocksPath := filepath.Join(v.versionsPath, dedupBlocksDir)
+	if _, err := osutil.Lstat(blocksPath); os.IsNotExist(err) {
+		return nil
+	}
+	removed := 0
+	err = filepath.Walk(blocksPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(blocksPath, path)
+		if err != nil {
+			return err
+		}
+		if refs[strings.Replace(rel, string(filepath.Separator), "", -1)] == 0 {
+			if err := os.Remove(path); err != nil {
+				l.Warnln("Versioner: can't remove block:", err)
+			}
+			removed++
+		}
+		return nil
+	})
+
+	if debug {
+		l.Debugf("%v: removed %d unused blocks", v, removed)
+	}
+	return err
+}
+
+// ArchiveStats returns the number of archived versions and the disk space
+// used by them and their blocks.
+func (v *Dedup) ArchiveStats() (ArchiveStats, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	versions, err := taggedVersions(v.versionsPath, "")
+	if err != nil {
+		return ArchiveStats{}, err
+	}
+	stats := ArchiveStats{Size: diskUsage(v.versionsPath)}
+	for _, vs := range versions {
+		stats.Files += len(vs)
+	}
+	return stats, nil
+}
+
+func readDedupVersion(path string) (dedupVersion, error) {
+	var version dedupVersion
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return version, err
+	}
+	err = json.Unmarshal(bs, &version)
+	return version, err
+}
+
+// writeFileAtomic writes the data to a temporary file that is then renamed
+// into place, so that a file is never seen half written.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return osutil.Rename(tmp, path)
+}
@@ -0,0 +1,146 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package versioner
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/syncthing/protocol"
+	"github.com/syncthing/syncthing/internal/scanner"
+)
+
+func countBlocks(t *testing.T, dir string) int {
+	n := 0
+	filepath.Walk(filepath.Join(dir, ".stversions", dedupBlocksDir), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			n++
+		}
+		return nil
+	})
+	return n
+}
+
+func TestDedupArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	v := NewDedup("", dir, map[string]string{}).(*Dedup)
+
+	// Two files sharing all but their last block.
+	common := bytes.Repeat([]byte{1}, 2*protocol.BlockSize)
+	data1 := append(append([]byte{}, common...), []byte("one")...)
+	data2 := append(append([]byte{}, common...), []byte("two")...)
+
+	path1 := filepath.Join(dir, "file1")
+	path2 := filepath.Join(dir, "file2")
+	if err := ioutil.WriteFile(path1, data1, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path2, data2, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The index is used for the first file.
+	indexed := 0
+	v.SetIndex(func(name string) (protocol.FileInfo, bool) {
+		if name != "file1" {
+			return protocol.FileInfo{}, false
+		}
+		indexed++
+		info, _ := os.Stat(path1)
+		blocks, _ := scanner.HashFile(path1, 0)
+		return protocol.FileInfo{Name: name, Modified: info.ModTime().Unix(), Blocks: blocks}, true
+	})
+
+	if err := v.Archive(path1); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Archive(path2); err != nil {
+		t.Fatal(err)
+	}
+	if indexed != 1 {
+		t.Errorf("index used %d times, expected once", indexed)
+	}
+	if _, err := os.Stat(path1); !os.IsNotExist(err) {
+		t.Error("archived file still exists")
+	}
+
+	// One block is common; the last blocks differ.
+	if n := countBlocks(t, dir); n != 3 {
+		t.Errorf("%d blocks stored, expected 3", n)
+	}
+
+	versions, err := v.Versions("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions["file1"]) != 1 || versions["file1"][0].Size != int64(len(data1)) {
+		t.Fatalf("unexpected versions %v", versions)
+	}
+
+	if err := v.Restore("file1", versions["file1"][0].VersionTime); err != nil {
+		t.Fatal(err)
+	}
+	if bs, _ := ioutil.ReadFile(path1); !bytes.Equal(bs, data1) {
+		t.Error("restored data mismatch")
+	}
+
+	// Cleaning removes the blocks only used by the restored version.
+	if err := v.clean(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countBlocks(t, dir); n != 2 {
+		t.Errorf("%d blocks left, expected 2", n)
+	}
+	versions, _ = v.Versions("")
+	if bs, _ := ioutil.ReadFile(path2); len(bs) != 0 || len(versions["file2"]) != 1 {
+		t.Error("unexpected change to the other version")
+	}
+}
+
+func TestDedupExpiry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	v := NewDedup("", dir, map[string]string{"maxAge": "86400"}).(*Dedup)
+
+	path := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Archive(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Make the version older than the max age.
+	versions, _ := taggedVersions(v.versionsPath, "")
+	old := filepath.Join(v.versionsPath, taggedFilename("file", time.Now().Add(-48*time.Hour).Format(TimeFormat)))
+	if err := os.Rename(versions["file"][0].path, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v.clean(); err != nil {
+		t.Fatal(err)
+	}
+	if versions, _ := v.Versions(""); len(versions) != 0 {
+		t.Errorf("unexpected versions %v after expiry", versions)
+	}
+	if n := countBlocks(t, dir); n != 0 {
+		t.Errorf("%d blocks left, expected none", n)
+	}
+}
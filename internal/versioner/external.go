@@ -7,11 +7,16 @@
 package versioner
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/syncthing/syncthing/internal/osutil"
 )
@@ -21,16 +26,47 @@ func init() {
 	Factories["external"] = NewExternal
 }
 
+// The command of the external versioner is run for each file to archive.
+// Its arguments and the values of the extra environment variables, given by
+// the "env.NAME" parameters, may refer to %FOLDER_ID%, %FOLDER_PATH% and
+// %FILE_PATH%, the latter being relative to the folder. A command without
+// any of them is run with the folder path and file path as arguments. The
+// command is killed if it runs for longer than the timeout, in seconds.
 type External struct {
 	command    string
+	env        map[string]string
+	timeout    time.Duration
+	folderID   string
 	folderPath string
 }
 
+// The maximum length of the command's error output kept in the error.
+const maxExternalStderr = 1024
+
+var errExternalTimeout = errors.New("command timed out")
+
+var externalTemplates = []string{"%FOLDER_ID%", "%FOLDER_PATH%", "%FILE_PATH%"}
+
 func NewExternal(folderID, folderPath string, params map[string]string) Versioner {
 	command := params["command"]
 
+	env := make(map[string]string)
+	for key, val := range params {
+		if strings.HasPrefix(key, "env.") && len(key) > len("env.") {
+			env[key[len("env."):]] = val
+		}
+	}
+
+	timeout, err := strconv.Atoi(params["timeout"])
+	if err != nil || timeout < 0 {
+		timeout = 0 // Default: no timeout
+	}
+
 	s := External{
 		command:    command,
+		env:        env,
+		timeout:    time.Duration(timeout) * time.Second,
+		folderID:   folderID,
 		folderPath: folderPath,
 	}
 
@@ -66,7 +102,26 @@ func (v External) Archive(filePath string) error {
 		return errors.New("Versioner: command is empty, please enter a valid command")
 	}
 
-	cmd := exec.Command(v.command, v.folderPath, inFolderPath)
+	replacer := strings.NewReplacer(
+		"%FOLDER_ID%", v.folderID,
+		"%FOLDER_PATH%", v.folderPath,
+		"%FILE_PATH%", inFolderPath,
+	)
+
+	var cmd *exec.Cmd
+	if hasTemplate(v.command) {
+		words, err := splitCommandLine(v.command)
+		if err != nil {
+			return fmt.Errorf("Versioner: %v", err)
+		}
+		for i := range words {
+			words[i] = replacer.Replace(words[i])
+		}
+		cmd = exec.Command(words[0], words[1:]...)
+	} else {
+		cmd = exec.Command(v.command, v.folderPath, inFolderPath)
+	}
+
 	env := os.Environ()
 	// filter STGUIAUTH and STGUIAPIKEY from environment variables
 	filteredEnv := []string{}
@@ -75,10 +130,23 @@ func (v External) Archive(filePath string) error {
 			filteredEnv = append(filteredEnv, x)
 		}
 	}
+	for key, val := range v.env {
+		filteredEnv = append(filteredEnv, key+"="+replacer.Replace(val))
+	}
 	cmd.Env = filteredEnv
-	err = cmd.Run()
-	if err != nil {
-		return err
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := v.run(cmd); err == errExternalTimeout {
+		return fmt.Errorf("Versioner: %v after %v", err, v.timeout)
+	} else if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			if len(msg) > maxExternalStderr {
+				msg = msg[:maxExternalStderr] + "..."
+			}
+			return fmt.Errorf("Versioner: %v: %s", err, msg)
+		}
+		return fmt.Errorf("Versioner: %v", err)
 	}
 
 	// return error if the file was not removed
@@ -87,3 +155,86 @@ func (v External) Archive(filePath string) error {
 	}
 	return errors.New("Versioner: file was not removed by external script")
 }
+
+// run runs the command, killing it if it doesn't finish within the timeout.
+// The output of a command that timed out may still be written to afterwards.
+func (v External) run(cmd *exec.Cmd) error {
+	if v.timeout <= 0 {
+		return cmd.Run()
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(v.timeout):
+		// Children of the command may keep its output open, so we don't
+		// wait for it to be closed.
+		cmd.Process.Kill()
+		return errExternalTimeout
+	}
+}
+
+func hasTemplate(s string) bool {
+	for _, t := range externalTemplates {
+		if strings.Contains(s, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitCommandLine splits the command line into words separated by spaces.
+// Single or double quotes group words containing spaces.
+func splitCommandLine(line string) ([]string, error) {
+	var words []string
+	var word []rune
+	var quote rune
+	inWord := false
+	for _, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+			inWord = true
+		case quote == 0 && unicode.IsSpace(r):
+			if inWord {
+				words = append(words, string(word))
+				word = word[:0]
+				inWord = false
+			}
+		default:
+			word = append(word, r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote in command")
+	}
+	if inWord {
+		words = append(words, string(word))
+	}
+	if len(words) == 0 {
+		return nil, errors.New("command is empty")
+	}
+	return words, nil
+}
+
+// Versions is not supported by the external versioner, as the archive is
+// up to the command.
+func (v External) Versions(prefix string) (map[string][]FileVersion, error) {
+	return nil, ErrRestoreNotSupported
+}
+
+// Restore is not supported by the external versioner.
+func (v External) Restore(name string, versionTime time.Time) error {
+	return ErrRestoreNotSupported
+}
@@ -0,0 +1,106 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build !windows
+
+package versioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	cases := []struct {
+		line  string
+		words []string
+	}{
+		{"cmd", []string{"cmd"}},
+		{"  cmd  a b ", []string{"cmd", "a", "b"}},
+		{`cmd "a b" c"d e"`, []string{"cmd", "a b", "cd e"}},
+		{`cmd ""`, []string{"cmd", ""}},
+		{`cmd 'a "b"' "c 'd'"`, []string{"cmd", `a "b"`, "c 'd'"}},
+	}
+	for _, tc := range cases {
+		words, err := splitCommandLine(tc.line)
+		if err != nil {
+			t.Errorf("%q: %v", tc.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(words, tc.words) {
+			t.Errorf("%q: got %q, expected %q", tc.line, words, tc.words)
+		}
+	}
+
+	for _, line := range []string{"", "  ", `cmd "a`} {
+		if _, err := splitCommandLine(line); err == nil {
+			t.Errorf("%q: unexpected nil error", line)
+		}
+	}
+}
+
+func TestExternalArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archive := filepath.Join(dir, "archive")
+	if err := os.Mkdir(archive, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "file")
+
+	cases := []struct {
+		params map[string]string
+		err    string // expected in the error, if any
+	}{
+		{
+			params: map[string]string{
+				"command":     `/bin/sh -c 'mv "$1/$2" "$DEST/$3"' - %FOLDER_PATH% %FILE_PATH% %FOLDER_ID%`,
+				"env.DEST":    archive,
+				"env.STEXTRA": "%FOLDER_ID%",
+			},
+		},
+		{
+			params: map[string]string{"command": `/bin/sh -c "echo failed >&2; exit 1" %FILE_PATH%`},
+			err:    "failed",
+		},
+		{
+			params: map[string]string{"command": `/bin/true %FILE_PATH%`},
+			err:    "not removed",
+		},
+		{
+			params: map[string]string{"command": `/bin/sh -c "sleep 10" %FILE_PATH%`, "timeout": "1"},
+			err:    "timed out",
+		},
+	}
+
+	for i, tc := range cases {
+		if err := ioutil.WriteFile(path, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		v := NewExternal("folder", dir, tc.params)
+		err := v.Archive(path)
+		if tc.err == "" {
+			if err != nil {
+				t.Errorf("%d: unexpected error: %v", i, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%d: error %v, expected %q", i, err, tc.err)
+		}
+	}
+
+	if bs, err := ioutil.ReadFile(filepath.Join(archive, "folder")); err != nil || string(bs) != "data" {
+		t.Errorf("file not archived by the templated command: %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package versioner
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ArchiveStats describes the archive of a versioner.
+type ArchiveStats struct {
+	Files int   `json:"files"` // The number of archived versions
+	Size  int64 `json:"size"`  // The disk space used by the archive
+}
+
+// A StatsVersioner can report on the size of its archive.
+type StatsVersioner interface {
+	ArchiveStats() (ArchiveStats, error)
+}
+
+// The retention rules are common to the built-in versioners, in addition to
+// their own rules. They are given by the parameters maxAge, in seconds,
+// maxVersions, per file, and maxSizeMiB, for the whole archive. Zero or
+// unset means no limit.
+type retention struct {
+	maxAge      time.Duration
+	maxVersions int
+	maxSize     int64
+}
+
+func newRetention(params map[string]string) retention {
+	var r retention
+	if secs, err := strconv.ParseInt(params["maxAge"], 10, 64); err == nil && secs > 0 {
+		r.maxAge = time.Duration(secs) * time.Second
+	}
+	if n, err := strconv.Atoi(params["maxVersions"]); err == nil && n > 0 {
+		r.maxVersions = n
+	}
+	if mib, err := strconv.ParseInt(params["maxSizeMiB"], 10, 64); err == nil && mib > 0 {
+		r.maxSize = mib << 20
+	}
+	return r
+}
+
+func (r retention) active() bool {
+	return r.maxAge > 0 || r.maxVersions > 0 || r.maxSize > 0
+}
+
+// apply removes the versions older than the maximum age and the oldest
+// versions of files with more than the maximum number of versions. Then the
+// oldest versions overall are removed until the archive, of the given total
+// size, is within the maximum size. Removing a version returns the number of
+// bytes freed.
+func (r retention) apply(versions map[string][]archivedVersion, total int64, remove func(archivedVersion) (int64, error)) {
+	now := time.Now()
+	var kept []archivedVersion
+
+	evict := func(av archivedVersion) {
+		if debug {
+			l.Debugln("retention: removing", av.path)
+		}
+		freed, err := remove(av)
+		if err != nil {
+			l.Warnln("Versioner: removing old version:", err)
+			return
+		}
+		total -= freed
+	}
+
+	for _, vs := range versions {
+		// Versions are sorted oldest first.
+		for i, av := range vs {
+			tooOld := r.maxAge > 0 && now.Sub(av.VersionTime) > r.maxAge
+			tooMany := r.maxVersions > 0 && len(vs)-i > r.maxVersions
+			if tooOld || tooMany {
+				evict(av)
+				continue
+			}
+			kept = append(kept, av)
+		}
+	}
+
+	if r.maxSize <= 0 || total <= r.maxSize {
+		return
+	}
+
+	sort.Sort(versionsByTime(kept))
+	for _, av := range kept {
+		if total <= r.maxSize {
+			break
+		}
+		evict(av)
+	}
+}
+
+// removeVersion removes a plain file version, returning its size.
+func removeVersion(av archivedVersion) (int64, error) {
+	if err := os.Remove(av.path); err != nil {
+		return 0, err
+	}
+	return av.Size, nil
+}
+
+// plainStats returns the stats of an archive of plain file versions.
+func plainStats(versions map[string][]archivedVersion) ArchiveStats {
+	var stats ArchiveStats
+	for _, vs := range versions {
+		for _, av := range vs {
+			stats.Files++
+			stats.Size += av.Size
+		}
+	}
+	return stats
+}
+
+// diskUsage returns the total size of the files under the directory.
+func diskUsage(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// removeEmptyDirs removes the empty directories under the given one, which
+// itself is kept.
+func removeEmptyDirs(root string) {
+	var dirs []string
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() && path != root {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	// Deepest first, so that directories emptied by removing their
+	// subdirectories go as well. Removing a directory that isn't empty
+	// fails, which is fine.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package versioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetentionApply(t *testing.T) {
+	now := time.Now()
+	version := func(path string, age time.Duration, size int64) archivedVersion {
+		return archivedVersion{
+			FileVersion: FileVersion{VersionTime: now.Add(-age), Size: size},
+			path:        path,
+		}
+	}
+
+	cases := []struct {
+		r       retention
+		removed []string
+	}{
+		{retention{}, nil},
+		{retention{maxAge: 90 * time.Minute}, []string{"a1", "b1", "b2"}},
+		{retention{maxVersions: 1}, []string{"a1", "b1", "b2"}},
+		{retention{maxVersions: 2}, []string{"b1"}},
+		// 60 bytes in total; the oldest go first until within 35.
+		{retention{maxSize: 35}, []string{"b1", "a1", "b2"}},
+		{retention{maxVersions: 2, maxSize: 20}, []string{"b1", "a1", "b2", "a2"}},
+	}
+
+	for i, tc := range cases {
+		versions := map[string][]archivedVersion{
+			"a": {version("a1", 2*time.Hour, 10), version("a2", time.Hour, 10)},
+			"b": {version("b1", 3*time.Hour, 10), version("b2", 2*time.Hour-time.Minute, 10), version("b3", time.Minute, 20)},
+		}
+
+		var removed []string
+		tc.r.apply(versions, 60, func(av archivedVersion) (int64, error) {
+			removed = append(removed, av.path)
+			return av.Size, nil
+		})
+
+		if len(removed) != len(tc.removed) {
+			t.Errorf("%d: removed %v, expected %v", i, removed, tc.removed)
+			continue
+		}
+		// Age and count removals happen in map order; compare as sets.
+		seen := make(map[string]bool)
+		for _, p := range removed {
+			seen[p] = true
+		}
+		for _, p := range tc.removed {
+			if !seen[p] {
+				t.Errorf("%d: removed %v, expected %v", i, removed, tc.removed)
+				break
+			}
+		}
+	}
+}
+
+func TestTrashcanRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	v := NewTrashcan("", dir, map[string]string{"maxSizeMiB": "1"}).(*Trashcan)
+
+	// Two files of 768 KiB each; only the newer one fits.
+	data := make([]byte, 768<<10)
+	for i, name := range []string{"old", filepath.Join("sub", "new")} {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.Archive(path); err != nil {
+			t.Fatal(err)
+		}
+		// The trash can times versions by when they were archived.
+		mtime := time.Now().Add(time.Duration(i-2) * time.Hour)
+		if err := os.Chtimes(filepath.Join(dir, ".stversions", name), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := v.ArchiveStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Files != 2 || stats.Size != 2*int64(len(data)) {
+		t.Errorf("unexpected stats %+v before retention", stats)
+	}
+
+	if err := v.applyRetention(); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := v.Versions("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || len(versions[filepath.Join("sub", "new")]) != 1 {
+		t.Errorf("unexpected versions %v after retention", versions)
+	}
+	if stats, _ := v.ArchiveStats(); stats.Files != 1 {
+		t.Errorf("unexpected stats %+v after retention", stats)
+	}
+}
+
+func TestSimpleRetentionArchiveTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	v := NewSimple("", dir, map[string]string{"maxAge": "3600"}).(*Simple)
+
+	// A file last modified long ago is archived now, so it's kept.
+	path := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(path, []byte("old data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Archive(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v.applyRetention(); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := v.Versions("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions["file"]) != 1 {
+		t.Fatalf("unexpected versions %v after retention", versions)
+	}
+	if fv := versions["file"][0]; !fv.ModTime.Equal(mtime) || time.Since(fv.VersionTime) > time.Minute {
+		t.Errorf("unexpected version %+v", fv)
+	}
+}
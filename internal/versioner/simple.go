@@ -7,9 +7,11 @@
 package versioner
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/syncthing/syncthing/internal/osutil"
 )
@@ -22,6 +24,8 @@ func init() {
 type Simple struct {
 	keep       int
 	folderPath string
+	retention  retention
+	stop       chan struct{}
 }
 
 func NewSimple(folderID, folderPath string, params map[string]string) Versioner {
@@ -29,10 +33,16 @@ func NewSimple(folderID, folderPath string, params map[string]string) Versioner
 	if err != nil {
 		keep = 5 // A reasonable default
 	}
+	retention := newRetention(params)
+	if retention.maxVersions > 0 && retention.maxVersions < keep {
+		keep = retention.maxVersions
+	}
 
-	s := Simple{
+	s := &Simple{
 		keep:       keep,
 		folderPath: folderPath,
+		retention:  retention,
+		stop:       make(chan struct{}),
 	}
 
 	if debug {
@@ -43,8 +53,8 @@ func NewSimple(folderID, folderPath string, params map[string]string) Versioner
 
 // Archive moves the named file away to a version archive. If this function
 // returns nil, the named file does not exist any more (has been archived).
-func (v Simple) Archive(filePath string) error {
-	fileInfo, err := osutil.Lstat(filePath)
+func (v *Simple) Archive(filePath string) error {
+	_, err := osutil.Lstat(filePath)
 	if os.IsNotExist(err) {
 		if debug {
 			l.Debugln("not archiving nonexistent file", filePath)
@@ -84,7 +94,9 @@ func (v Simple) Archive(filePath string) error {
 		return err
 	}
 
-	ver := taggedFilename(file, fileInfo.ModTime().Format(TimeFormat))
+	// Versions are tagged with the time they were archived, which is what
+	// the retention rules go by. The archived file keeps its own mtime.
+	ver := taggedFilename(file, time.Now().Format(TimeFormat))
 	dst := filepath.Join(dir, ver)
 	if debug {
 		l.Debugln("moving to", dst)
@@ -126,3 +138,82 @@ func (v Simple) Archive(filePath string) error {
 
 	return nil
 }
+
+func (v *Simple) Serve() {
+	if debug {
+		l.Debugln(v, "starting")
+		defer l.Debugln(v, "stopping")
+	}
+
+	// The number of versions is limited when archiving; the other rules
+	// are applied once an hour.
+	timer := time.NewTimer(time.Minute)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-v.stop:
+			return
+
+		case <-timer.C:
+			if v.retention.maxAge > 0 || v.retention.maxSize > 0 {
+				if err := v.applyRetention(); err != nil {
+					l.Infoln("Cleaning versions:", err)
+				}
+			}
+			timer.Reset(time.Hour)
+		}
+	}
+}
+
+func (v *Simple) Stop() {
+	close(v.stop)
+}
+
+func (v *Simple) String() string {
+	return fmt.Sprintf("simple@%p", v)
+}
+
+func (v *Simple) applyRetention() error {
+	versionsDir := filepath.Join(v.folderPath, ".stversions")
+	versions, err := taggedVersions(versionsDir, "")
+	if err != nil {
+		return err
+	}
+	v.retention.apply(versions, plainStats(versions).Size, removeVersion)
+	removeEmptyDirs(versionsDir)
+	return nil
+}
+
+// ArchiveStats returns the number and size of the archived versions.
+func (v *Simple) ArchiveStats() (ArchiveStats, error) {
+	versions, err := taggedVersions(filepath.Join(v.folderPath, ".stversions"), "")
+	if err != nil {
+		return ArchiveStats{}, err
+	}
+	return plainStats(versions), nil
+}
+
+// Versions returns the archived versions of the files whose names start with
+// the given prefix. The version time of a simple version is when it was
+// archived.
+func (v *Simple) Versions(prefix string) (map[string][]FileVersion, error) {
+	versions, err := taggedVersions(filepath.Join(v.folderPath, ".stversions"), prefix)
+	if err != nil {
+		return nil, err
+	}
+	return fileVersions(versions), nil
+}
+
+// Restore replaces the named file with the given archived version.
+func (v *Simple) Restore(name string, versionTime time.Time) error {
+	versions, err := taggedVersions(filepath.Join(v.folderPath, ".stversions"), name)
+	if err != nil {
+		return err
+	}
+	path, err := findVersion(versions, name, versionTime)
+	if err != nil {
+		return err
+	}
+	return restoreVersion(v.folderPath, name, path, v.Archive)
+}
@@ -31,6 +31,7 @@ type Staggered struct {
 	cleanInterval int64
 	folderPath    string
 	interval      [4]Interval
+	retention     retention
 	mutex         sync.Mutex
 }
 
@@ -62,13 +63,9 @@ func NewStaggered(folderID, folderPath string, params map[string]string) Version
 		versionsPath:  versionsDir,
 		cleanInterval: cleanInterval,
 		folderPath:    folderPath,
-		interval: [4]Interval{
-			{30, 3600},       // first hour -> 30 sec between versions
-			{3600, 86400},    // next day -> 1 h between versions
-			{86400, 592000},  // next 30 days -> 1 day between versions
-			{604800, maxAge}, // next year -> 1 week between versions
-		},
-		mutex: sync.NewMutex(),
+		interval:      staggeredIntervals(maxAge),
+		retention:     newRetention(params),
+		mutex:         sync.NewMutex(),
 	}
 
 	if debug {
@@ -85,6 +82,15 @@ func NewStaggered(folderID, folderPath string, params map[string]string) Version
 	return s
 }
 
+func staggeredIntervals(maxAge int64) [4]Interval {
+	return [4]Interval{
+		{30, 3600},       // first hour -> 30 sec between versions
+		{3600, 86400},    // next day -> 1 h between versions
+		{86400, 592000},  // next 30 days -> 1 day between versions
+		{604800, maxAge}, // next year -> 1 week between versions
+	}
+}
+
 func (v Staggered) clean() {
 	if debug {
 		l.Debugln("Versioner clean: Waiting for lock on", v.versionsPath)
@@ -138,6 +144,16 @@ func (v Staggered) clean() {
 		v.expire(versionList)
 	}
 
+	if v.retention.active() {
+		versions, err := taggedVersions(v.versionsPath, "")
+		if err != nil {
+			l.Warnln("Versioner: error scanning versions dir", err)
+			return
+		}
+		v.retention.apply(versions, plainStats(versions).Size, removeVersion)
+		// Directories emptied by this are removed on the next cleaning.
+	}
+
 	for path, numFiles := range filesPerDir {
 		if numFiles > 0 {
 			continue
@@ -242,6 +258,10 @@ func (v Staggered) Archive(filePath string) error {
 	v.mutex.Lock()
 	defer v.mutex.Unlock()
 
+	return v.archive(filePath)
+}
+
+func (v Staggered) archive(filePath string) error {
 	_, err := osutil.Lstat(filePath)
 	if os.IsNotExist(err) {
 		if debug {
@@ -310,3 +330,45 @@ func (v Staggered) Archive(filePath string) error {
 
 	return nil
 }
+
+// ArchiveStats returns the number and size of the archived versions.
+func (v Staggered) ArchiveStats() (ArchiveStats, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	versions, err := taggedVersions(v.versionsPath, "")
+	if err != nil {
+		return ArchiveStats{}, err
+	}
+	return plainStats(versions), nil
+}
+
+// Versions returns the archived versions of the files whose names start with
+// the given prefix. The version time of a staggered version is the time it
+// was archived.
+func (v Staggered) Versions(prefix string) (map[string][]FileVersion, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	versions, err := taggedVersions(v.versionsPath, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return fileVersions(versions), nil
+}
+
+// Restore replaces the named file with the given archived version.
+func (v Staggered) Restore(name string, versionTime time.Time) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	versions, err := taggedVersions(v.versionsPath, name)
+	if err != nil {
+		return err
+	}
+	path, err := findVersion(versions, name, versionTime)
+	if err != nil {
+		return err
+	}
+	return restoreVersion(v.folderPath, name, path, v.archive)
+}
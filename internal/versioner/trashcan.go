@@ -24,6 +24,7 @@ func init() {
 type Trashcan struct {
 	folderPath   string
 	cleanoutDays int
+	retention    retention
 	stop         chan struct{}
 }
 
@@ -34,6 +35,7 @@ func NewTrashcan(folderID, folderPath string, params map[string]string) Versione
 	s := &Trashcan{
 		folderPath:   folderPath,
 		cleanoutDays: cleanoutDays,
+		retention:    newRetention(params),
 		stop:         make(chan struct{}),
 	}
 
@@ -122,6 +124,11 @@ func (t *Trashcan) Serve() {
 					l.Infoln("Cleaning trashcan:", err)
 				}
 			}
+			if t.retention.active() {
+				if err := t.applyRetention(); err != nil {
+					l.Infoln("Cleaning trashcan:", err)
+				}
+			}
 
 			// Cleanups once a day should be enough.
 			timer.Reset(24 * time.Hour)
@@ -185,3 +192,53 @@ func (t *Trashcan) cleanoutArchive() error {
 	}
 	return nil
 }
+
+func (t *Trashcan) applyRetention() error {
+	versions, err := t.versions("")
+	if err != nil {
+		return err
+	}
+	t.retention.apply(versions, plainStats(versions).Size, removeVersion)
+	removeEmptyDirs(filepath.Join(t.folderPath, ".stversions"))
+	return nil
+}
+
+// ArchiveStats returns the number and size of the files in the trash can.
+func (t *Trashcan) ArchiveStats() (ArchiveStats, error) {
+	versions, err := t.versions("")
+	if err != nil {
+		return ArchiveStats{}, err
+	}
+	return plainStats(versions), nil
+}
+
+// Versions returns the files in the trash can whose names start with the
+// given prefix. There is a single version of each file, timed by when it was
+// moved to the trash can.
+func (t *Trashcan) Versions(prefix string) (map[string][]FileVersion, error) {
+	versions, err := t.versions(prefix)
+	if err != nil {
+		return nil, err
+	}
+	return fileVersions(versions), nil
+}
+
+// Restore moves the named file back from the trash can.
+func (t *Trashcan) Restore(name string, versionTime time.Time) error {
+	versions, err := t.versions(name)
+	if err != nil {
+		return err
+	}
+	path, err := findVersion(versions, name, versionTime)
+	if err != nil {
+		return err
+	}
+	return restoreVersion(t.folderPath, name, path, t.Archive)
+}
+
+func (t *Trashcan) versions(prefix string) (map[string][]archivedVersion, error) {
+	return walkVersions(filepath.Join(t.folderPath, ".stversions"), prefix, func(path string, info os.FileInfo) (string, time.Time, bool) {
+		// Archive sets the modification time to the time of deletion.
+		return path, info.ModTime().Truncate(time.Second), true
+	})
+}
@@ -7,9 +7,14 @@
 package versioner
 
 import (
+	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strings"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/osutil"
 )
 
 // Inserts ~tag just before the extension of the filename.
@@ -46,3 +51,154 @@ func uniqueSortedStrings(strings []string) []string {
 	sort.Strings(unique)
 	return unique
 }
+
+// An archivedVersion is a file version and where it's kept.
+type archivedVersion struct {
+	FileVersion
+	path string
+}
+
+type versionsByTime []archivedVersion
+
+func (l versionsByTime) Len() int           { return len(l) }
+func (l versionsByTime) Less(a, b int) bool { return l[a].VersionTime.Before(l[b].VersionTime) }
+func (l versionsByTime) Swap(a, b int)      { l[a], l[b] = l[b], l[a] }
+
+// untaggedFilename returns the name of the file the tagged version is of,
+// whether the tag is at the end or before the extension.
+func untaggedFilename(path, tag string) string {
+	if strings.HasSuffix(path, "~"+tag) {
+		return path[:len(path)-len(tag)-1]
+	}
+	ext := filepath.Ext(path)
+	return path[:len(path)-len(ext)-len(tag)-1] + ext
+}
+
+// taggedVersions returns the versions in the versions directory that are
+// tagged with their version time, for the files whose names start with the
+// given prefix. The version time is taken from the tag. The versions are
+// keyed by name relative to the versions directory and sorted by time.
+func taggedVersions(versionsDir, prefix string) (map[string][]archivedVersion, error) {
+	return walkVersions(versionsDir, prefix, func(path string, info os.FileInfo) (string, time.Time, bool) {
+		tag := filenameTag(path)
+		if tag == "" {
+			return "", time.Time{}, false
+		}
+		versionTime, err := time.ParseInLocation(TimeFormat, tag, time.Local)
+		if err != nil {
+			return "", time.Time{}, false
+		}
+		return untaggedFilename(path, tag), versionTime, true
+	})
+}
+
+// walkVersions returns the versions in the versions directory, as named and
+// timed by the given function, for the files whose names start with the
+// given prefix.
+func walkVersions(versionsDir, prefix string, version func(path string, info os.FileInfo) (string, time.Time, bool)) (map[string][]archivedVersion, error) {
+	versions := make(map[string][]archivedVersion)
+	if _, err := osutil.Lstat(versionsDir); os.IsNotExist(err) {
+		return versions, nil
+	}
+
+	err := filepath.Walk(versionsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path == filepath.Join(versionsDir, dedupBlocksDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(path, ".restoring") {
+			return nil
+		}
+
+		name, versionTime, ok := version(path, info)
+		if !ok {
+			return nil
+		}
+		name, err = filepath.Rel(versionsDir, name)
+		if err != nil || !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+
+		versions[name] = append(versions[name], archivedVersion{
+			FileVersion: FileVersion{
+				VersionTime: versionTime,
+				ModTime:     info.ModTime(),
+				Size:        info.Size(),
+			},
+			path: path,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, vs := range versions {
+		sort.Sort(versionsByTime(vs))
+	}
+	return versions, nil
+}
+
+// fileVersions strips the archive paths from the versions.
+func fileVersions(versions map[string][]archivedVersion) map[string][]FileVersion {
+	res := make(map[string][]FileVersion, len(versions))
+	for name, vs := range versions {
+		fvs := make([]FileVersion, len(vs))
+		for i, v := range vs {
+			fvs[i] = v.FileVersion
+		}
+		res[name] = fvs
+	}
+	return res
+}
+
+// findVersion returns where the version of the named file from the given
+// time is kept.
+func findVersion(versions map[string][]archivedVersion, name string, versionTime time.Time) (string, error) {
+	for _, v := range versions[name] {
+		if v.VersionTime.Equal(versionTime) {
+			return v.path, nil
+		}
+	}
+	return "", ErrNoSuchVersion
+}
+
+// restoreVersion moves the archived version to the named file in the folder.
+// Until the version is in place it is kept under a temporary name in the
+// archive, so that archiving the current file can't clash with it or remove
+// it.
+func restoreVersion(folderPath, name, versionPath string, archive func(string) error) error {
+	tmp := versionPath + ".restoring"
+	if err := osutil.TryRename(versionPath, tmp); err != nil {
+		return err
+	}
+
+	if err := replaceFile(folderPath, name, tmp, archive); err != nil {
+		// Put the version back where it was.
+		osutil.TryRename(tmp, versionPath)
+		return err
+	}
+
+	if debug {
+		l.Debugln("restored", versionPath, "to", name)
+	}
+	return nil
+}
+
+// replaceFile moves the file at path to the named file in the folder. The
+// current file is first moved out of the way by archive.
+func replaceFile(folderPath, name, path string, archive func(string) error) error {
+	target := filepath.Join(folderPath, name)
+	if err := archive(target); err != nil {
+		return err
+	}
+	if err := osutil.MkdirAll(filepath.Dir(target), 0755); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return osutil.TryRename(path, target)
+}
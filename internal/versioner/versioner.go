@@ -8,10 +8,35 @@
 // simple default versioning scheme.
 package versioner
 
+import (
+	"errors"
+	"time"
+)
+
 type Versioner interface {
+	// Archive moves the file at the given path away to the archive.
 	Archive(filePath string) error
+	// Versions returns the archived versions of the files whose names, relative
+	// to the folder, start with the given prefix. They are keyed by file name
+	// and sorted from oldest to newest.
+	Versions(prefix string) (map[string][]FileVersion, error)
+	// Restore replaces the named file with the version archived at the given
+	// time. The current file, if any, is archived.
+	Restore(name string, versionTime time.Time) error
+}
+
+// A FileVersion is an archived version of a file.
+type FileVersion struct {
+	VersionTime time.Time `json:"versionTime"`
+	ModTime     time.Time `json:"modTime"`
+	Size        int64     `json:"size"`
 }
 
+var (
+	ErrRestoreNotSupported = errors.New("versioner does not support listing and restoring versions")
+	ErrNoSuchVersion       = errors.New("no such version")
+)
+
 var Factories = map[string]func(folderID string, folderDir string, params map[string]string) Versioner{}
 
 const (
@@ -88,3 +88,61 @@ func TestSimpleVersioningVersionCount(t *testing.T) {
 		time.Sleep(time.Second)
 	}
 }
+
+func TestRestoreVersion(t *testing.T) {
+	for _, typ := range []string{"simple", "staggered", "trashcan", "dedup"} {
+		dir, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		v := Factories[typ]("", dir, map[string]string{})
+		name := filepath.Join("dir", "file")
+		path := filepath.Join(dir, name)
+
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte("version one"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		old := time.Now().Add(-time.Hour)
+		os.Chtimes(path, old, old)
+		if err := v.Archive(path); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("v2"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		versions, err := v.Versions("dir")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(versions) != 1 || len(versions[name]) != 1 || versions[name][0].Size != int64(len("version one")) {
+			t.Fatalf("%s: unexpected versions %v", typ, versions)
+		}
+		if versions, _ := v.Versions("other"); len(versions) != 0 {
+			t.Errorf("%s: unexpected versions %v for other prefix", typ, versions)
+		}
+
+		if err := v.Restore(name, versions[name][0].VersionTime.Add(time.Second)); err != ErrNoSuchVersion {
+			t.Errorf("%s: unexpected error %v restoring nonexistent version", typ, err)
+		}
+		if err := v.Restore(name, versions[name][0].VersionTime); err != nil {
+			t.Fatal(err)
+		}
+
+		if bs, _ := ioutil.ReadFile(path); string(bs) != "version one" {
+			t.Errorf("%s: unexpected restored content %q", typ, bs)
+		}
+
+		// The replaced file is archived in turn.
+		versions, err = v.Versions("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(versions[name]) != 1 || versions[name][0].Size != int64(len("v2")) {
+			t.Errorf("%s: unexpected versions %v after restore", typ, versions)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build linux
+
+package watcher
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ATTRIB |
+	syscall.IN_CLOSE_WRITE | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF |
+	syscall.IN_DONT_FOLLOW | syscall.IN_ONLYDIR
+
+// notify watches dir recursively using inotify.
+func notify(dir string, events chan<- string, stop <-chan struct{}) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return os.NewSyscallError("inotify_init1", err)
+	}
+	fh := os.NewFile(uintptr(fd), "inotify")
+
+	in := &inotify{
+		fd:   fd,
+		dir:  dir,
+		dirs: make(map[int32]string),
+		wds:  make(map[string]int32),
+	}
+	if err := in.addTree("."); err != nil {
+		fh.Close()
+		return err
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- in.readEvents(fh, events, stop)
+	}()
+
+	select {
+	case <-stop:
+		// Closing the file makes the pending read in readEvents return.
+		fh.Close()
+		<-errc
+		return nil
+	case err := <-errc:
+		fh.Close()
+		return err
+	}
+}
+
+type inotify struct {
+	fd   int
+	dir  string
+	dirs map[int32]string // watch descriptor -> relative directory
+	wds  map[string]int32 // relative directory -> watch descriptor
+}
+
+// addTree adds watches for the relative directory rn and all directories
+// below it.
+func (in *inotify) addTree(rn string) error {
+	return filepath.Walk(filepath.Join(in.dir, rn), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// The directory may have gone away already.
+			return nil
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(in.dir, path)
+		if err != nil {
+			return nil
+		}
+		wd, err := syscall.InotifyAddWatch(in.fd, path, inotifyMask)
+		if err == syscall.ENOENT {
+			return nil
+		}
+		if err != nil {
+			return os.NewSyscallError("inotify_add_watch", err)
+		}
+		in.dirs[int32(wd)] = rel
+		in.wds[rel] = int32(wd)
+		return nil
+	})
+}
+
+func (in *inotify) readEvents(fh *os.File, events chan<- string, stop <-chan struct{}) error {
+	var buf [syscall.SizeofInotifyEvent * 4096]byte
+	for {
+		n, err := fh.Read(buf[:])
+		if err != nil {
+			select {
+			case <-stop:
+				return nil
+			default:
+				return err
+			}
+		}
+
+		var changed []string
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(ev.Len)]
+			offset += syscall.SizeofInotifyEvent + int(ev.Len)
+
+			if ev.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				if debug {
+					l.Debugln("inotify queue overflow in", in.dir)
+				}
+				changed = append(changed, "")
+				continue
+			}
+
+			parent, ok := in.dirs[ev.Wd]
+			if !ok {
+				continue
+			}
+			if ev.Mask&syscall.IN_IGNORED != 0 {
+				delete(in.dirs, ev.Wd)
+				if in.wds[parent] == ev.Wd {
+					delete(in.wds, parent)
+				}
+				continue
+			}
+
+			var rn string
+			if i := bytes.IndexByte(nameBytes, 0); i >= 0 {
+				nameBytes = nameBytes[:i]
+			}
+			if len(nameBytes) == 0 {
+				// Event on the watched directory itself.
+				rn = parent
+			} else {
+				rn = filepath.Join(parent, string(nameBytes))
+			}
+
+			if ev.Mask&syscall.IN_ISDIR != 0 && ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+				if err := in.addTree(rn); err != nil {
+					return err
+				}
+			}
+
+			changed = append(changed, rn)
+		}
+
+		for _, rn := range changed {
+			select {
+			case events <- rn:
+			case <-stop:
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build linux
+
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInotifyNewDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	events := make(chan string, 16)
+	stop := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() {
+		errc <- notify(dir, events, stop)
+	}()
+	// Give the watcher a moment to set up its initial watches.
+	time.Sleep(100 * time.Millisecond)
+
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, events, "sub")
+
+	// The new directory should have been watched as well.
+	if err := ioutil.WriteFile(filepath.Join(dir, "sub", "file"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, events, filepath.Join("sub", "file"))
+
+	close(stop)
+	if err := <-errc; err != nil {
+		t.Error(err)
+	}
+}
+
+func waitFor(t *testing.T, events <-chan string, name string) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case rn := <-events:
+			if rn == name {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Timeout waiting for change to %q", name)
+		}
+	}
+}
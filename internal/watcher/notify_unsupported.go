@@ -0,0 +1,15 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build !linux
+
+package watcher
+
+// notify is not implemented on this platform; the watcher falls back to
+// polling.
+func notify(dir string, events chan<- string, stop <-chan struct{}) error {
+	return errUnsupported
+}
@@ -0,0 +1,117 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// minPollInterval is the shortest interval at which we walk the folder
+// when polling for changes.
+const minPollInterval = 5 * time.Second
+
+type fileState struct {
+	size    int64
+	modTime int64
+	mode    os.FileMode
+}
+
+// pollerFor returns a notifyFunc that detects changes by walking the tree at
+// the given interval and comparing size, modification time and mode of all
+// files to what was seen the previous time around.
+func pollerFor(interval time.Duration) notifyFunc {
+	if interval < minPollInterval {
+		interval = minPollInterval
+	}
+	return func(dir string, events chan<- string, stop <-chan struct{}) error {
+		return poll(dir, interval, events, stop)
+	}
+}
+
+func poll(dir string, interval time.Duration, events chan<- string, stop <-chan struct{}) error {
+	prev, err := snapshot(dir)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+
+		cur, err := snapshot(dir)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range diffSnapshots(prev, cur) {
+			select {
+			case events <- name:
+			case <-stop:
+				return nil
+			}
+		}
+		prev = cur
+	}
+}
+
+func snapshot(dir string) (map[string]fileState, error) {
+	if _, err := os.Lstat(dir); err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]fileState)
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Unreadable parts of the tree are left for the scanner to
+			// complain about.
+			return nil
+		}
+		rn, err := filepath.Rel(dir, path)
+		if err != nil || rn == "." {
+			return nil
+		}
+		if info.IsDir() {
+			// A directory's size and modification time change whenever
+			// its contents do; we'll see those changes on the children
+			// themselves.
+			res[rn] = fileState{mode: info.Mode()}
+			return nil
+		}
+		res[rn] = fileState{
+			size:    info.Size(),
+			modTime: info.ModTime().UnixNano(),
+			mode:    info.Mode(),
+		}
+		return nil
+	})
+	return res, nil
+}
+
+// diffSnapshots returns the names of all files that were added, removed or
+// changed between the two snapshots.
+func diffSnapshots(prev, cur map[string]fileState) []string {
+	var changed []string
+	for name, cs := range cur {
+		if ps, ok := prev[name]; !ok || ps != cs {
+			changed = append(changed, name)
+		}
+	}
+	for name := range prev {
+		if _, ok := cur[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
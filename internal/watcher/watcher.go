@@ -0,0 +1,272 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package watcher detects changes in a folder tree, either using the
+// operating system notification facilities or by polling, and aggregates them
+// into batches of changed paths suitable for a partial rescan.
+package watcher
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/events"
+)
+
+const (
+	StateStopped = "stopped"
+	StateRunning = "running"
+	StatePolling = "polling"
+	StateFailed  = "failed"
+)
+
+// maxPaths is the number of distinct changed paths we track before
+// collapsing them into their parent directories. If we still have more than
+// this after collapsing, the batch turns into a full rescan.
+const maxPaths = 1000
+
+// maxDelayFactor limits how long a batch is held back while changes keep
+// coming in, relative to the configured delay.
+const maxDelayFactor = 4
+
+var errUnsupported = errors.New("filesystem notifications not supported on this platform")
+
+// A notifyFunc reports paths relative to dir that have changed on the
+// events channel until stop is closed or an error occurs. An empty path means
+// that changes may have been lost and the whole tree should be rescanned.
+type notifyFunc func(dir string, events chan<- string, stop <-chan struct{}) error
+
+// Watcher watches a folder and sends batches of changed paths on the channel
+// returned by C. A nil batch means the whole folder should be rescanned.
+type Watcher struct {
+	folder string
+	dir    string
+	delay  time.Duration
+	filter func(string) bool
+	notify notifyFunc
+	poll   notifyFunc
+	out    chan []string
+	stop   chan struct{}
+
+	mut   sync.Mutex
+	state string
+	err   error
+}
+
+// New returns a new Watcher for the folder with the given ID and path.
+// Changes are held back for delay after the first change is seen, so that
+// bursts of changes result in a single batch. The filter, if not nil, is
+// called with the relative path of each change and should return false for
+// paths that are not interesting.
+func New(folder, dir string, delay time.Duration, filter func(string) bool) *Watcher {
+	return &Watcher{
+		folder: folder,
+		dir:    dir,
+		delay:  delay,
+		filter: filter,
+		notify: notify,
+		poll:   pollerFor(delay),
+		out:    make(chan []string),
+		stop:   make(chan struct{}),
+		state:  StateStopped,
+	}
+}
+
+// C returns the channel on which change batches are delivered.
+func (w *Watcher) C() <-chan []string {
+	return w.out
+}
+
+// State returns the current watcher state and the error that caused it, if
+// any.
+func (w *Watcher) State() (string, error) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	return w.state, w.err
+}
+
+func (w *Watcher) Serve() {
+	if debug {
+		l.Debugln(w, "starting")
+		defer l.Debugln(w, "exiting")
+	}
+
+	changes := make(chan string, maxPaths)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- w.notify(w.dir, changes, w.stop)
+	}()
+	w.setState(StateRunning, nil)
+
+	b := newBatch()
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	var first time.Time
+	var out chan []string
+	var ready []string
+
+	for {
+		select {
+		case <-w.stop:
+			timer.Stop()
+			w.setState(StateStopped, nil)
+			return
+
+		case err := <-errc:
+			if err == nil {
+				// The backend only returns nil when asked to stop
+				continue
+			}
+			if state, _ := w.State(); state == StatePolling {
+				l.Warnf("Watcher for folder %q: %v", w.folder, err)
+				w.setState(StateFailed, err)
+				continue
+			}
+			if err != errUnsupported {
+				l.Infof("Watcher for folder %q: %v; falling back to polling", w.folder, err)
+			}
+			go func() {
+				errc <- w.poll(w.dir, changes, w.stop)
+			}()
+			w.setState(StatePolling, err)
+
+		case p := <-changes:
+			if p != "" && w.filter != nil && !w.filter(p) {
+				continue
+			}
+			if debug {
+				l.Debugf("%v change: %q", w, p)
+			}
+			b.add(p)
+			if out != nil {
+				// A batch is already waiting to be picked up; include this
+				// change in it.
+				ready = b.paths()
+				continue
+			}
+			now := time.Now()
+			if first.IsZero() {
+				first = now
+			}
+			delay := w.delay
+			if max := first.Add(maxDelayFactor * w.delay).Sub(now); max < delay {
+				delay = max
+			}
+			timer.Reset(delay)
+
+		case <-timer.C:
+			ready = b.paths()
+			out = w.out
+
+		case out <- ready:
+			if debug {
+				l.Debugf("%v sent batch of %d paths", w, len(ready))
+			}
+			b = newBatch()
+			first = time.Time{}
+			out = nil
+			ready = nil
+		}
+	}
+}
+
+func (w *Watcher) Stop() {
+	close(w.stop)
+}
+
+func (w *Watcher) String() string {
+	return "watcher/" + w.folder
+}
+
+func (w *Watcher) setState(state string, err error) {
+	w.mut.Lock()
+	if state == w.state && err == w.err {
+		w.mut.Unlock()
+		return
+	}
+	from := w.state
+	w.state = state
+	w.err = err
+	w.mut.Unlock()
+
+	events.Default.Log(events.FolderWatchStateChanged, map[string]interface{}{
+		"folder": w.folder,
+		"from":   from,
+		"to":     state,
+		"error":  events.Error(err),
+	})
+}
+
+// A batch is a set of changed paths. A batch marked as full means the whole
+// folder needs rescanning.
+type batch struct {
+	full  bool
+	names map[string]struct{}
+}
+
+func newBatch() *batch {
+	return &batch{names: make(map[string]struct{})}
+}
+
+func (b *batch) add(name string) {
+	if b.full {
+		return
+	}
+	name = filepath.Clean(name)
+	if name == "" || name == "." {
+		b.full = true
+		b.names = nil
+		return
+	}
+	b.names[name] = struct{}{}
+	if len(b.names) > maxPaths {
+		b.collapse()
+	}
+}
+
+// collapse replaces all changed paths with their parent directories. If that
+// doesn't bring the number of paths under the limit, the batch becomes a
+// full rescan.
+func (b *batch) collapse() {
+	parents := make(map[string]struct{}, len(b.names))
+	for name := range b.names {
+		parent := filepath.Dir(name)
+		if parent == "." {
+			b.full = true
+			b.names = nil
+			return
+		}
+		parents[parent] = struct{}{}
+	}
+	b.names = parents
+	if len(b.names) > maxPaths {
+		b.full = true
+		b.names = nil
+	}
+}
+
+// paths returns the sorted list of changed paths, with paths that are below
+// another changed path removed. It returns nil for a full rescan.
+func (b *batch) paths() []string {
+	if b.full || len(b.names) == 0 {
+		return nil
+	}
+	var res []string
+nextName:
+	for name := range b.names {
+		for parent := filepath.Dir(name); parent != "." && parent != string(filepath.Separator); parent = filepath.Dir(parent) {
+			if _, ok := b.names[parent]; ok {
+				continue nextName
+			}
+		}
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,172 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package watcher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestBatchPaths(t *testing.T) {
+	b := newBatch()
+	for _, name := range []string{"a/b/c", "a/b", "d", "a-b/c", "e/f/g", "d/x"} {
+		b.add(filepath.FromSlash(name))
+	}
+
+	expected := []string{"a-b/c", "a/b", "d", "e/f/g"}
+	for i := range expected {
+		expected[i] = filepath.FromSlash(expected[i])
+	}
+	if paths := b.paths(); !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Incorrect paths %v != expected %v", paths, expected)
+	}
+}
+
+func TestBatchFull(t *testing.T) {
+	b := newBatch()
+	b.add("a")
+	b.add("")
+	b.add("b")
+	if paths := b.paths(); paths != nil {
+		t.Errorf("Expected full rescan, got %v", paths)
+	}
+
+	b = newBatch()
+	b.add("a")
+	b.add(".")
+	if paths := b.paths(); paths != nil {
+		t.Errorf("Expected full rescan, got %v", paths)
+	}
+}
+
+func TestBatchCollapse(t *testing.T) {
+	b := newBatch()
+	for i := 0; i <= maxPaths; i++ {
+		b.add(filepath.Join("dir", fmt.Sprintf("file%d", i)))
+	}
+	expected := []string{"dir"}
+	if paths := b.paths(); !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Incorrect paths %v != expected %v", paths, expected)
+	}
+
+	b = newBatch()
+	for i := 0; i <= maxPaths; i++ {
+		b.add(fmt.Sprintf("file%d", i))
+	}
+	if paths := b.paths(); paths != nil {
+		t.Errorf("Expected full rescan, got %v", paths)
+	}
+}
+
+func TestWatcherAggregates(t *testing.T) {
+	changes := make(chan string)
+	w := New("default", "testdata", 50*time.Millisecond, func(rn string) bool {
+		return rn != "ignored"
+	})
+	w.notify = func(dir string, events chan<- string, stop <-chan struct{}) error {
+		for {
+			select {
+			case rn := <-changes:
+				events <- rn
+			case <-stop:
+				return nil
+			}
+		}
+	}
+	go w.Serve()
+	defer w.Stop()
+
+	changes <- "b"
+	changes <- "ignored"
+	changes <- "a"
+	changes <- "b"
+
+	select {
+	case paths := <-w.C():
+		expected := []string{"a", "b"}
+		if !reflect.DeepEqual(paths, expected) {
+			t.Errorf("Incorrect batch %v != expected %v", paths, expected)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for batch")
+	}
+
+	if state, err := w.State(); state != StateRunning || err != nil {
+		t.Errorf("Unexpected state %q, %v", state, err)
+	}
+}
+
+func TestWatcherFallsBackToPolling(t *testing.T) {
+	polling := make(chan struct{})
+	w := New("default", "testdata", time.Second, nil)
+	w.notify = func(dir string, events chan<- string, stop <-chan struct{}) error {
+		return errUnsupported
+	}
+	w.poll = func(dir string, events chan<- string, stop <-chan struct{}) error {
+		close(polling)
+		<-stop
+		return nil
+	}
+	go w.Serve()
+	defer w.Stop()
+
+	select {
+	case <-polling:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for poller to start")
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, data string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("unchanged", "data")
+	write("changed", "data")
+	write("removed", "data")
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	prev, err := snapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write("changed", "more data")
+	write(filepath.Join("sub", "added"), "data")
+	if err := os.Remove(filepath.Join(dir, "removed")); err != nil {
+		t.Fatal(err)
+	}
+
+	cur, err := snapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed := diffSnapshots(prev, cur)
+	sort.Strings(changed)
+	expected := []string{"changed", "removed", filepath.Join("sub", "added")}
+	if !reflect.DeepEqual(changed, expected) {
+		t.Errorf("Incorrect changes %v != expected %v", changed, expected)
+	}
+}
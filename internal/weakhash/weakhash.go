@@ -0,0 +1,90 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package weakhash implements the rolling checksum used by rsync. The
+// checksum of a block sized window can be updated in constant time as the
+// window moves through a file one byte at a time, which makes it possible to
+// find blocks that have moved to arbitrary offsets.
+package weakhash
+
+import (
+	"bufio"
+	"io"
+)
+
+// MaxHits is the maximum number of offsets Find records for each hash.
+const MaxHits = 10
+
+// Block returns the weak hash of the given data.
+func Block(data []byte) uint32 {
+	var a, b uint32
+	n := uint32(len(data))
+	for i, c := range data {
+		a += uint32(c)
+		b += (n - uint32(i)) * uint32(c)
+	}
+	return a&0xffff | b<<16
+}
+
+// Find reads r and returns, for each of the given hashes, the offsets at
+// which a window of size bytes with that weak hash starts. At most MaxHits
+// offsets are recorded per hash. Matches are only candidates; the data at
+// each offset must be verified using a strong hash before use.
+func Find(r io.Reader, hashes []uint32, size int) (map[uint32][]int64, error) {
+	if len(hashes) == 0 || size <= 0 {
+		return nil, nil
+	}
+
+	wanted := make(map[uint32]struct{}, len(hashes))
+	for _, h := range hashes {
+		wanted[h] = struct{}{}
+	}
+
+	br := bufio.NewReader(r)
+	window := make([]byte, size)
+	n, err := io.ReadFull(br, window)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// File is shorter than one block; nothing can match.
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var a, b uint32
+	for i, c := range window[:n] {
+		a += uint32(c)
+		b += uint32(size-i) * uint32(c)
+	}
+
+	hits := make(map[uint32][]int64)
+	record := func(offset int64) {
+		h := a&0xffff | b<<16
+		if _, ok := wanted[h]; ok && len(hits[h]) < MaxHits {
+			hits[h] = append(hits[h], offset)
+		}
+	}
+
+	var offset int64
+	record(offset)
+	for pos := 0; ; pos = (pos + 1) % size {
+		c, err := br.ReadByte()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		// Roll the window one byte forward; window[pos] is the oldest byte.
+		out := uint32(window[pos])
+		a += uint32(c) - out
+		b += a - uint32(size)*out
+		window[pos] = c
+		offset++
+		record(offset)
+	}
+
+	return hits, nil
+}
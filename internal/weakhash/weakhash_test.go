@@ -0,0 +1,73 @@
+// Copyright (C) 2015 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package weakhash
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestRollingMatchesBlock(t *testing.T) {
+	data := make([]byte, 4096)
+	rand.New(rand.NewSource(42)).Read(data)
+
+	const size = 64
+	for _, offset := range []int{0, 1, 17, 1000, len(data) - size} {
+		h := Block(data[offset : offset+size])
+		hits, err := Find(bytes.NewReader(data), []uint32{h}, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, o := range hits[h] {
+			if o == int64(offset) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Offset %d not found among hits %v", offset, hits[h])
+		}
+	}
+}
+
+func TestFindShiftedBlocks(t *testing.T) {
+	old := make([]byte, 1024)
+	rand.New(rand.NewSource(1)).Read(old)
+
+	// Insert a few bytes at the start; every block of the old data is now
+	// three bytes further in.
+	cur := append([]byte{1, 2, 3}, old...)
+
+	const size = 128
+	var hashes []uint32
+	for i := 0; i+size <= len(old); i += size {
+		hashes = append(hashes, Block(old[i:i+size]))
+	}
+
+	hits, err := Find(bytes.NewReader(cur), hashes, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, h := range hashes {
+		expected := []int64{int64(i*size + 3)}
+		if !reflect.DeepEqual(hits[h], expected) {
+			t.Errorf("Block %d: hits %v != expected %v", i, hits[h], expected)
+		}
+	}
+}
+
+func TestFindShortFile(t *testing.T) {
+	hits, err := Find(bytes.NewReader([]byte("abc")), []uint32{Block([]byte("abc"))}, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 0 {
+		t.Errorf("Unexpected hits %v", hits)
+	}
+}